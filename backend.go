@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"bessarabov/mac2mqtt/llm"
+	"bessarabov/mac2mqtt/macos"
+
+	sigar "github.com/cloudfoundry/gosigar"
+)
+
+// Backend is the source of all host data and the target of all host commands.
+// The default implementation talks to macOS; the simulator replaces it with
+// synthetic data so the rest of the pipeline can run anywhere.
+type Backend interface {
+	// Device information
+	SerialNumber() string
+	Model() string
+
+	// Audio
+	GetVolume() int
+	SetVolume(volume int) error
+	GetMuteStatus() bool
+	SetMute(mute bool) error
+
+	// System commands
+	Sleep()
+	DisplaySleep()
+	DisplayWake()
+	Shutdown()
+	Screensaver()
+	KeepAwake()
+	AllowSleep()
+	GetCaffeinateStatus() bool
+	RunShortcut(shortcut string)
+
+	// Displays
+	IsBetterDisplayCLIAvailable() bool
+	GetDisplays() []macos.Display
+	GetDisplayBrightness(displayID string) (int, error)
+	SetDisplayBrightness(displayID string, brightness int) error
+
+	// Media
+	IsMediaControlAvailable() bool
+	GetMediaInfo() (*macos.MediaInfo, error)
+	StartMediaStream() (io.ReadCloser, error) // newline-delimited media-control stream JSON
+	PlayPause()
+	Play() error
+	Pause() error
+	NextTrack() error
+	PreviousTrack() error
+	StopMedia() error
+	SeekMedia(position float64) error // position in seconds
+	SetShuffleMode(mode string) error // off, albums or tracks
+	SetRepeatMode(mode string) error  // off, track or playlist
+
+	// Monitoring
+	GetBatteryChargePercent() string
+	GetSystemIdleTime() (int, error)
+	GetDiskUsage() (*macos.DiskUsage, error)
+	GetCPUUsage() (*macos.CPUUsage, error)
+	GetMemoryUsage() (*macos.MemoryUsage, error)
+	GetSystemUptime() (*macos.UptimeInfo, error)
+	GetTemperatures() (*macos.TemperatureInfo, error)
+	GetNetworkStats(lastStats *macos.NetworkStats, interval time.Duration) (*macos.NetworkStats, error)
+	GetMediaDevicesState() (isMicOn bool, isCameraOn bool, err error)
+	GetPublicIP() (string, error)
+
+	// Local LLM server (LM Studio, Ollama, llama.cpp) of the given kind at apiURL
+	NewLLM(kind, apiURL string) (llm.Backend, error)
+}
+
+// macosBackend implements Backend with the macos package
+type macosBackend struct {
+	cpuMutex sync.Mutex
+	lastCPU  *sigar.Cpu // for CPU percentage calculation
+}
+
+func newMacOSBackend() *macosBackend {
+	b := &macosBackend{}
+	// Initialize CPU stats so the first reading is a real delta
+	if _, cpu, err := macos.GetCPUUsage(nil); err == nil {
+		b.lastCPU = cpu
+	} else {
+		systemLog.Warn("Failed to initialize CPU stats", "err", err)
+	}
+	return b
+}
+
+func (b *macosBackend) SerialNumber() string { return macos.GetSerialnumber() }
+func (b *macosBackend) Model() string        { return macos.GetModel() }
+
+func (b *macosBackend) GetVolume() int                    { return macos.GetVolume() }
+func (b *macosBackend) SetVolume(volume int) error        { return macos.SetVolume(volume) }
+func (b *macosBackend) GetMuteStatus() bool               { return macos.GetMuteStatus() }
+func (b *macosBackend) SetMute(mute bool) error           { return macos.SetMute(mute) }
+func (b *macosBackend) Sleep()                            { macos.Sleep() }
+func (b *macosBackend) DisplaySleep()                     { macos.DisplaySleep() }
+func (b *macosBackend) DisplayWake()                      { macos.DisplayWake() }
+func (b *macosBackend) Shutdown()                         { macos.Shutdown() }
+func (b *macosBackend) Screensaver()                      { macos.Screensaver() }
+func (b *macosBackend) KeepAwake()                        { macos.KeepAwake() }
+func (b *macosBackend) AllowSleep()                       { macos.AllowSleep() }
+func (b *macosBackend) GetCaffeinateStatus() bool         { return macos.GetCaffeinateStatus() }
+func (b *macosBackend) RunShortcut(shortcut string)       { macos.RunShortcut(shortcut) }
+func (b *macosBackend) IsBetterDisplayCLIAvailable() bool { return macos.IsBetterDisplayCLIAvailable() }
+func (b *macosBackend) GetDisplays() []macos.Display      { return macos.GetDisplays() }
+
+func (b *macosBackend) GetDisplayBrightness(displayID string) (int, error) {
+	return macos.GetDisplayBrightness(displayID)
+}
+
+func (b *macosBackend) SetDisplayBrightness(displayID string, brightness int) error {
+	return macos.SetDisplayBrightness(displayID, brightness)
+}
+
+func (b *macosBackend) IsMediaControlAvailable() bool           { return macos.IsMediaControlAvailable() }
+func (b *macosBackend) GetMediaInfo() (*macos.MediaInfo, error) { return macos.GetMediaInfo() }
+func (b *macosBackend) PlayPause()                              { macos.PlayPause() }
+func (b *macosBackend) Play() error                             { return macos.Play() }
+func (b *macosBackend) Pause() error                            { return macos.Pause() }
+func (b *macosBackend) NextTrack() error                        { return macos.NextTrack() }
+func (b *macosBackend) PreviousTrack() error                    { return macos.PreviousTrack() }
+func (b *macosBackend) StopMedia() error                        { return macos.StopMedia() }
+func (b *macosBackend) SeekMedia(position float64) error        { return macos.SeekMedia(position) }
+func (b *macosBackend) SetShuffleMode(mode string) error        { return macos.SetShuffleMode(mode) }
+func (b *macosBackend) SetRepeatMode(mode string) error         { return macos.SetRepeatMode(mode) }
+
+// StartMediaStream starts `media-control stream`. Closing the returned reader
+// stops the process.
+func (b *macosBackend) StartMediaStream() (io.ReadCloser, error) {
+	cmd := exec.Command("media-control", "stream")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (b *macosBackend) GetBatteryChargePercent() string             { return macos.GetBatteryChargePercent() }
+func (b *macosBackend) GetSystemIdleTime() (int, error)             { return macos.GetSystemIdleTime() }
+func (b *macosBackend) GetDiskUsage() (*macos.DiskUsage, error)     { return macos.GetDiskUsage() }
+func (b *macosBackend) GetMemoryUsage() (*macos.MemoryUsage, error) { return macos.GetMemoryUsage() }
+func (b *macosBackend) GetSystemUptime() (*macos.UptimeInfo, error) { return macos.GetSystemUptime() }
+func (b *macosBackend) GetTemperatures() (*macos.TemperatureInfo, error) {
+	return macos.GetTemperatures()
+}
+func (b *macosBackend) GetPublicIP() (string, error) { return macos.GetPublicIP() }
+
+func (b *macosBackend) GetCPUUsage() (*macos.CPUUsage, error) {
+	b.cpuMutex.Lock()
+	defer b.cpuMutex.Unlock()
+
+	usage, cpu, err := macos.GetCPUUsage(b.lastCPU)
+	if err != nil {
+		return nil, err
+	}
+	// Store current CPU stats for next calculation
+	b.lastCPU = cpu
+	return usage, nil
+}
+
+func (b *macosBackend) GetNetworkStats(lastStats *macos.NetworkStats, interval time.Duration) (*macos.NetworkStats, error) {
+	return macos.GetNetworkStats(lastStats, interval)
+}
+
+func (b *macosBackend) GetMediaDevicesState() (bool, bool, error) {
+	return macos.GetMediaDevicesState()
+}
+
+func (b *macosBackend) NewLLM(kind, apiURL string) (llm.Backend, error) {
+	return llm.New(kind, apiURL)
+}
+
+// commandReader wraps a command's stdout and reaps the process on Close
+type commandReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *commandReader) Close() error {
+	if r.cmd.Process != nil {
+		r.cmd.Process.Kill()
+	}
+	err := r.ReadCloser.Close()
+	r.cmd.Wait()
+	return err
+}
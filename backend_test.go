@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"bessarabov/mac2mqtt/llm"
+	"bessarabov/mac2mqtt/macos"
+)
+
+// fakeBackend is a Backend with fixed, settable data that records every command it receives
+type fakeBackend struct {
+	mu         sync.Mutex
+	calls      []string
+	volume     int
+	muted      bool
+	caffeinate bool
+	idleTime   int
+	displays   []macos.Display
+	brightness map[string]int
+	media      *macos.MediaInfo
+	lmsRunning bool
+	models     []llm.Model
+	streams    []*io.PipeWriter
+	downloads  chan struct{} // if set, downloads wait for it after reporting 50%
+	loads      chan struct{} // if set, model loads wait for it after reporting 40%
+	loadErr    error         // if set, model loads fail with it
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		volume:     40,
+		idleTime:   100,
+		displays:   []macos.Display{{DisplayID: "1", Name: "Built-in Display"}},
+		brightness: map[string]int{"1": 70},
+		lmsRunning: true,
+		models: []llm.Model{
+			{ID: "qwen/qwen3-8b", Type: "llm", State: "loaded"},
+			{ID: "google/gemma-3-12b", Type: "llm", State: "not-loaded"},
+		},
+	}
+}
+
+// record notes a call like SetVolume(55)
+func (f *fakeBackend) record(name string, args ...interface{}) {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = fmt.Sprint(a)
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, name+"("+strings.Join(parts, ",")+")")
+	f.mu.Unlock()
+}
+
+// called reports whether call (e.g. "SetVolume(55)") was recorded
+func (f *fakeBackend) called(call string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+// waitCalled polls until call was recorded or the timeout expires
+func (f *fakeBackend) waitCalled(call string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if f.called(call) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return f.called(call)
+}
+
+// emitMedia writes a media-control stream diff to every open media stream
+func (f *fakeBackend) emitMedia(payload map[string]interface{}) {
+	f.emitMediaEvent(true, payload)
+}
+
+// emitMediaSnapshot sends a complete now playing item, like media-control does at start
+func (f *fakeBackend) emitMediaSnapshot(payload map[string]interface{}) {
+	f.emitMediaEvent(false, payload)
+}
+
+func (f *fakeBackend) emitMediaEvent(diff bool, payload map[string]interface{}) {
+	line, _ := json.Marshal(map[string]interface{}{"type": "data", "diff": diff, "payload": payload})
+	f.mu.Lock()
+	streams := append([]*io.PipeWriter(nil), f.streams...)
+	f.mu.Unlock()
+	for _, w := range streams {
+		w.Write(append(line, '\n'))
+	}
+}
+
+func (f *fakeBackend) SerialNumber() string { return "FAKESERIAL" }
+func (f *fakeBackend) Model() string        { return "Fake Mac" }
+
+func (f *fakeBackend) GetVolume() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.volume
+}
+
+func (f *fakeBackend) SetVolume(volume int) error {
+	f.record("SetVolume", volume)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.volume = volume
+	return nil
+}
+
+func (f *fakeBackend) GetMuteStatus() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.muted
+}
+
+func (f *fakeBackend) SetMute(mute bool) error {
+	f.record("SetMute", mute)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.muted = mute
+	return nil
+}
+
+func (f *fakeBackend) Sleep()                      { f.record("Sleep") }
+func (f *fakeBackend) DisplaySleep()               { f.record("DisplaySleep") }
+func (f *fakeBackend) DisplayWake()                { f.record("DisplayWake") }
+func (f *fakeBackend) Shutdown()                   { f.record("Shutdown") }
+func (f *fakeBackend) Screensaver()                { f.record("Screensaver") }
+func (f *fakeBackend) RunShortcut(shortcut string) { f.record("RunShortcut", shortcut) }
+
+func (f *fakeBackend) KeepAwake() {
+	f.record("KeepAwake")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.caffeinate = true
+}
+
+func (f *fakeBackend) AllowSleep() {
+	f.record("AllowSleep")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.caffeinate = false
+}
+
+func (f *fakeBackend) GetCaffeinateStatus() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.caffeinate
+}
+
+func (f *fakeBackend) IsBetterDisplayCLIAvailable() bool { return true }
+
+func (f *fakeBackend) GetDisplays() []macos.Display {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]macos.Display(nil), f.displays...)
+}
+
+func (f *fakeBackend) GetDisplayBrightness(displayID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.brightness[displayID], nil
+}
+
+func (f *fakeBackend) SetDisplayBrightness(displayID string, brightness int) error {
+	f.record("SetDisplayBrightness", displayID, brightness)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.brightness[displayID] = brightness
+	return nil
+}
+
+func (f *fakeBackend) IsMediaControlAvailable() bool { return true }
+
+func (f *fakeBackend) GetMediaInfo() (*macos.MediaInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.media == nil {
+		return nil, nil
+	}
+	info := *f.media
+	return &info, nil
+}
+
+func (f *fakeBackend) StartMediaStream() (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	f.mu.Lock()
+	f.streams = append(f.streams, w)
+	f.mu.Unlock()
+	return r, nil
+}
+
+func (f *fakeBackend) PlayPause()                       { f.record("PlayPause") }
+func (f *fakeBackend) Play() error                      { f.record("Play"); return nil }
+func (f *fakeBackend) Pause() error                     { f.record("Pause"); return nil }
+func (f *fakeBackend) NextTrack() error                 { f.record("NextTrack"); return nil }
+func (f *fakeBackend) PreviousTrack() error             { f.record("PreviousTrack"); return nil }
+func (f *fakeBackend) StopMedia() error                 { f.record("StopMedia"); return nil }
+func (f *fakeBackend) SeekMedia(position float64) error { f.record("SeekMedia", position); return nil }
+func (f *fakeBackend) SetShuffleMode(mode string) error { f.record("SetShuffleMode", mode); return nil }
+func (f *fakeBackend) SetRepeatMode(mode string) error  { f.record("SetRepeatMode", mode); return nil }
+
+func (f *fakeBackend) GetBatteryChargePercent() string { return "87" }
+
+func (f *fakeBackend) GetSystemIdleTime() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.idleTime, nil
+}
+
+func (f *fakeBackend) GetDiskUsage() (*macos.DiskUsage, error) {
+	return &macos.DiskUsage{Total: 1000, Used: 250, Free: 750, UsedPercent: 25, FreePercent: 75}, nil
+}
+
+func (f *fakeBackend) GetCPUUsage() (*macos.CPUUsage, error) {
+	return &macos.CPUUsage{UsedPercent: 12.5, FreePercent: 87.5}, nil
+}
+
+func (f *fakeBackend) GetMemoryUsage() (*macos.MemoryUsage, error) {
+	return &macos.MemoryUsage{Total: 1000, Used: 500, Free: 500, UsedPercent: 50, FreePercent: 50}, nil
+}
+
+func (f *fakeBackend) GetSystemUptime() (*macos.UptimeInfo, error) {
+	return &macos.UptimeInfo{Seconds: 3600, Human: "0 days, 1:00"}, nil
+}
+
+func (f *fakeBackend) GetTemperatures() (*macos.TemperatureInfo, error) {
+	return &macos.TemperatureInfo{CPU: 45, GPU: 40}, nil
+}
+
+func (f *fakeBackend) GetNetworkStats(*macos.NetworkStats, time.Duration) (*macos.NetworkStats, error) {
+	return &macos.NetworkStats{}, nil
+}
+
+func (f *fakeBackend) GetMediaDevicesState() (bool, bool, error) { return false, false, nil }
+func (f *fakeBackend) GetPublicIP() (string, error)              { return "203.0.113.1", nil }
+
+// NewLLM returns an LLM server backed by the fake's model list. Chat requests go
+// to a real LM Studio client so tests can point apiURL at a fake API.
+func (f *fakeBackend) NewLLM(kind, apiURL string) (llm.Backend, error) {
+	return &fakeLLM{fakeBackend: f, chat: &llm.LMStudio{APIURL: apiURL}}, nil
+}
+
+// fakeLLM is the LLM server of fakeBackend; it records its calls on the backend
+type fakeLLM struct {
+	*fakeBackend
+	chat llm.Backend
+}
+
+func (f *fakeLLM) Name() string    { return "LM Studio" }
+func (f *fakeLLM) Available() bool { return true }
+
+func (f *fakeLLM) StartServer() error {
+	f.record("StartServer")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lmsRunning = true
+	return nil
+}
+
+func (f *fakeLLM) StopServer() error {
+	f.record("StopServer")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lmsRunning = false
+	return nil
+}
+
+func (f *fakeLLM) ServerStatus() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lmsRunning, nil
+}
+
+func (f *fakeLLM) ListModels() ([]llm.Model, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]llm.Model(nil), f.models...), nil
+}
+
+func (f *fakeLLM) LoadModel(modelID string, opts llm.LoadOptions) error {
+	f.record("LoadModel", modelID, opts.GPU, opts.ContextLength, opts.TTL)
+	if f.loads != nil {
+		opts.Progress(40)
+		<-f.loads
+	}
+	f.mu.Lock()
+	loadErr := f.loadErr
+	f.mu.Unlock()
+	if loadErr != nil {
+		return loadErr
+	}
+	return f.setModelState(modelID, llm.StateLoaded)
+}
+
+func (f *fakeLLM) UnloadModel(modelID string) error {
+	f.record("UnloadModel", modelID)
+	return f.setModelState(modelID, llm.StateNotLoaded)
+}
+
+func (f *fakeLLM) UnloadAllModels() error {
+	f.record("UnloadAllModels")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.models {
+		f.models[i].State = llm.StateNotLoaded
+	}
+	return nil
+}
+
+func (f *fakeLLM) Chat(ctx context.Context, request llm.ChatRequest, onDelta func(string)) (*llm.ChatResponse, error) {
+	f.record("Chat", request.Model)
+	return f.chat.Chat(ctx, request, onDelta)
+}
+
+func (f *fakeLLM) Download(ctx context.Context, modelID string, onProgress func(llm.DownloadProgress)) error {
+	f.record("Download", modelID)
+	if modelID == "broken/model" {
+		return fmt.Errorf("model not found")
+	}
+	onProgress(llm.DownloadProgress{Percent: 50, BytesPerSecond: 25e6, Status: "downloading"})
+	if f.downloads != nil {
+		select {
+		case <-f.downloads:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.models = append(f.models, llm.Model{ID: modelID, Type: "llm", State: llm.StateNotLoaded})
+	return nil
+}
+
+func (f *fakeBackend) setModelState(modelID, state string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.models {
+		if f.models[i].ID == modelID {
+			f.models[i].State = state
+			return nil
+		}
+	}
+	return fmt.Errorf("model %s not found", modelID)
+}
@@ -0,0 +1,181 @@
+package broker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// Bridge directions
+const (
+	DirectionOut  = "out"  // local -> upstream
+	DirectionIn   = "in"   // upstream -> local
+	DirectionBoth = "both" // both ways
+)
+
+// echoWindow is how long a message sent upstream is ignored when the upstream broker sends it back
+const echoWindow = 5 * time.Second
+
+// BridgeTopic selects the topics forwarded over the bridge
+type BridgeTopic struct {
+	Pattern   string `yaml:"pattern"`   // MQTT topic filter, e.g. "mac2mqtt/#"
+	Direction string `yaml:"direction"` // out, in or both (default: out)
+}
+
+// BridgeOptions configures the connection to an upstream broker
+type BridgeOptions struct {
+	URL      string        `yaml:"url"` // e.g. tcp://192.168.1.10:1883 or ssl://broker:8883
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	ClientID string        `yaml:"client_id"` // default: mac2mqtt_bridge
+	Topics   []BridgeTopic `yaml:"topics"`    // default: mac2mqtt/# both ways, homeassistant/# out
+}
+
+// DefaultBridgeTopics forward mac2mqtt state and discovery upstream and commands back
+var DefaultBridgeTopics = []BridgeTopic{
+	{Pattern: "mac2mqtt/#", Direction: DirectionBoth},
+	{Pattern: "homeassistant/#", Direction: DirectionOut},
+}
+
+// bridge forwards messages between the embedded broker and an upstream broker
+type bridge struct {
+	server   *mqtt.Server
+	upstream paho.Client
+
+	mu     sync.Mutex
+	recent map[string]time.Time // topic+payload of recently forwarded messages, for echo suppression
+}
+
+func startBridge(server *mqtt.Server, opts BridgeOptions) (*bridge, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("bridge url is required")
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "mac2mqtt_bridge"
+	}
+	if len(opts.Topics) == 0 {
+		opts.Topics = DefaultBridgeTopics
+	}
+
+	var in, out []string
+	for _, t := range opts.Topics {
+		switch t.Direction {
+		case "", DirectionOut:
+			out = append(out, t.Pattern)
+		case DirectionIn:
+			in = append(in, t.Pattern)
+		case DirectionBoth:
+			in = append(in, t.Pattern)
+			out = append(out, t.Pattern)
+		default:
+			return nil, fmt.Errorf("invalid bridge direction %q for %s (expected in, out or both)", t.Direction, t.Pattern)
+		}
+	}
+
+	b := &bridge{server: server, recent: make(map[string]time.Time)}
+
+	clientOpts := paho.NewClientOptions().
+		AddBroker(opts.URL).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(15 * time.Second).
+		SetMaxReconnectInterval(2 * time.Minute)
+	clientOpts.OnConnect = func(c paho.Client) {
+		brokerLog.Info("Bridge connected to upstream broker", "url", opts.URL)
+		for _, pattern := range in {
+			c.Subscribe(pattern, 0, b.fromUpstream)
+		}
+		b.replayRetained(c, out)
+	}
+	clientOpts.OnConnectionLost = func(_ paho.Client, err error) {
+		brokerLog.Warn("Bridge lost connection to upstream broker", "url", opts.URL, "err", err)
+	}
+	b.upstream = paho.NewClient(clientOpts)
+	// With ConnectRetry the token only completes once connected, so don't block startup on it
+	b.upstream.Connect()
+
+	for i, pattern := range out {
+		if err := server.Subscribe(pattern, 1000+i, b.fromLocal); err != nil {
+			return nil, err
+		}
+	}
+
+	brokerLog.Info("Bridge configured", "url", opts.URL, "out", out, "in", in)
+	return b, nil
+}
+
+// fromLocal forwards a message published on the embedded broker upstream
+func (b *bridge) fromLocal(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+	if pk.Origin == mqtt.InlineClientId {
+		return // published by the bridge itself
+	}
+	if !b.upstream.IsConnectionOpen() {
+		return
+	}
+	b.remember(pk.TopicName, pk.Payload)
+	b.upstream.Publish(pk.TopicName, pk.FixedHeader.Qos, pk.FixedHeader.Retain, pk.Payload)
+}
+
+// replayRetained sends the retained messages on the out topics upstream. Messages are
+// only forwarded as they are published, so without this the state and discovery
+// published before the bridge (re)connected would never reach the upstream broker.
+func (b *bridge) replayRetained(c paho.Client, out []string) {
+	sent := make(map[string]bool)
+	for _, pattern := range out {
+		for _, pk := range b.server.Topics.Messages(pattern) {
+			if sent[pk.TopicName] || pk.Origin == mqtt.InlineClientId {
+				continue // matched by an earlier pattern, or received from upstream
+			}
+			sent[pk.TopicName] = true
+			b.remember(pk.TopicName, pk.Payload)
+			c.Publish(pk.TopicName, pk.FixedHeader.Qos, true, pk.Payload)
+		}
+	}
+	brokerLog.Info("Bridge sent retained messages upstream", "count", len(sent))
+}
+
+// fromUpstream publishes a message received from the upstream broker locally
+func (b *bridge) fromUpstream(_ paho.Client, msg paho.Message) {
+	if b.isEcho(msg.Topic(), msg.Payload()) {
+		return // our own message coming back on a "both" topic
+	}
+	if err := b.server.Publish(msg.Topic(), msg.Payload(), msg.Retained(), 0); err != nil {
+		brokerLog.Warn("Bridge failed to publish upstream message locally", "topic", msg.Topic(), "err", err)
+	}
+}
+
+// remember notes a message sent upstream so its echo is dropped
+func (b *bridge) remember(topic string, payload []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for k, t := range b.recent {
+		if now.Sub(t) > echoWindow {
+			delete(b.recent, k)
+		}
+	}
+	b.recent[topic+"\x00"+string(payload)] = now
+}
+
+// isEcho reports (and forgets) a message that was just sent upstream
+func (b *bridge) isEcho(topic string, payload []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := topic + "\x00" + string(payload)
+	t, ok := b.recent[key]
+	if ok {
+		delete(b.recent, key)
+	}
+	return ok && time.Since(t) <= echoWindow
+}
+
+func (b *bridge) close() {
+	b.upstream.Disconnect(250)
+}
@@ -0,0 +1,114 @@
+// Package broker runs an embedded MQTT broker for setups without Mosquitto
+package broker
+
+import (
+	"fmt"
+	"net"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+
+	"bessarabov/mac2mqtt/logging"
+)
+
+var brokerLog = logging.New("broker")
+
+// DefaultListen is the listen address used when none is configured
+const DefaultListen = ":1883"
+
+// User is a username and password accepted by the broker
+type User struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Options configures the embedded broker
+type Options struct {
+	Listen       string         `yaml:"listen"`        // Address to listen on (default: ":1883")
+	Users        []User         `yaml:"users"`         // Accepted logins; empty allows anonymous clients
+	RetainedFile string         `yaml:"retained_file"` // JSON file retained messages are persisted to; empty disables persistence
+	Bridge       *BridgeOptions `yaml:"bridge"`        // Optional bridge to an upstream broker
+}
+
+// Broker is a running embedded broker
+type Broker struct {
+	server   *mqtt.Server
+	listener *listeners.TCP
+	bridge   *bridge
+}
+
+// Start starts the broker and, if configured, the bridge
+func Start(opts Options) (*Broker, error) {
+	if opts.Listen == "" {
+		opts.Listen = DefaultListen
+	}
+
+	server := mqtt.New(&mqtt.Options{
+		InlineClient: true, // used by the bridge
+		Logger:       brokerLog,
+	})
+
+	if len(opts.Users) == 0 {
+		brokerLog.Warn("No users configured, the embedded broker accepts anonymous clients")
+		if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+			return nil, err
+		}
+	} else {
+		var rules auth.AuthRules
+		for _, u := range opts.Users {
+			rules = append(rules, auth.AuthRule{Username: auth.RString(u.Username), Password: auth.RString(u.Password), Allow: true})
+		}
+		if err := server.AddHook(new(auth.Hook), &auth.Options{Ledger: &auth.Ledger{Auth: rules}}); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.RetainedFile != "" {
+		if err := server.AddHook(new(retainedStore), opts.RetainedFile); err != nil {
+			return nil, fmt.Errorf("failed to load retained messages: %w", err)
+		}
+	}
+
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: opts.Listen})
+	if err := server.AddListener(tcp); err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", opts.Listen, err)
+	}
+	if err := server.Serve(); err != nil {
+		return nil, err
+	}
+	brokerLog.Info("Embedded MQTT broker started", "listen", tcp.Address(), "users", len(opts.Users), "retained_file", opts.RetainedFile)
+
+	b := &Broker{server: server, listener: tcp}
+	if opts.Bridge != nil {
+		bridge, err := startBridge(server, *opts.Bridge)
+		if err != nil {
+			server.Close()
+			return nil, err
+		}
+		b.bridge = bridge
+	}
+	return b, nil
+}
+
+// Addr returns the address the broker listens on
+func (b *Broker) Addr() string {
+	return b.listener.Address()
+}
+
+// LocalAddr returns a host and port a client on this machine can connect to
+func (b *Broker) LocalAddr() (host, port string) {
+	host, port, _ = net.SplitHostPort(b.Addr())
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return host, port
+}
+
+// Close stops the bridge and the broker, flushing retained messages to disk
+func (b *Broker) Close() error {
+	if b.bridge != nil {
+		b.bridge.close()
+	}
+	return b.server.Close()
+}
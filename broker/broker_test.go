@@ -0,0 +1,162 @@
+package broker
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"bessarabov/mac2mqtt/mqtttest"
+)
+
+func startTestBroker(t *testing.T, opts Options) *Broker {
+	t.Helper()
+	opts.Listen = "127.0.0.1:0"
+	b, err := Start(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func connect(t *testing.T, b *Broker, user, password string) (paho.Client, error) {
+	t.Helper()
+	host, port := b.LocalAddr()
+	opts := paho.NewClientOptions().
+		AddBroker("tcp://" + net.JoinHostPort(host, port)).
+		SetClientID(t.Name() + user).
+		SetUsername(user).
+		SetPassword(password)
+	c := paho.NewClient(opts)
+	token := c.Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatal("connect timed out")
+	}
+	if token.Error() == nil {
+		t.Cleanup(func() { c.Disconnect(100) })
+	}
+	return c, token.Error()
+}
+
+func TestAuthentication(t *testing.T) {
+	b := startTestBroker(t, Options{Users: []User{{Username: "ha", Password: "secret"}}})
+
+	if _, err := connect(t, b, "ha", "secret"); err != nil {
+		t.Errorf("valid login rejected: %v", err)
+	}
+	if _, err := connect(t, b, "ha", "wrong"); err == nil {
+		t.Error("wrong password accepted")
+	}
+	if _, err := connect(t, b, "", ""); err == nil {
+		t.Error("anonymous client accepted")
+	}
+}
+
+func TestRetainedPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "retained.json")
+
+	b, err := Start(Options{Listen: "127.0.0.1:0", RetainedFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := connect(t, b, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Publish("mac2mqtt/test/status/volume", 1, true, "42").Wait()
+	c.Publish("mac2mqtt/test/status/gone", 1, true, "x").Wait()
+	c.Publish("mac2mqtt/test/status/gone", 1, true, "").Wait() // clears the retained message
+	c.Disconnect(100)
+	b.Close()
+
+	b = startTestBroker(t, Options{RetainedFile: file})
+	if pk, ok := b.server.Topics.Retained.Get("mac2mqtt/test/status/volume"); !ok || string(pk.Payload) != "42" {
+		t.Errorf("retained message not restored: %q %v", pk.Payload, ok)
+	}
+	if _, ok := b.server.Topics.Retained.Get("mac2mqtt/test/status/gone"); ok {
+		t.Error("cleared retained message was restored")
+	}
+}
+
+func TestBridgeReplaysRetained(t *testing.T) {
+	// Retained state and discovery that were on the embedded broker before the bridge connected
+	file := filepath.Join(t.TempDir(), "retained.json")
+	err := writeRetained(file, []retainedMessage{
+		{Topic: "mac2mqtt/mac/status/volume", Payload: []byte("30")},
+		{Topic: "homeassistant/device/mac/config", Payload: []byte(`{"dev":{}}`)},
+		{Topic: "other/topic", Payload: []byte("x")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := mqtttest.Start(t)
+	startTestBroker(t, Options{RetainedFile: file, Bridge: &BridgeOptions{
+		URL: "tcp://" + net.JoinHostPort(upstream.Host, upstream.Port),
+	}})
+
+	upstream.WaitForPayload(t, "mac2mqtt/mac/status/volume", "30")
+	upstream.WaitForPayload(t, "homeassistant/device/mac/config", `{"dev":{}}`)
+	if payload, ok := upstream.Retained("mac2mqtt/mac/status/volume"); !ok || payload != "30" {
+		t.Errorf("replayed message not retained upstream: %q %v", payload, ok)
+	}
+	if _, ok := upstream.Last("other/topic"); ok {
+		t.Error("topic outside the bridge patterns was sent upstream")
+	}
+}
+
+func TestBridge(t *testing.T) {
+	upstream := mqtttest.Start(t)
+	b := startTestBroker(t, Options{Bridge: &BridgeOptions{
+		URL: "tcp://" + net.JoinHostPort(upstream.Host, upstream.Port),
+		Topics: []BridgeTopic{
+			{Pattern: "mac2mqtt/#", Direction: DirectionBoth},
+			{Pattern: "local/#", Direction: DirectionOut},
+		},
+	}})
+
+	// Wait until the upstream subscription is in place
+	deadline := time.Now().Add(mqtttest.DefaultTimeout)
+	for len(upstream.Server.Topics.Subscribers("mac2mqtt/x").Subscriptions) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("bridge did not subscribe upstream")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c, err := connect(t, b, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 10)
+	c.Subscribe("mac2mqtt/#", 0, func(_ paho.Client, m paho.Message) { received <- m.Topic() + "=" + string(m.Payload()) }).Wait()
+
+	// Local -> upstream
+	c.Publish("mac2mqtt/mac/status/volume", 0, true, "30").Wait()
+	upstream.WaitForPayload(t, "mac2mqtt/mac/status/volume", "30")
+
+	// Upstream -> local
+	upstream.Publish(t, "mac2mqtt/mac/command/volume", "50", false)
+	want := map[string]bool{"mac2mqtt/mac/status/volume=30": true, "mac2mqtt/mac/command/volume=50": true}
+	for len(want) > 0 {
+		select {
+		case got := <-received:
+			if !want[got] {
+				t.Errorf("unexpected or duplicate message %s", got)
+			}
+			delete(want, got)
+		case <-time.After(mqtttest.DefaultTimeout):
+			t.Fatalf("missing messages: %v", want)
+		}
+	}
+
+	// The status message sent upstream must not come back as a duplicate
+	select {
+	case got := <-received:
+		t.Errorf("unexpected message %s", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,167 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// flushInterval is how often changed retained messages are written to disk
+const flushInterval = 5 * time.Second
+
+// retainedMessage is the on-disk form of a retained message
+type retainedMessage struct {
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+	QoS     byte   `json:"qos"`
+}
+
+// retainedStore is a broker hook that keeps retained messages in a JSON file.
+// Writes are batched so frequent state updates don't hit the disk every time.
+type retainedStore struct {
+	mqtt.HookBase
+
+	path     string
+	mu       sync.Mutex
+	messages map[string]retainedMessage
+	dirty    bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func (h *retainedStore) ID() string { return "retained-file" }
+
+func (h *retainedStore) Provides(b byte) bool {
+	return bytes.Contains([]byte{mqtt.OnRetainMessage, mqtt.StoredRetainedMessages}, []byte{b})
+}
+
+// Init loads the retained file; config is its path
+func (h *retainedStore) Init(config any) error {
+	path, ok := config.(string)
+	if !ok || path == "" {
+		return errors.New("retained store needs a file path")
+	}
+	h.path = path
+	h.messages = make(map[string]retainedMessage)
+	h.stop = make(chan struct{})
+	h.done = make(chan struct{})
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		var list []retainedMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("invalid retained file %s: %w", path, err)
+		}
+		for _, m := range list {
+			h.messages[m.Topic] = m
+		}
+	}
+
+	go h.flushLoop()
+	return nil
+}
+
+// StoredRetainedMessages restores the persisted messages when the broker starts
+func (h *retainedStore) StoredRetainedMessages() ([]storage.Message, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]storage.Message, 0, len(h.messages))
+	for _, m := range h.messages {
+		out = append(out, storage.Message{
+			TopicName:   m.Topic,
+			Payload:     m.Payload,
+			FixedHeader: packets.FixedHeader{Type: packets.Publish, Retain: true, Qos: m.QoS},
+			Created:     time.Now().Unix(),
+		})
+	}
+	brokerLog.Info("Restored retained messages", "count", len(out), "file", h.path)
+	return out, nil
+}
+
+// OnRetainMessage records a new, changed (r >= 0) or cleared (r == -1) retained message
+func (h *retainedStore) OnRetainMessage(_ *mqtt.Client, pk packets.Packet, r int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r == -1 {
+		delete(h.messages, pk.TopicName)
+	} else {
+		h.messages[pk.TopicName] = retainedMessage{Topic: pk.TopicName, Payload: pk.Payload, QoS: pk.FixedHeader.Qos}
+	}
+	h.dirty = true
+}
+
+// Stop writes any pending changes
+func (h *retainedStore) Stop() error {
+	close(h.stop)
+	<-h.done
+	return h.flush()
+}
+
+func (h *retainedStore) flushLoop() {
+	defer close(h.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.flush(); err != nil {
+				brokerLog.Error("Failed to persist retained messages", "file", h.path, "err", err)
+			}
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// flush atomically rewrites the retained file if anything changed
+func (h *retainedStore) flush() error {
+	h.mu.Lock()
+	if !h.dirty {
+		h.mu.Unlock()
+		return nil
+	}
+	list := make([]retainedMessage, 0, len(h.messages))
+	for _, m := range h.messages {
+		list = append(list, m)
+	}
+	h.dirty = false
+	h.mu.Unlock()
+
+	if err := writeRetained(h.path, list); err != nil {
+		// Try again on the next flush
+		h.mu.Lock()
+		h.dirty = true
+		h.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeRetained replaces the file at path with list
+func writeRetained(path string, list []retainedMessage) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -2,10 +2,11 @@ package config
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 
+	"bessarabov/mac2mqtt/logging"
+
 	"gopkg.in/yaml.v2"
 )
 
@@ -34,7 +35,7 @@ func LoadConfig() (*Config, error) {
 	}
 	exPath := filepath.Dir(ex)
 
-	log.Printf("Path: %v", exPath)
+	logging.System.Debug("Config path", "path", exPath)
 	configContent, err := os.ReadFile(exPath + "/mac2mqtt.yaml")
 	if err != nil {
 		return nil, fmt.Errorf("no config file provided: %w", err)
@@ -47,7 +48,7 @@ func LoadConfig() (*Config, error) {
 
 	// Set defaults
 	if c.IdleActivityTime == 0 {
-		log.Println("No idle_activity_time specified in config, using default 10 seconds")
+		logging.System.Info("No idle_activity_time specified in config, using default 10 seconds")
 	}
 	if c.DiscoveryPrefix == "" {
 		c.DiscoveryPrefix = "homeassistant"
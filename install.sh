@@ -84,7 +84,7 @@ build_application() {
     go mod download
 
     # Build the application
-    go build -o mac2mqtt mac2mqtt.go
+    go build -o mac2mqtt .
 
     # Make executable
     chmod +x mac2mqtt
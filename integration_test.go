@@ -0,0 +1,728 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bessarabov/mac2mqtt/macos"
+	"bessarabov/mac2mqtt/mqtttest"
+)
+
+const testPrefix = "mac2mqtt/testmac"
+
+// startTestApp connects an Application with backend to a fresh in-process broker.
+// configure can adjust the test config before the application starts.
+func startTestApp(t *testing.T, backend Backend, configure ...func(*config)) (*Application, *mqtttest.Broker) {
+	t.Helper()
+	broker := mqtttest.Start(t)
+
+	cfg := &config{
+		IP:               broker.Host,
+		Port:             broker.Port,
+		Hostname:         "testmac",
+		DiscoveryPrefix:  DefaultDiscoveryPrefix,
+		IdleActivityTime: 1,
+		LMStudioEnabled:  true,
+		LMStudioAPIURL:   "http://localhost:1234",
+	}
+	for _, f := range configure {
+		f(cfg)
+	}
+	app, err := newApplication(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.getMQTTClient(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.getClient().Disconnect(100) })
+
+	broker.WaitForPayload(t, testPrefix+"/status/alive", "online")
+	// The app subscribes to its commands after announcing itself, so wait for that too
+	deadline := time.Now().Add(mqtttest.DefaultTimeout)
+	for len(broker.Server.Topics.Subscribers(testPrefix+"/command/volume").Subscriptions) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("app did not subscribe to its commands")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return app, broker
+}
+
+func TestDiscoveryAndInitialState(t *testing.T) {
+	_, broker := startTestApp(t, newFakeBackend())
+
+	payload := broker.WaitFor(t, "homeassistant/device/testmac/config", nil)
+	var discovery struct {
+		Device struct {
+			IDs   string `json:"ids"`
+			Model string `json:"mdl"`
+		} `json:"dev"`
+		Components   map[string]map[string]interface{} `json:"cmps"`
+		Availability string                            `json:"availability_topic"`
+	}
+	if err := json.Unmarshal([]byte(payload), &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if discovery.Device.IDs != "FAKESERIAL" || discovery.Device.Model != "Fake Mac" {
+		t.Errorf("unexpected device: %+v", discovery.Device)
+	}
+	if discovery.Availability != testPrefix+"/status/alive" {
+		t.Errorf("availability_topic = %q", discovery.Availability)
+	}
+	if _, ok := discovery.Components["volume"]; !ok {
+		t.Errorf("missing volume component, got %d components", len(discovery.Components))
+	}
+	if _, ok := broker.Retained("homeassistant/device/testmac/config"); !ok {
+		t.Error("device discovery should be retained")
+	}
+
+	broker.WaitForPayload(t, testPrefix+"/status/volume", "40")
+	broker.WaitForPayload(t, testPrefix+"/status/mute", "false")
+	broker.WaitForPayload(t, testPrefix+"/status/display_1_brightness", "70")
+	broker.WaitFor(t, "homeassistant/switch/testmac/lmstudio_server/config", nil)
+}
+
+func TestCommands(t *testing.T) {
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend)
+
+	broker.Publish(t, testPrefix+"/command/volume", "55", false)
+	broker.WaitForPayload(t, testPrefix+"/status/volume", "55")
+	if !backend.called("SetVolume(55)") {
+		t.Error("expected SetVolume(55)")
+	}
+
+	broker.Publish(t, testPrefix+"/command/mute", "true", false)
+	broker.WaitForPayload(t, testPrefix+"/status/mute", "true")
+
+	broker.Publish(t, testPrefix+"/command/display_1_brightness", "30", false)
+	broker.WaitForPayload(t, testPrefix+"/status/display_1_brightness", "30")
+
+	broker.Publish(t, testPrefix+"/command/keepawake", "true", false)
+	broker.WaitForPayload(t, testPrefix+"/status/caffeinate", "true")
+
+	for payload, call := range map[string]string{
+		"sleep":        "Sleep()",
+		"displaysleep": "DisplaySleep()",
+		"screensaver":  "Screensaver()",
+	} {
+		broker.Publish(t, testPrefix+"/command/set", payload, false)
+		if !backend.waitCalled(call, mqtttest.DefaultTimeout) {
+			t.Errorf("%s did not call %s", payload, call)
+		}
+	}
+
+	broker.Publish(t, testPrefix+"/command/runshortcut", "Focus", false)
+	if !backend.waitCalled("RunShortcut(Focus)", mqtttest.DefaultTimeout) {
+		t.Error("expected RunShortcut(Focus)")
+	}
+}
+
+func TestInvalidCommandsAreIgnored(t *testing.T) {
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend)
+
+	broker.Publish(t, testPrefix+"/command/volume", "loud", false)
+	broker.Publish(t, testPrefix+"/command/volume", "101", false)
+	broker.Publish(t, testPrefix+"/command/runshortcut", "rm -rf; echo", false)
+	// A valid command afterwards proves the invalid ones have been handled
+	broker.Publish(t, testPrefix+"/command/volume", "10", false)
+	broker.WaitForPayload(t, testPrefix+"/status/volume", "10")
+
+	for _, call := range []string{"SetVolume(101)", "RunShortcut(rm -rf; echo)"} {
+		if backend.called(call) {
+			t.Errorf("invalid command reached the backend: %s", call)
+		}
+	}
+}
+
+func TestLMStudioModelCommands(t *testing.T) {
+	backend := newFakeBackend()
+	app, broker := startTestApp(t, backend)
+	app.updateLMStudioStatus(app.getClient())
+
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_qwen_qwen3_8b", "ON")
+	broker.Publish(t, testPrefix+"/command/lmstudio_model_google_gemma_3_12b", "load", false)
+	if !backend.waitCalled("LoadModel(google/gemma-3-12b,0,0,0)", mqtttest.DefaultTimeout) {
+		t.Error("expected model load")
+	}
+
+	// Stopping the server unloads all models first
+	broker.Publish(t, testPrefix+"/command/lmstudio_server", "stop", false)
+	if !backend.waitCalled("StopServer()", mqtttest.DefaultTimeout) {
+		t.Error("expected server stop")
+	}
+	if !backend.called("UnloadAllModels()") {
+		t.Error("expected models to be unloaded before the server stops")
+	}
+}
+
+// waitMediaStream waits for the app to start reading the media stream, which the connect handler does
+func waitMediaStream(t *testing.T, backend *fakeBackend) {
+	t.Helper()
+	deadline := time.Now().Add(mqtttest.DefaultTimeout)
+	for {
+		backend.mu.Lock()
+		n := len(backend.streams)
+		backend.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("media stream was not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMediaStreamUpdates(t *testing.T) {
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend)
+
+	waitMediaStream(t, backend)
+
+	broker.Reset()
+	backend.emitMedia(map[string]interface{}{"title": "Teardrop", "artist": "Massive Attack", "playing": true, "duration": 330.0})
+	broker.WaitForPayload(t, testPrefix+"/status/now_playing", "playing")
+	attr := broker.WaitFor(t, testPrefix+"/status/now_playing_attr", nil)
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(attr), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["title"] != "Teardrop" || got["artist"] != "Massive Attack" {
+		t.Errorf("unexpected attributes: %v", got)
+	}
+
+	// Pausing keeps the item, and every media topic agrees
+	backend.emitMedia(map[string]interface{}{"playing": false, "elapsedTime": 42.0})
+	broker.WaitForPayload(t, testPrefix+"/status/now_playing", "paused")
+	broker.WaitForPayload(t, testPrefix+"/status/media_state", "paused")
+	broker.WaitForPayload(t, testPrefix+"/status/media_position", "42")
+	broker.WaitFor(t, testPrefix+"/status/media_player", func(p string) bool {
+		return strings.Contains(p, `"state":"paused"`) && strings.Contains(p, `"title":"Teardrop"`)
+	})
+
+	// Nothing playing
+	backend.emitMediaSnapshot(map[string]interface{}{})
+	broker.WaitForPayload(t, testPrefix+"/status/media_state", "idle")
+	broker.WaitForPayload(t, testPrefix+"/status/media_title", "")
+}
+
+func TestMergeMediaPayload(t *testing.T) {
+	reported := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	now := reported.Add(10 * time.Second)
+	playing := macos.MediaInfo{
+		Title: "Teardrop", Artist: "Massive Attack", Album: "Mezzanine", AppName: "Spotify", AppBundleID: "com.spotify.client",
+		State: "playing", Duration: 330, Position: 100, PositionUpdatedAt: reported, PlaybackRate: 1,
+		Artwork: []byte("cover"), ArtworkMimeType: "image/jpeg",
+	}
+	tests := []struct {
+		name    string
+		current macos.MediaInfo
+		payload map[string]interface{}
+		diff    bool
+		want    macos.MediaInfo
+	}{
+		{
+			name:    "pause",
+			current: playing,
+			payload: map[string]interface{}{"playing": false},
+			diff:    true,
+			want: macos.MediaInfo{
+				Title: "Teardrop", Artist: "Massive Attack", Album: "Mezzanine", AppName: "Spotify", AppBundleID: "com.spotify.client",
+				State: "paused", Duration: 330, Position: 110, PositionUpdatedAt: now,
+				Artwork: []byte("cover"), ArtworkMimeType: "image/jpeg",
+			},
+		},
+		{
+			name:    "stop",
+			current: playing,
+			payload: map[string]interface{}{"playing": false, "elapsedTime": 0.0},
+			diff:    true,
+			want: macos.MediaInfo{
+				Title: "Teardrop", Artist: "Massive Attack", Album: "Mezzanine", AppName: "Spotify", AppBundleID: "com.spotify.client",
+				State: "stopped", Duration: 330, PositionUpdatedAt: now,
+				Artwork: []byte("cover"), ArtworkMimeType: "image/jpeg",
+			},
+		},
+		{
+			name:    "reported position",
+			current: playing,
+			payload: map[string]interface{}{"elapsedTime": 120.25, "timestamp": reported.Add(5 * time.Second).Format(time.RFC3339Nano), "playbackRate": 2.0},
+			diff:    true,
+			want: macos.MediaInfo{
+				Title: "Teardrop", Artist: "Massive Attack", Album: "Mezzanine", AppName: "Spotify", AppBundleID: "com.spotify.client",
+				State: "playing", Duration: 330, Position: 120.25, PositionUpdatedAt: reported.Add(5 * time.Second), PlaybackRate: 2,
+				Artwork: []byte("cover"), ArtworkMimeType: "image/jpeg",
+			},
+		},
+		{
+			name:    "next track on the album",
+			current: playing,
+			payload: map[string]interface{}{"title": "Angel"},
+			diff:    true,
+			want: macos.MediaInfo{
+				Title: "Angel", Artist: "Massive Attack", Album: "Mezzanine", AppName: "Spotify", AppBundleID: "com.spotify.client",
+				State: "playing", PositionUpdatedAt: now, PlaybackRate: 1,
+				Artwork: []byte("cover"), ArtworkMimeType: "image/jpeg",
+			},
+		},
+		{
+			name:    "next album",
+			current: playing,
+			payload: map[string]interface{}{"title": "Unfinished Sympathy", "album": "Blue Lines", "duration": 308.0},
+			diff:    true,
+			want: macos.MediaInfo{
+				Title: "Unfinished Sympathy", Artist: "Massive Attack", Album: "Blue Lines", AppName: "Spotify", AppBundleID: "com.spotify.client",
+				State: "playing", Duration: 308, PositionUpdatedAt: now, PlaybackRate: 1,
+			},
+		},
+		{
+			name:    "removed field",
+			current: playing,
+			payload: map[string]interface{}{"artist": nil},
+			diff:    true,
+			want: macos.MediaInfo{
+				Title: "Teardrop", Album: "Mezzanine", AppName: "Spotify", AppBundleID: "com.spotify.client",
+				State: "playing", PositionUpdatedAt: now, PlaybackRate: 1,
+				Artwork: []byte("cover"), ArtworkMimeType: "image/jpeg",
+			},
+		},
+		{
+			name:    "other app",
+			current: playing,
+			payload: map[string]interface{}{"bundleIdentifier": "com.apple.Safari", "title": "Weekly Standup", "playing": false, "elapsedTime": 60.0},
+			diff:    true,
+			want: macos.MediaInfo{
+				Title: "Weekly Standup", AppName: "Safari", AppBundleID: "com.apple.Safari",
+				State: "paused", Position: 60, PositionUpdatedAt: now,
+			},
+		},
+		{
+			name:    "full payload",
+			current: playing,
+			payload: map[string]interface{}{"bundleIdentifier": "com.spotify.client", "title": "Angel", "playing": true},
+			want: macos.MediaInfo{
+				Title: "Angel", AppName: "Spotify", AppBundleID: "com.spotify.client",
+				State: "playing", PositionUpdatedAt: now, PlaybackRate: 1,
+			},
+		},
+		{
+			name:    "idle",
+			current: playing,
+			payload: map[string]interface{}{},
+			want:    macos.MediaInfo{State: "idle"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeMediaPayload(tt.current, tt.payload, tt.diff, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLiveMediaPosition(t *testing.T) {
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend, func(c *config) { c.MediaPositionInterval = 0.05 })
+	waitMediaStream(t, backend)
+
+	backend.emitMedia(map[string]interface{}{"title": "Teardrop", "playing": true, "duration": 330.0, "elapsedTime": 10.5, "playbackRate": 1.0})
+	attr := broker.WaitFor(t, testPrefix+"/status/now_playing_attr", func(p string) bool { return strings.Contains(p, `"position":10.5`) })
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(attr), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["playback_rate"] != 1.0 {
+		t.Errorf("playback_rate = %v, want 1", got["playback_rate"])
+	}
+	if updatedAt, _ := got["position_updated_at"].(string); updatedAt == "" {
+		t.Error("missing position_updated_at")
+	} else if _, err := time.Parse(time.RFC3339Nano, updatedAt); err != nil {
+		t.Errorf("invalid position_updated_at: %v", err)
+	}
+
+	// While playing, the position moves on between updates
+	broker.WaitFor(t, testPrefix+"/status/media_position", func(p string) bool {
+		position, err := strconv.ParseFloat(p, 64)
+		return err == nil && position > 10.6
+	})
+
+	// Paused, it stays put
+	backend.emitMedia(map[string]interface{}{"playing": false, "elapsedTime": 20.0, "playbackRate": 0.0})
+	broker.WaitFor(t, testPrefix+"/status/media_player", func(p string) bool {
+		return strings.Contains(p, `"position":20,`) && strings.Contains(p, `"playback_rate":0`)
+	})
+	broker.Reset()
+	time.Sleep(200 * time.Millisecond)
+	if p, ok := broker.Last(testPrefix + "/status/media_position"); ok {
+		t.Errorf("position published while paused: %s", p)
+	}
+}
+
+func TestChooseMediaSource(t *testing.T) {
+	cfg := &config{
+		MediaExcludeApps:    []string{"us.zoom.xos"},
+		MediaSourcePriority: []string{"com.apple.Music", "com.spotify.client"},
+	}
+	media := func(bundleID, state string) macos.MediaInfo {
+		return macos.MediaInfo{Title: bundleID + " " + state, AppBundleID: bundleID, State: state}
+	}
+	kept := func(bundleID string) macos.MediaInfo {
+		m := media(bundleID, "paused")
+		m.Title, m.Kept = bundleID+" playing", true
+		return m
+	}
+	idle := macos.MediaInfo{State: "idle"}
+	tests := []struct {
+		name       string
+		shown, now macos.MediaInfo
+		want       macos.MediaInfo
+	}{
+		{"same app", media("com.apple.Music", "playing"), media("com.apple.Music", "paused"), media("com.apple.Music", "paused")},
+		{"lower priority while playing", media("com.apple.Music", "playing"), media("com.apple.Safari", "playing"), kept("com.apple.Music")},
+		{"lower priority while paused", media("com.apple.Music", "paused"), media("com.apple.Safari", "playing"), media("com.apple.Safari", "playing")},
+		{"higher priority", media("com.spotify.client", "playing"), media("com.apple.Music", "playing"), media("com.apple.Music", "playing")},
+		{"same priority", media("com.apple.Safari", "playing"), media("com.google.Chrome", "playing"), media("com.google.Chrome", "playing")},
+		{"excluded while playing", media("com.apple.Safari", "playing"), media("us.zoom.xos", "playing"), kept("com.apple.Safari")},
+		{"excluded", media("com.apple.Safari", "paused"), media("us.zoom.xos", "playing"), idle},
+		{"kept", kept("com.apple.Music"), media("com.apple.Safari", "playing"), kept("com.apple.Music")},
+		{"kept higher priority", kept("com.apple.Music"), media("com.apple.Music", "playing"), media("com.apple.Music", "playing")},
+		{"idle", media("com.apple.Music", "playing"), idle, idle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.chooseMediaSource(tt.shown, tt.now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// The kept app is paused at the position it had when another app took over, until
+	// it reports again; once it is paused for real, the other app is shown
+	t0 := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	at := func(m macos.MediaInfo, position float64, offset time.Duration) macos.MediaInfo {
+		m.Position, m.PositionUpdatedAt = position, t0.Add(offset)
+		if m.State == "playing" {
+			m.PlaybackRate = 1
+		}
+		return m
+	}
+	steps := []struct {
+		now          macos.MediaInfo
+		wantBundleID string
+		wantState    string
+		wantPosition float64 // at t0 + 1 minute
+	}{
+		{at(media("com.apple.Music", "playing"), 30, 0), "com.apple.Music", "playing", 90},
+		{at(media("com.apple.Safari", "playing"), 0, 10*time.Second), "com.apple.Music", "paused", 40},
+		{at(media("com.apple.Safari", "playing"), 5, 15*time.Second), "com.apple.Music", "paused", 40},
+		{at(media("com.apple.Music", "paused"), 40, 20*time.Second), "com.apple.Music", "paused", 40},
+		{at(media("com.apple.Safari", "playing"), 10, 20*time.Second), "com.apple.Safari", "playing", 50},
+	}
+	shown := idle
+	for i, step := range steps {
+		shown = cfg.chooseMediaSource(shown, step.now)
+		if shown.AppBundleID != step.wantBundleID || shown.State != step.wantState || shown.LivePosition(t0.Add(time.Minute)) != step.wantPosition {
+			t.Errorf("step %d: got %s %s at %v, want %s %s at %v", i, shown.AppBundleID, shown.State, shown.LivePosition(t0.Add(time.Minute)),
+				step.wantBundleID, step.wantState, step.wantPosition)
+		}
+	}
+
+	included := &config{MediaIncludeApps: []string{"com.apple.music"}}
+	if got := included.chooseMediaSource(idle, media("com.apple.Music", "playing")); got.State != "playing" {
+		t.Errorf("included app not shown: %+v", got)
+	}
+	if got := included.chooseMediaSource(idle, media("com.apple.Safari", "playing")); got.State != "idle" {
+		t.Errorf("app that isn't included shown: %+v", got)
+	}
+}
+
+func TestMediaSources(t *testing.T) {
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend, func(c *config) {
+		c.MediaExcludeApps = []string{"us.zoom.xos"}
+		c.MediaSourcePriority = []string{"com.apple.Music"}
+	})
+	waitMediaStream(t, backend)
+
+	backend.emitMediaSnapshot(map[string]interface{}{"bundleIdentifier": "com.apple.Music", "title": "Teardrop", "playing": true})
+	broker.WaitForPayload(t, testPrefix+"/status/media_app_bundle_id", "com.apple.Music")
+	broker.WaitForPayload(t, testPrefix+"/status/media_app", "Music")
+	broker.WaitFor(t, testPrefix+"/status/now_playing_attr", func(p string) bool {
+		return strings.Contains(p, `"app_bundle_id":"com.apple.Music"`) && strings.Contains(p, `"app_name":"Music"`)
+	})
+
+	// A ringtone or a video in the browser don't replace the music
+	broker.Reset()
+	backend.emitMediaSnapshot(map[string]interface{}{"bundleIdentifier": "us.zoom.xos", "title": "Ringtone", "playing": true})
+	backend.emitMediaSnapshot(map[string]interface{}{"bundleIdentifier": "com.apple.Safari", "title": "Video", "playing": true})
+	backend.emitMediaSnapshot(map[string]interface{}{"bundleIdentifier": "com.apple.Music", "title": "Teardrop", "playing": false, "elapsedTime": 30.0})
+	broker.WaitForPayload(t, testPrefix+"/status/media_state", "paused")
+	if title, _ := broker.Last(testPrefix + "/status/media_title"); title != "Teardrop" {
+		t.Errorf("media title = %q, want Teardrop", title)
+	}
+	if p, ok := broker.Last(testPrefix + "/status/media_player"); ok && (strings.Contains(p, "Ringtone") || strings.Contains(p, "Video")) {
+		t.Errorf("other source shown: %s", p)
+	}
+
+	// Once the music is paused, the browser is shown
+	backend.emitMediaSnapshot(map[string]interface{}{"bundleIdentifier": "com.apple.Safari", "title": "Video", "playing": true})
+	broker.WaitForPayload(t, testPrefix+"/status/media_title", "Video")
+}
+
+func TestMediaTransport(t *testing.T) {
+	backend := newFakeBackend()
+	backend.media = &macos.MediaInfo{AppName: "Spotify", AppBundleID: "com.spotify.client", State: "playing", Duration: 200, Position: 190}
+	_, broker := startTestApp(t, backend)
+
+	payload := broker.WaitFor(t, "homeassistant/device/testmac/config", nil)
+	var discovery struct {
+		Components map[string]map[string]interface{} `json:"cmps"`
+	}
+	if err := json.Unmarshal([]byte(payload), &discovery); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"media_next", "media_previous", "media_stop", "media_skip_back", "media_skip_forward", "media_seek", "media_shuffle", "media_repeat"} {
+		if _, ok := discovery.Components[id]; !ok {
+			t.Errorf("missing %s component", id)
+		}
+	}
+
+	commands := []struct{ topic, payload, call string }{
+		{"media", `{"command": "play"}`, "Play()"},
+		{"media", `{"command": "pause"}`, "Pause()"},
+		{"media_next", "next", "NextTrack()"},
+		{"media_previous", "previous", "PreviousTrack()"},
+		{"media_stop", "stop", "StopMedia()"},
+		{"media_seek", "42", "SeekMedia(42)"},
+		{"media_skip", "-15", "SeekMedia(175)"},
+		{"media_skip", "15", "SeekMedia(200)"}, // clamped to the duration
+		{"media_shuffle", "tracks", "SetShuffleMode(tracks)"},
+		{"media", `{"command": "repeat", "mode": "playlist", "only_if_app": "com.spotify.client"}`, "SetRepeatMode(playlist)"},
+	}
+	for _, c := range commands {
+		broker.Publish(t, testPrefix+"/command/"+c.topic, c.payload, false)
+		if !backend.waitCalled(c.call, mqtttest.DefaultTimeout) {
+			t.Errorf("%s %s: expected %s", c.topic, c.payload, c.call)
+		}
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/media_shuffle", "tracks")
+	broker.WaitForPayload(t, testPrefix+"/status/media_repeat", "playlist")
+
+	// Commands for another app and invalid modes are ignored
+	broker.Publish(t, testPrefix+"/command/media", `{"command": "next", "only_if_app": "Music"}`, false)
+	broker.Publish(t, testPrefix+"/command/media_repeat", "album", false)
+	broker.Publish(t, testPrefix+"/command/media", `{"command": "seek", "position": 7, "only_if_app": "spotify"}`, false)
+	if !backend.waitCalled("SeekMedia(7)", mqtttest.DefaultTimeout) {
+		t.Error("expected the command for the playing app to run")
+	}
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	var calls []string
+	for _, c := range backend.calls {
+		if c == "NextTrack()" || c == "SetRepeatMode(album)" {
+			calls = append(calls, c)
+		}
+	}
+	if len(calls) != 1 {
+		t.Errorf("expected only the first next track, got %v", calls)
+	}
+}
+
+func TestMediaPlayerDiscovery(t *testing.T) {
+	backend := newFakeBackend()
+	backend.media = &macos.MediaInfo{Title: "Teardrop", State: "playing"}
+	_, broker := startTestApp(t, backend, func(c *config) { c.MediaPlayerDiscovery = true })
+	waitMediaStream(t, backend)
+
+	payload := broker.WaitFor(t, "homeassistant/media_player/testmac/config", nil)
+	var discovery map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &discovery); err != nil {
+		t.Fatal(err)
+	}
+	for key, topic := range map[string]string{
+		"state_state_topic":    "/status/media_player_state",
+		"state_title_topic":    "/status/media_title",
+		"state_position_topic": "/status/media_position",
+		"state_volume_topic":   "/status/media_player_volume",
+		"state_mute_topic":     "/status/mute",
+		"state_albumart_topic": "/status/media_player_albumart",
+		"command_volume_topic": "/command/media_player_volume",
+		"command_next_topic":   "/command/media_next",
+	} {
+		if discovery[key] != testPrefix+topic {
+			t.Errorf("%s = %v, want %s", key, discovery[key], testPrefix+topic)
+		}
+	}
+
+	// Stopped is idle for Home Assistant
+	backend.emitMedia(map[string]interface{}{"title": "Teardrop", "playing": true, "elapsedTime": 5.0})
+	broker.WaitForPayload(t, testPrefix+"/status/media_player_state", "playing")
+	backend.emitMedia(map[string]interface{}{"playing": false, "elapsedTime": 0.0})
+	broker.WaitForPayload(t, testPrefix+"/status/media_state", "stopped")
+	broker.WaitForPayload(t, testPrefix+"/status/media_player_state", "idle")
+
+	broker.Publish(t, testPrefix+"/command/media_player_volume", "0.3", false)
+	if !backend.waitCalled("SetVolume(30)", mqtttest.DefaultTimeout) {
+		t.Error("expected the volume to be set to 30")
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/media_player_volume", "0.3")
+
+	// The media player's play and pause buttons don't toggle
+	broker.Publish(t, testPrefix+"/command/media", discovery["command_pause_payload"].(string), false)
+	if !backend.waitCalled("Pause()", mqtttest.DefaultTimeout) {
+		t.Error("expected the pause button to pause the media")
+	}
+	broker.Publish(t, testPrefix+"/command/media", discovery["command_play_payload"].(string), false)
+	if !backend.waitCalled("Play()", mqtttest.DefaultTimeout) {
+		t.Error("expected the play button to play the media")
+	}
+	if backend.called("PlayPause()") {
+		t.Error("expected play and pause not to toggle")
+	}
+}
+
+func TestMediaArtwork(t *testing.T) {
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend, func(c *config) { c.MediaArtworkSize = 100 })
+	waitMediaStream(t, backend)
+
+	cover := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	draw.Draw(cover, cover.Bounds(), image.NewUniform(color.RGBA{R: 200, A: 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, cover); err != nil {
+		t.Fatal(err)
+	}
+	artwork := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	backend.emitMedia(map[string]interface{}{"title": "Teardrop", "playing": true, "artworkData": artwork, "artworkMimeType": "image/png"})
+	payload := broker.WaitFor(t, testPrefix+"/status/media_artwork", func(p string) bool { return p != "" })
+	img, err := jpeg.Decode(strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("artwork is not a JPEG: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 100 || size.Y != 50 {
+		t.Errorf("artwork size = %v, want 100x50", size)
+	}
+	if r, _, _, _ := img.At(50, 25).RGBA(); r>>8 < 190 {
+		t.Errorf("artwork colour lost: red = %d", r>>8)
+	}
+
+	// The same cover isn't published again
+	broker.Reset()
+	backend.emitMedia(map[string]interface{}{"elapsedTime": 10.0, "artworkData": artwork})
+	backend.emitMedia(map[string]interface{}{"elapsedTime": 11.0}) // anything published for the first update comes before this one
+	broker.WaitFor(t, testPrefix+"/status/now_playing_attr", func(p string) bool { return strings.Contains(p, `"position":11`) })
+	if _, ok := broker.Last(testPrefix + "/status/media_artwork"); ok {
+		t.Error("unchanged artwork was published again")
+	}
+
+	// Artwork above the pixel limit isn't decoded
+	buf.Reset()
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4097, 4097))); err != nil {
+		t.Fatal(err)
+	}
+	backend.emitMedia(map[string]interface{}{"artworkData": base64.StdEncoding.EncodeToString(buf.Bytes())})
+	broker.WaitForPayload(t, testPrefix+"/status/media_artwork", "")
+
+	// Idle clears it
+	backend.emitMedia(map[string]interface{}{"artworkData": artwork})
+	broker.WaitFor(t, testPrefix+"/status/media_artwork", func(p string) bool { return p != "" })
+	broker.Reset()
+	backend.emitMediaSnapshot(map[string]interface{}{})
+	broker.WaitForPayload(t, testPrefix+"/status/media_artwork", "")
+}
+
+func TestMediaStreamSupervisor(t *testing.T) {
+	timing := mediaStreamTiming
+	mediaStreamTiming.minBackoff, mediaStreamTiming.stallTimeout, mediaStreamTiming.checkInterval = 50*time.Millisecond, 200*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { mediaStreamTiming = timing })
+
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend)
+	waitMediaStream(t, backend)
+	broker.WaitForPayload(t, testPrefix+"/status/media_stream", "online")
+
+	streamCount := func() int {
+		backend.mu.Lock()
+		defer backend.mu.Unlock()
+		return len(backend.streams)
+	}
+
+	// media-control exits: the player is polled and the stream restarted
+	backend.mu.Lock()
+	backend.media = &macos.MediaInfo{Title: "Polled", State: "playing"}
+	backend.streams[0].Close()
+	backend.mu.Unlock()
+	broker.WaitForPayload(t, testPrefix+"/status/media_stream", "offline")
+	broker.WaitFor(t, testPrefix+"/status/now_playing_attr", func(p string) bool { return strings.Contains(p, `"title":"Polled"`) })
+	broker.WaitFor(t, testPrefix+"/status/media_stream/attributes", func(p string) bool { return strings.Contains(p, `"restarts":1`) })
+	broker.WaitForPayload(t, testPrefix+"/status/media_stream", "online")
+	if n := streamCount(); n != 2 {
+		t.Fatalf("expected the stream to be restarted once, got %d streams", n)
+	}
+
+	// The stream goes silent while the player moves on: it is restarted
+	backend.emitMedia(map[string]interface{}{"title": "Polled", "playing": true})
+	backend.mu.Lock()
+	backend.media = &macos.MediaInfo{Title: "Next song", State: "playing"}
+	backend.mu.Unlock()
+	broker.WaitFor(t, testPrefix+"/status/media_stream/attributes", func(p string) bool {
+		return strings.Contains(p, "stalled")
+	})
+	broker.WaitFor(t, testPrefix+"/status/now_playing_attr", func(p string) bool { return strings.Contains(p, `"title":"Next song"`) })
+	deadline := time.Now().Add(mqtttest.DefaultTimeout)
+	for streamCount() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := streamCount(); n != 3 {
+		t.Errorf("expected the stalled stream to be restarted, got %d streams", n)
+	}
+}
+
+func TestUserActivity(t *testing.T) {
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend)
+
+	backend.mu.Lock()
+	backend.idleTime = 0
+	backend.mu.Unlock()
+	broker.WaitForPayload(t, testPrefix+"/status/user_activity", "active")
+
+	backend.mu.Lock()
+	backend.idleTime = 100
+	backend.mu.Unlock()
+	broker.Reset()
+	broker.WaitForPayload(t, testPrefix+"/status/user_activity", "inactive")
+}
+
+func TestReconnect(t *testing.T) {
+	app, broker := startTestApp(t, newFakeBackend())
+	broker.Reset()
+
+	broker.DropClients()
+	// The broker publishes the will, then the client reconnects on its own
+	broker.WaitForPayload(t, testPrefix+"/status/alive", "offline")
+	broker.WaitForPayload(t, testPrefix+"/status/alive", "online")
+	broker.WaitFor(t, "homeassistant/device/testmac/config", nil)
+
+	if !app.getClient().IsConnected() {
+		t.Error("client should be connected again")
+	}
+	broker.Publish(t, testPrefix+"/command/volume", "20", false)
+	broker.WaitForPayload(t, testPrefix+"/status/volume", "20")
+}
@@ -0,0 +1,122 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// LlamaCpp talks to llama.cpp's llama-server over its OpenAI-compatible API.
+// A server started with -m serves that one model, which is always loaded. In router
+// mode (started without -m) it can load and unload models through /models/load and
+// /models/unload.
+type LlamaCpp struct {
+	APIURL string // e.g. http://localhost:8080
+}
+
+// llamaCppModel is a model entry of /v1/models
+type llamaCppModel struct {
+	ID      string `json:"id"`
+	OwnedBy string `json:"owned_by"`
+	Meta    struct {
+		NCtxTrain int   `json:"n_ctx_train"`
+		Size      int64 `json:"size"` // bytes
+	} `json:"meta"`
+	Status *struct {
+		Value string `json:"value"` // router mode: loaded, loading or unloaded
+	} `json:"status"`
+}
+
+func (l *LlamaCpp) Name() string { return "llama.cpp" }
+
+// Available is always true, llama-server is controlled over HTTP only
+func (l *LlamaCpp) Available() bool { return true }
+
+// ServerStatus checks if llama-server is up and has finished loading
+func (l *LlamaCpp) ServerStatus() (bool, error) {
+	return isServerUp(l.APIURL + "/health"), nil
+}
+
+// ListModels lists the models the server can serve
+func (l *LlamaCpp) ListModels() ([]Model, error) {
+	var result struct {
+		Data []llamaCppModel `json:"data"`
+	}
+	if err := getJSON(context.Background(), l.APIURL+"/v1/models", 10*time.Second, &result); err != nil {
+		return nil, fmt.Errorf("failed to list llama.cpp models: %w", err)
+	}
+
+	models := make([]Model, 0, len(result.Data))
+	for _, m := range result.Data {
+		state := StateLoaded // single-model server
+		if m.Status != nil && m.Status.Value != StateLoaded {
+			state = StateNotLoaded
+		}
+		models = append(models, Model{
+			ID:                m.ID,
+			Object:            "model",
+			Type:              "llm",
+			Publisher:         m.OwnedBy,
+			CompatibilityType: "gguf",
+			State:             state,
+			MaxContextLength:  m.Meta.NCtxTrain,
+			SizeBytes:         m.Meta.Size,
+		})
+	}
+	return models, nil
+}
+
+// LoadModel loads a model on a server in router mode. Load options are set when
+// llama-server starts, so opts is ignored.
+func (l *LlamaCpp) LoadModel(modelID string, opts LoadOptions) error {
+	llmLog.Info("Loading llama.cpp model", "model", modelID)
+	if err := l.routerRequest("/models/load", modelID); err != nil {
+		return fmt.Errorf("failed to load model %s: %w", modelID, err)
+	}
+	llmLog.Info("Model loaded successfully", "model", modelID)
+	return nil
+}
+
+// UnloadModel unloads a model on a server in router mode
+func (l *LlamaCpp) UnloadModel(modelID string) error {
+	llmLog.Info("Unloading llama.cpp model", "model", modelID)
+	if err := l.routerRequest("/models/unload", modelID); err != nil {
+		return fmt.Errorf("failed to unload model %s: %w", modelID, err)
+	}
+	llmLog.Info("Model unloaded successfully", "model", modelID)
+	return nil
+}
+
+// UnloadAllModels unloads every loaded model on a server in router mode
+func (l *LlamaCpp) UnloadAllModels() error {
+	models, err := l.ListModels()
+	if err != nil {
+		return err
+	}
+	for _, m := range models {
+		if m.State != StateLoaded {
+			continue
+		}
+		if err := l.UnloadModel(m.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Chat sends a chat completion request to the server
+func (l *LlamaCpp) Chat(ctx context.Context, request ChatRequest, onDelta func(string)) (*ChatResponse, error) {
+	return chat(ctx, l.APIURL+"/v1/chat/completions", request, onDelta)
+}
+
+// routerRequest posts a model load/unload request, explaining the error of a single-model server
+func (l *LlamaCpp) routerRequest(path, modelID string) error {
+	err := postJSON(context.Background(), l.APIURL+path, modelLoadTimeout, map[string]string{"model": modelID}, nil)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		return fmt.Errorf("llama-server was started with a single model; start it without -m (router mode) to switch models")
+	}
+	return err
+}
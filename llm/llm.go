@@ -0,0 +1,278 @@
+// Package llm talks to local LLM servers (LM Studio, Ollama, llama.cpp server)
+// behind a common interface, so the MQTT entities work the same for all of them.
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"bessarabov/mac2mqtt/logging"
+)
+
+var llmLog = logging.LMStudio
+
+// chatTimeout caps a non-streaming chat request; inference takes a while
+const chatTimeout = 120 * time.Second
+
+// modelLoadTimeout is how long a server may take to load a model into memory
+const modelLoadTimeout = 5 * time.Minute
+
+// Backend kinds accepted by New
+const (
+	KindLMStudio = "lmstudio"
+	KindOllama   = "ollama"
+	KindLlamaCpp = "llamacpp"
+)
+
+// Model states
+const (
+	StateLoaded    = "loaded"
+	StateNotLoaded = "not-loaded"
+)
+
+// Model describes a model known to the LLM server
+type Model struct {
+	ID                string `json:"id"`
+	Object            string `json:"object"`
+	Type              string `json:"type"` // llm, vlm or embeddings
+	Publisher         string `json:"publisher"`
+	Arch              string `json:"arch"`
+	CompatibilityType string `json:"compatibility_type"` // e.g. gguf or mlx
+	Quantization      string `json:"quantization"`
+	State             string `json:"state"` // "loaded" or "not-loaded"
+	MaxContextLength  int    `json:"max_context_length"`
+	// Resources, 0 when the server doesn't report them
+	LoadedContextLength int   `json:"loaded_context_length,omitempty"` // context window the model was loaded with
+	SizeBytes           int64 `json:"size_bytes,omitempty"`            // size on disk
+	MemoryBytes         int64 `json:"memory_bytes,omitempty"`          // memory used while loaded
+	// ExpiresAt is when the server unloads the idle model on its own (Ollama keep_alive).
+	// It moves later whenever the model is used.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// LoadOptions tune how a model is loaded; zero values keep the server defaults
+type LoadOptions struct {
+	GPU           float64 // GPU offload ratio 0-1
+	ContextLength int     // context window in tokens
+	TTL           int     // idle seconds before the server unloads the model
+
+	// Progress is called with the load progress (0-100) if the server reports it
+	Progress func(percent float64)
+}
+
+// ChatMessage is one message of a chat conversation
+type ChatMessage struct {
+	Role    string `json:"role"` // system, user or assistant
+	Content string `json:"content"`
+}
+
+// ChatRequest is an OpenAI-style chat completion request
+type ChatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []ChatMessage `json:"messages"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	MaxTokens   int           `json:"max_tokens,omitempty"` // -1 for no limit
+	Stream      bool          `json:"stream"`
+}
+
+// ChatUsage is the token usage reported for a chat completion
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatResponse is the answer to a chat completion request
+type ChatResponse struct {
+	Model        string
+	Content      string
+	FinishReason string
+	Usage        ChatUsage
+}
+
+// Backend is a local LLM server
+type Backend interface {
+	// Name is the product name used in entity names, e.g. "LM Studio"
+	Name() string
+	// Available reports whether the tools needed to control the server are installed
+	Available() bool
+	ServerStatus() (bool, error)
+	ListModels() ([]Model, error)
+	LoadModel(modelID string, opts LoadOptions) error
+	UnloadModel(modelID string) error
+	UnloadAllModels() error
+	// Chat runs a chat completion; with request.Stream set, onDelta receives the answer as it is generated
+	Chat(ctx context.Context, request ChatRequest, onDelta func(content string)) (*ChatResponse, error)
+}
+
+// ServerController is implemented by backends whose server can be started and stopped
+type ServerController interface {
+	StartServer() error
+	StopServer() error
+}
+
+// DownloadProgress is a progress report of a model download
+type DownloadProgress struct {
+	Percent        float64 // 0-100
+	BytesPerSecond float64 // 0 if unknown
+	Status         string  // what the server is doing, e.g. "pulling manifest"
+}
+
+// Downloader is implemented by backends that can download models
+type Downloader interface {
+	// Download fetches a model, calling onProgress as it goes; it stops when ctx is cancelled
+	Download(ctx context.Context, modelID string, onProgress func(DownloadProgress)) error
+}
+
+// New returns the backend of the given kind talking to apiURL ("" for the default URL)
+func New(kind, apiURL string) (Backend, error) {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL(kind)
+	}
+	apiURL = strings.TrimRight(apiURL, "/")
+	switch kind {
+	case "", KindLMStudio:
+		return &LMStudio{APIURL: apiURL}, nil
+	case KindOllama:
+		return &Ollama{APIURL: apiURL}, nil
+	case KindLlamaCpp:
+		return &LlamaCpp{APIURL: apiURL}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM backend %q (expected lmstudio, ollama or llamacpp)", kind)
+	}
+}
+
+// DefaultAPIURL returns the address the server of the given kind listens on by default
+func DefaultAPIURL(kind string) string {
+	switch kind {
+	case KindOllama:
+		return "http://localhost:11434"
+	case KindLlamaCpp:
+		return "http://localhost:8080"
+	default:
+		return "http://localhost:1234"
+	}
+}
+
+// SplitModelsByState separates loaded models from the ones that are only available
+func SplitModelsByState(models []Model) (loaded, available []Model) {
+	for _, model := range models {
+		if model.State == StateLoaded {
+			loaded = append(loaded, model)
+		} else {
+			available = append(available, model)
+		}
+	}
+	return loaded, available
+}
+
+// FormatModelList formats a list of models into a readable string
+func FormatModelList(models []Model) string {
+	if len(models) == 0 {
+		return "No models"
+	}
+
+	var parts []string
+	for _, model := range models {
+		parts = append(parts, fmt.Sprintf("%s (%s, %s)", model.ID, model.Type, model.State))
+	}
+	// Use newlines for better display in Home Assistant
+	return strings.Join(parts, "\n")
+}
+
+// isServerUp reports whether a GET of url answers 200; connection errors mean the server is down
+func isServerUp(url string) bool {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return false // Server not running or not reachable
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// doRequest sends req and turns non-200 answers into errors; the caller closes the body
+func doRequest(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &StatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
+
+// StatusError is returned when the server answers with an unexpected HTTP status
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API returned status %d: %s", e.Code, e.Body)
+}
+
+// getJSON decodes the JSON answer of a GET request into out
+func getJSON(ctx context.Context, url string, timeout time.Duration, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse JSON response: %v", err)
+	}
+	return nil
+}
+
+// postJSON posts body as JSON and decodes the answer into out (if not nil)
+func postJSON(ctx context.Context, url string, timeout time.Duration, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse JSON response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		kind, url, wantName, wantURL string
+	}{
+		{"", "", "LM Studio", "http://localhost:1234"},
+		{KindOllama, "", "Ollama", "http://localhost:11434"},
+		{KindLlamaCpp, "http://gpu-box:8080/", "llama.cpp", "http://gpu-box:8080"},
+	}
+	for _, c := range cases {
+		b, err := New(c.kind, c.url)
+		if err != nil {
+			t.Fatalf("New(%q): %v", c.kind, err)
+		}
+		if b.Name() != c.wantName {
+			t.Errorf("New(%q).Name() = %q, want %q", c.kind, b.Name(), c.wantName)
+		}
+		var url string
+		switch s := b.(type) {
+		case *LMStudio:
+			url = s.APIURL
+		case *Ollama:
+			url = s.APIURL
+		case *LlamaCpp:
+			url = s.APIURL
+		}
+		if url != c.wantURL {
+			t.Errorf("New(%q, %q) URL = %q, want %q", c.kind, c.url, url, c.wantURL)
+		}
+	}
+
+	if _, err := New("vllm", ""); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+	if _, ok := interface{}(&LMStudio{}).(ServerController); !ok {
+		t.Error("LM Studio should be controllable")
+	}
+	if _, ok := interface{}(&Ollama{}).(ServerController); ok {
+		t.Error("Ollama should not be controllable")
+	}
+}
+
+func TestOllama(t *testing.T) {
+	var generate, chat []map[string]interface{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/version":
+			fmt.Fprint(w, `{"version":"0.9.0"}`)
+		case "/api/tags":
+			fmt.Fprint(w, `{"models":[
+				{"name":"llama3.2:3b","size":2019393189,"details":{"format":"gguf","family":"llama","quantization_level":"Q4_K_M"}},
+				{"name":"hf.co/bartowski/qwen3:8b","details":{"format":"gguf","family":"qwen3"}}]}`)
+		case "/api/ps":
+			fmt.Fprint(w, `{"models":[{"name":"llama3.2:3b","size":3200000000,"context_length":8192}]}`)
+		case "/api/generate":
+			var req map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&req)
+			generate = append(generate, req)
+			fmt.Fprint(w, `{"done":true}`)
+		case "/api/chat":
+			var req map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&req)
+			chat = append(chat, req)
+			fmt.Fprintln(w, `{"model":"llama3.2:3b","message":{"content":"Hel"},"done":false}`)
+			fmt.Fprintln(w, `{"model":"llama3.2:3b","message":{"content":"lo"},"done":false}`)
+			fmt.Fprintln(w, `{"model":"llama3.2:3b","message":{"content":""},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":2}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+	o := &Ollama{APIURL: api.URL}
+
+	if up, _ := o.ServerStatus(); !up {
+		t.Error("expected the server to be up")
+	}
+
+	models, err := o.ListModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, available := SplitModelsByState(models)
+	if len(loaded) != 1 || loaded[0].ID != "llama3.2:3b" || loaded[0].LoadedContextLength != 8192 || loaded[0].Quantization != "Q4_K_M" ||
+		loaded[0].SizeBytes != 2019393189 || loaded[0].MemoryBytes != 3200000000 {
+		t.Errorf("loaded = %+v", loaded)
+	}
+	if len(available) != 1 || available[0].Publisher != "hf.co/bartowski" {
+		t.Errorf("available = %+v", available)
+	}
+
+	if err := o.LoadModel("llama3.2:3b", LoadOptions{ContextLength: 4096}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.UnloadAllModels(); err != nil {
+		t.Fatal(err)
+	}
+	if len(generate) != 2 {
+		t.Fatalf("expected 2 generate requests, got %v", generate)
+	}
+	if generate[0]["keep_alive"] != float64(-1) || generate[0]["options"].(map[string]interface{})["num_ctx"] != float64(4096) {
+		t.Errorf("load request = %v", generate[0])
+	}
+	if generate[1]["model"] != "llama3.2:3b" || generate[1]["keep_alive"] != float64(0) {
+		t.Errorf("unload request = %v", generate[1])
+	}
+
+	var deltas []string
+	resp, err := o.Chat(context.Background(), ChatRequest{Model: "llama3.2:3b", Stream: true}, func(s string) { deltas = append(deltas, s) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content != "Hello" || resp.FinishReason != "stop" || resp.Usage.TotalTokens != 14 {
+		t.Errorf("chat response = %+v", resp)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" {
+		t.Errorf("deltas = %q", deltas)
+	}
+
+	// A chat must not shorten the keep_alive the model was loaded with
+	if err := o.LoadModel("llama3.2:3b", LoadOptions{TTL: 600}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Chat(context.Background(), ChatRequest{Model: "llama3.2:3b"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(chat) != 2 || chat[0]["keep_alive"] != float64(-1) || chat[1]["keep_alive"] != float64(600) {
+		t.Errorf("chat keep_alive = %v, want -1 before the load and 600 after it", chat)
+	}
+}
+
+func TestLlamaCpp(t *testing.T) {
+	router := true
+	var loads []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/models" && router:
+			fmt.Fprint(w, `{"data":[
+				{"id":"qwen3-8b","owned_by":"llamacpp","status":{"value":"loaded"}},
+				{"id":"gemma-3-12b","owned_by":"llamacpp","status":{"value":"unloaded"}}]}`)
+		case r.URL.Path == "/v1/models":
+			fmt.Fprint(w, `{"data":[{"id":"model.gguf","owned_by":"llamacpp","meta":{"n_ctx_train":40960,"size":4920734016}}]}`)
+		case r.URL.Path == "/models/load" && router:
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			loads = append(loads, req["model"])
+			fmt.Fprint(w, `{"success":true}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+	l := &LlamaCpp{APIURL: api.URL}
+
+	models, err := l.ListModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 || models[0].State != StateLoaded || models[1].State != StateNotLoaded {
+		t.Errorf("router models = %+v", models)
+	}
+	if err := l.LoadModel("gemma-3-12b", LoadOptions{}); err != nil || len(loads) != 1 || loads[0] != "gemma-3-12b" {
+		t.Errorf("LoadModel: err=%v loads=%v", err, loads)
+	}
+
+	router = false
+	models, err = l.ListModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 1 || models[0].State != StateLoaded || models[0].MaxContextLength != 40960 || models[0].SizeBytes != 4920734016 {
+		t.Errorf("single model = %+v", models)
+	}
+	if err := l.LoadModel("other", LoadOptions{}); err == nil || !strings.Contains(err.Error(), "router mode") {
+		t.Errorf("expected a router mode error, got %v", err)
+	}
+}
+
+func TestStreamChatCompletion(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"model\":\"m\",\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "data: {\"model\":\"m\",\n")
+		fmt.Fprint(w, "data: \"choices\":[{\"delta\":{\"content\":\" there\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer api.Close()
+
+	var deltas []string
+	resp, err := chat(context.Background(), api.URL, ChatRequest{Model: "m", Stream: true}, func(s string) { deltas = append(deltas, s) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content != "Hi there" || resp.FinishReason != "stop" {
+		t.Errorf("response = %+v", resp)
+	}
+	if resp.Usage.CompletionTokens != 2 {
+		t.Errorf("expected the chunk count as completion tokens, got %+v", resp.Usage)
+	}
+	if strings.Join(deltas, "|") != "Hi| there" {
+		t.Errorf("deltas = %q", deltas)
+	}
+}
+
+func TestParseLMSProgress(t *testing.T) {
+	cases := []struct {
+		line  string
+		want  DownloadProgress
+		match bool
+	}{
+		{"Downloading qwen3-8b  45.20% | 2.27 GB / 5.03 GB | 31.4 MB/s | ETA 01:28",
+			DownloadProgress{Percent: 45.2, BytesPerSecond: 31.4e6, Status: "downloading"}, true},
+		{"[██████░░░░] 60% 512 KiB/s", DownloadProgress{Percent: 60, BytesPerSecond: 512 * 1024, Status: "downloading"}, true},
+		{"Resolving model qwen/qwen3-8b...", DownloadProgress{}, false},
+	}
+	for _, c := range cases {
+		got, ok := parseLMSProgress(c.line)
+		if ok != c.match || got != c.want {
+			t.Errorf("parseLMSProgress(%q) = %+v, %v; want %+v, %v", c.line, got, ok, c.want, c.match)
+		}
+	}
+}
+
+func TestOllamaDownload(t *testing.T) {
+	lines := []string{
+		`{"status":"pulling manifest"}`,
+		`{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a07","total":4000,"completed":1000}`,
+		`{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a07","total":4000,"completed":4000}`,
+		`{"status":"pulling 4fa551d4f938","digest":"sha256:4fa5","total":12,"completed":12}`,
+		`{"status":"verifying sha256 digest"}`,
+		`{"status":"success"}`,
+	}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/pull" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "nope" {
+			fmt.Fprintln(w, `{"error":"pull model manifest: file does not exist"}`)
+			return
+		}
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}))
+	defer api.Close()
+	o := &Ollama{APIURL: api.URL}
+
+	var percents []float64
+	err := o.Download(context.Background(), "llama3.2:3b", func(p DownloadProgress) { percents = append(percents, p.Percent) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{0, 25, 100, 100, 100, 100}
+	if fmt.Sprint(percents) != fmt.Sprint(want) {
+		t.Errorf("progress = %v, want %v", percents, want)
+	}
+
+	if err := o.Download(context.Background(), "nope", func(DownloadProgress) {}); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected the pull error, got %v", err)
+	}
+}
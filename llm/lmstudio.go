@@ -0,0 +1,278 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LMStudio controls LM Studio through the lms CLI and its /api/v0 REST API
+type LMStudio struct {
+	APIURL string // e.g. http://localhost:1234
+
+	mu        sync.Mutex
+	sizes     map[string]int64 // size on disk by model ID, from lms ls
+	sizesRead time.Time
+}
+
+func (l *LMStudio) Name() string { return "LM Studio" }
+
+// Available checks if lms CLI is installed and accessible
+func (l *LMStudio) Available() bool {
+	_, err := exec.LookPath("lms")
+	return err == nil
+}
+
+// StartServer starts the LM Studio server
+func (l *LMStudio) StartServer() error {
+	llmLog.Info("Starting LM Studio server...")
+	output, err := l.lms("server", "start")
+	if err != nil {
+		return fmt.Errorf("failed to start LM Studio server: %v, output: %s", err, output)
+	}
+
+	llmLog.Debug("LM Studio server start command executed", "output", output)
+	return nil
+}
+
+// StopServer stops the LM Studio server
+func (l *LMStudio) StopServer() error {
+	llmLog.Info("Stopping LM Studio server...")
+	output, err := l.lms("server", "stop")
+	if err != nil {
+		return fmt.Errorf("failed to stop LM Studio server: %v, output: %s", err, output)
+	}
+
+	llmLog.Debug("LM Studio server stop command executed", "output", output)
+	return nil
+}
+
+// ServerStatus checks if the LM Studio server is running
+func (l *LMStudio) ServerStatus() (bool, error) {
+	return isServerUp(l.APIURL + "/api/v0/models"), nil
+}
+
+// ListModels lists all models known to LM Studio, loaded or not
+func (l *LMStudio) ListModels() ([]Model, error) {
+	var result struct {
+		Object string  `json:"object"`
+		Data   []Model `json:"data"`
+	}
+	if err := getJSON(context.Background(), l.APIURL+"/api/v0/models", 10*time.Second, &result); err != nil {
+		return nil, fmt.Errorf("failed to list LM Studio models: %w", err)
+	}
+
+	sizes := l.modelSizes(result.Data)
+	for i := range result.Data {
+		result.Data[i].SizeBytes = sizes[result.Data[i].ID]
+	}
+	return result.Data, nil
+}
+
+// modelSizes returns the size on disk of the downloaded models. The REST API doesn't
+// report it, so it is read from lms ls and read again when an unknown model shows up.
+func (l *LMStudio) modelSizes(models []Model) map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stale := l.sizes == nil
+	for _, model := range models {
+		if _, ok := l.sizes[model.ID]; !ok {
+			stale = true
+		}
+	}
+	if !stale || time.Since(l.sizesRead) < time.Minute || !l.Available() {
+		return l.sizes
+	}
+	l.sizesRead = time.Now()
+
+	output, err := exec.Command("lms", "ls", "--json").Output()
+	if err != nil {
+		llmLog.Debug("Failed to read model sizes from lms ls", "err", err)
+		return l.sizes
+	}
+	var listed []struct {
+		ModelKey  string `json:"modelKey"`
+		SizeBytes int64  `json:"sizeBytes"`
+	}
+	if err := json.Unmarshal(output, &listed); err != nil {
+		llmLog.Debug("Failed to parse lms ls output", "err", err)
+		return l.sizes
+	}
+	l.sizes = make(map[string]int64, len(listed))
+	for _, model := range listed {
+		l.sizes[model.ModelKey] = model.SizeBytes
+	}
+	return l.sizes
+}
+
+// LoadModel loads a model using the lms CLI
+func (l *LMStudio) LoadModel(modelID string, opts LoadOptions) error {
+	args := []string{"load", modelID}
+
+	if opts.GPU > 0 {
+		args = append(args, fmt.Sprintf("--gpu=%.2f", opts.GPU))
+	}
+
+	if opts.ContextLength > 0 {
+		args = append(args, fmt.Sprintf("--context-length=%d", opts.ContextLength))
+	}
+
+	if opts.TTL > 0 {
+		args = append(args, fmt.Sprintf("--ttl=%d", opts.TTL))
+	}
+
+	llmLog.Info("Loading LM Studio model", "model", modelID, "gpu", opts.GPU, "context_length", opts.ContextLength, "ttl", opts.TTL)
+	output, err := l.lmsProgress(context.Background(), args, func(line string) {
+		if progress, ok := parseLMSProgress(line); ok && opts.Progress != nil {
+			opts.Progress(progress.Percent)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load model %s: %v, output: %s", modelID, err, output)
+	}
+
+	llmLog.Info("Model loaded successfully", "model", modelID)
+	llmLog.Debug("lms load output", "model", modelID, "output", output)
+	return nil
+}
+
+// UnloadModel unloads a model using the lms CLI
+func (l *LMStudio) UnloadModel(modelID string) error {
+	llmLog.Info("Unloading LM Studio model", "model", modelID)
+	output, err := l.lms("unload", modelID)
+	if err != nil {
+		return fmt.Errorf("failed to unload model %s: %v, output: %s", modelID, err, output)
+	}
+
+	llmLog.Info("Model unloaded successfully", "model", modelID)
+	llmLog.Debug("lms unload output", "model", modelID, "output", output)
+	return nil
+}
+
+// UnloadAllModels unloads all loaded models
+func (l *LMStudio) UnloadAllModels() error {
+	llmLog.Info("Unloading all LM Studio models...")
+	output, err := l.lms("unload", "--all")
+	if err != nil {
+		return fmt.Errorf("failed to unload all models: %v, output: %s", err, output)
+	}
+
+	llmLog.Info("All models unloaded successfully")
+	llmLog.Debug("lms unload --all output", "output", output)
+	return nil
+}
+
+// Chat sends a chat completion request to a loaded model
+func (l *LMStudio) Chat(ctx context.Context, request ChatRequest, onDelta func(string)) (*ChatResponse, error) {
+	return chat(ctx, l.APIURL+"/api/v0/chat/completions", request, onDelta)
+}
+
+// lms runs the lms CLI and returns its combined output
+func (l *LMStudio) lms(args ...string) (string, error) {
+	if !l.Available() {
+		return "", fmt.Errorf("lms CLI is not installed or not accessible")
+	}
+	output, err := exec.Command("lms", args...).CombinedOutput()
+	return string(output), err
+}
+
+// Download downloads a model with lms get, parsing the progress it prints
+func (l *LMStudio) Download(ctx context.Context, modelID string, onProgress func(DownloadProgress)) error {
+	if !l.Available() {
+		return fmt.Errorf("lms CLI is not installed or not accessible")
+	}
+
+	llmLog.Info("Downloading LM Studio model", "model", modelID)
+	output, err := l.lmsProgress(ctx, []string{"get", modelID, "--yes"}, func(line string) {
+		if progress, ok := parseLMSProgress(line); ok {
+			onProgress(progress)
+		}
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to download model %s: %v, output: %s", modelID, err, output)
+	}
+	llmLog.Info("Model downloaded successfully", "model", modelID)
+	return nil
+}
+
+// lmsProgress runs the lms CLI, passing each line of its output to onLine as it is printed,
+// and returns the last line. Progress bars are redrawn with carriage returns, which end a line too.
+func (l *LMStudio) lmsProgress(ctx context.Context, args []string, onLine func(line string)) (string, error) {
+	if !l.Available() {
+		return "", fmt.Errorf("lms CLI is not installed or not accessible")
+	}
+
+	cmd := exec.CommandContext(ctx, "lms", args...)
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	var lastLine string
+	scanner := bufio.NewScanner(output)
+	scanner.Split(scanLinesOrReturns)
+	for scanner.Scan() {
+		line := strings.TrimSpace(ansiEscape.ReplaceAllString(scanner.Text(), ""))
+		if line == "" {
+			continue
+		}
+		lastLine = line
+		onLine(line)
+	}
+	return lastLine, cmd.Wait()
+}
+
+var (
+	ansiEscape    = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+	progressValue = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%`)
+	speedValue    = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*([KMGT]?i?B)/s`)
+)
+
+// byteUnits are the multipliers of the units lms prints
+var byteUnits = map[string]float64{
+	"B": 1, "KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12,
+	"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40,
+}
+
+// parseLMSProgress reads the percentage and speed from a progress line of lms get or lms load,
+// e.g. "Downloading qwen3-8b  45.20% | 2.27 GB / 5.03 GB | 31.4 MB/s | ETA 01:28"
+func parseLMSProgress(line string) (DownloadProgress, bool) {
+	m := progressValue.FindStringSubmatch(line)
+	if m == nil {
+		return DownloadProgress{}, false
+	}
+	progress := DownloadProgress{Status: "downloading"}
+	progress.Percent, _ = strconv.ParseFloat(m[1], 64)
+	if s := speedValue.FindStringSubmatch(line); s != nil {
+		value, _ := strconv.ParseFloat(s[1], 64)
+		progress.BytesPerSecond = value * byteUnits[s[2]]
+	}
+	return progress, true
+}
+
+// scanLinesOrReturns is a bufio.SplitFunc that ends a line at \n or \r
+func scanLinesOrReturns(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,346 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ollama talks to an Ollama server over its REST API (/api/tags, /api/ps, /api/generate, /api/chat).
+// Ollama loads models on demand; mac2mqtt loads them with an empty generate request and
+// controls how long they stay loaded with keep_alive.
+type Ollama struct {
+	APIURL string // e.g. http://localhost:11434
+
+	mu        sync.Mutex
+	keepAlive map[string]int // keep_alive the models were loaded with, by model ID
+}
+
+// ollamaModel is a model entry of /api/tags and /api/ps
+type ollamaModel struct {
+	Name    string `json:"name"`
+	Model   string `json:"model"`
+	Size    int64  `json:"size"` // on disk in /api/tags, in memory in /api/ps
+	Details struct {
+		Format            string `json:"format"`
+		Family            string `json:"family"`
+		ParameterSize     string `json:"parameter_size"`
+		QuantizationLevel string `json:"quantization_level"`
+	} `json:"details"`
+	ContextLength int       `json:"context_length"` // /api/ps only, newer Ollama versions
+	ExpiresAt     time.Time `json:"expires_at"`     // /api/ps only
+}
+
+func (o *Ollama) Name() string { return "Ollama" }
+
+// Available is always true, Ollama is controlled over HTTP only
+func (o *Ollama) Available() bool { return true }
+
+// ServerStatus checks if the Ollama server is running
+func (o *Ollama) ServerStatus() (bool, error) {
+	return isServerUp(o.APIURL + "/api/version"), nil
+}
+
+// ListModels lists the pulled models, marking the ones in memory as loaded
+func (o *Ollama) ListModels() ([]Model, error) {
+	var tags struct {
+		Models []ollamaModel `json:"models"`
+	}
+	if err := getJSON(context.Background(), o.APIURL+"/api/tags", 10*time.Second, &tags); err != nil {
+		return nil, fmt.Errorf("failed to list Ollama models: %w", err)
+	}
+	running, err := o.runningModels()
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]Model, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		model := Model{
+			ID:                m.Name,
+			Object:            "model",
+			Type:              "llm",
+			Arch:              m.Details.Family,
+			CompatibilityType: m.Details.Format,
+			Quantization:      m.Details.QuantizationLevel,
+			State:             StateNotLoaded,
+			SizeBytes:         m.Size,
+		}
+		if i := strings.LastIndex(m.Name, "/"); i > 0 {
+			model.Publisher = m.Name[:i]
+		}
+		if r, ok := running[m.Name]; ok {
+			model.State = StateLoaded
+			model.LoadedContextLength = r.ContextLength
+			model.MemoryBytes = r.Size
+			if !r.ExpiresAt.IsZero() {
+				expiresAt := r.ExpiresAt
+				model.ExpiresAt = &expiresAt
+			}
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
+
+// runningModels returns the models currently in memory by name
+func (o *Ollama) runningModels() (map[string]ollamaModel, error) {
+	var ps struct {
+		Models []ollamaModel `json:"models"`
+	}
+	if err := getJSON(context.Background(), o.APIURL+"/api/ps", 10*time.Second, &ps); err != nil {
+		return nil, fmt.Errorf("failed to list running Ollama models: %w", err)
+	}
+	running := make(map[string]ollamaModel, len(ps.Models))
+	for _, m := range ps.Models {
+		running[m.Name] = m
+	}
+	return running, nil
+}
+
+// LoadModel loads a model into memory. Without a TTL it stays loaded until it is unloaded.
+// Ollama has no GPU offload ratio (only a layer count), so opts.GPU is ignored.
+func (o *Ollama) LoadModel(modelID string, opts LoadOptions) error {
+	keepAlive := -1
+	if opts.TTL > 0 {
+		keepAlive = opts.TTL
+	}
+	request := map[string]interface{}{
+		"model":      modelID,
+		"keep_alive": keepAlive,
+	}
+	if opts.ContextLength > 0 {
+		request["options"] = map[string]interface{}{"num_ctx": opts.ContextLength}
+	}
+	if opts.GPU > 0 {
+		llmLog.Debug("Ollama does not support a GPU offload ratio, ignoring it", "model", modelID, "gpu", opts.GPU)
+	}
+
+	llmLog.Info("Loading Ollama model", "model", modelID, "context_length", opts.ContextLength, "ttl", opts.TTL)
+	if err := postJSON(context.Background(), o.APIURL+"/api/generate", modelLoadTimeout, request, nil); err != nil {
+		return fmt.Errorf("failed to load model %s: %w", modelID, err)
+	}
+	o.mu.Lock()
+	if o.keepAlive == nil {
+		o.keepAlive = make(map[string]int)
+	}
+	o.keepAlive[modelID] = keepAlive
+	o.mu.Unlock()
+	llmLog.Info("Model loaded successfully", "model", modelID)
+	return nil
+}
+
+// modelKeepAlive returns the keep_alive to send with requests for a model. Ollama
+// applies a request's keep_alive (5 minutes if it has none) to the model, so every
+// request repeats the one it was loaded with; -1 for models not loaded by LoadModel.
+func (o *Ollama) modelKeepAlive(modelID string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if keepAlive, ok := o.keepAlive[modelID]; ok {
+		return keepAlive
+	}
+	return -1
+}
+
+// UnloadModel removes a model from memory
+func (o *Ollama) UnloadModel(modelID string) error {
+	llmLog.Info("Unloading Ollama model", "model", modelID)
+	request := map[string]interface{}{"model": modelID, "keep_alive": 0}
+	if err := postJSON(context.Background(), o.APIURL+"/api/generate", 30*time.Second, request, nil); err != nil {
+		return fmt.Errorf("failed to unload model %s: %w", modelID, err)
+	}
+	o.mu.Lock()
+	delete(o.keepAlive, modelID)
+	o.mu.Unlock()
+	llmLog.Info("Model unloaded successfully", "model", modelID)
+	return nil
+}
+
+// UnloadAllModels unloads every model in memory
+func (o *Ollama) UnloadAllModels() error {
+	running, err := o.runningModels()
+	if err != nil {
+		return err
+	}
+	for name := range running {
+		if err := o.UnloadModel(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ollamaChatChunk is the /api/chat answer, or one line of it when streaming
+type ollamaChatChunk struct {
+	Model   string `json:"model"`
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// Chat sends a chat request to /api/chat; streamed answers arrive as one JSON object per line
+func (o *Ollama) Chat(ctx context.Context, request ChatRequest, onDelta func(string)) (*ChatResponse, error) {
+	options := map[string]interface{}{}
+	if request.Temperature != nil {
+		options["temperature"] = *request.Temperature
+	}
+	if request.MaxTokens > 0 {
+		options["num_predict"] = request.MaxTokens
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"model":      request.Model,
+		"messages":   request.Messages,
+		"stream":     request.Stream,
+		"options":    options,
+		"keep_alive": o.modelKeepAlive(request.Model), // without it the model is unloaded 5 minutes after the chat
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	if !request.Stream {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, chatTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.APIURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &ChatResponse{Model: request.Model}
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChatChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %v", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("ollama error: %s", chunk.Error)
+		}
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if request.Stream && onDelta != nil {
+				onDelta(chunk.Message.Content)
+			}
+		}
+		if chunk.Done {
+			result.FinishReason = chunk.DoneReason
+			result.Usage = ChatUsage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	result.Content = content.String()
+	return result, nil
+}
+
+// Download pulls a model from the Ollama library; /api/pull streams one status object per line
+func (o *Ollama) Download(ctx context.Context, modelID string, onProgress func(DownloadProgress)) error {
+	body, err := json.Marshal(map[string]interface{}{"model": modelID, "stream": true})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.APIURL+"/api/pull", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	llmLog.Info("Pulling Ollama model", "model", modelID)
+	resp, err := doRequest(req)
+	if err != nil {
+		return fmt.Errorf("failed to download model %s: %w", modelID, err)
+	}
+	defer resp.Body.Close()
+
+	// Progress follows the largest layer (the weights); speed is measured between
+	// progress lines of the same layer
+	var maxTotal, lastCompleted int64
+	var lastDigest string
+	var lastTime time.Time
+	var percent, speed float64
+	succeeded := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var status struct {
+			Status    string `json:"status"`
+			Digest    string `json:"digest"`
+			Total     int64  `json:"total"`
+			Completed int64  `json:"completed"`
+			Error     string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &status); err != nil {
+			continue
+		}
+		if status.Error != "" {
+			return fmt.Errorf("failed to download model %s: %s", modelID, status.Error)
+		}
+
+		if status.Total > 0 {
+			now := time.Now()
+			if status.Digest != lastDigest {
+				lastDigest, lastCompleted, lastTime, speed = status.Digest, status.Completed, now, 0
+			} else if now.Sub(lastTime) >= time.Second {
+				speed = float64(status.Completed-lastCompleted) / now.Sub(lastTime).Seconds()
+				lastCompleted, lastTime = status.Completed, now
+			}
+			if status.Total >= maxTotal {
+				maxTotal = status.Total
+				percent = float64(status.Completed) / float64(status.Total) * 100
+			}
+		}
+		if status.Status == "success" {
+			percent, speed, succeeded = 100, 0, true
+		}
+		onProgress(DownloadProgress{Percent: percent, BytesPerSecond: speed, Status: status.Status})
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to read download progress: %v", err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !succeeded {
+		return fmt.Errorf("download of model %s ended before it finished", modelID)
+	}
+	llmLog.Info("Model downloaded successfully", "model", modelID)
+	return nil
+}
@@ -0,0 +1,181 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// chat runs request against an OpenAI-compatible chat completions endpoint
+func chat(ctx context.Context, endpoint string, request ChatRequest, onDelta func(string)) (*ChatResponse, error) {
+	if request.Stream {
+		return streamChatCompletion(ctx, endpoint, request, onDelta)
+	}
+	return chatCompletion(ctx, endpoint, request)
+}
+
+// chatCompletion sends a non-streaming request to an OpenAI-compatible chat completions endpoint
+func chatCompletion(ctx context.Context, endpoint string, request ChatRequest) (*ChatResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, chatTimeout)
+	defer cancel()
+
+	request.Stream = false
+	resp, err := postChatRequest(ctx, endpoint, request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var result struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage ChatUsage `json:"usage"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	if len(result.Choices) == 0 {
+		return nil, fmt.Errorf("no response from model")
+	}
+
+	return &ChatResponse{
+		Model:        result.Model,
+		Content:      result.Choices[0].Message.Content,
+		FinishReason: result.Choices[0].FinishReason,
+		Usage:        result.Usage,
+	}, nil
+}
+
+// streamChatCompletion sends a streaming request to an OpenAI-compatible chat completions
+// endpoint and calls onDelta with every piece of content as it arrives (server-sent events).
+// The returned response holds the full answer. Servers report usage on the last event,
+// if at all; without it CompletionTokens counts the content events instead.
+func streamChatCompletion(ctx context.Context, endpoint string, request ChatRequest, onDelta func(content string)) (*ChatResponse, error) {
+	request.Stream = true
+	resp, err := postChatRequest(ctx, endpoint, request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &ChatResponse{Model: request.Model}
+	var content strings.Builder
+	chunks := 0
+
+	// handleEvent processes the data of one SSE event; it reports false after [DONE]
+	handleEvent := func(data string) (bool, error) {
+		if data == "" {
+			return true, nil
+		}
+		if data == "[DONE]" {
+			return false, nil
+		}
+
+		var event struct {
+			Model   string `json:"model"`
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *ChatUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return false, fmt.Errorf("failed to parse stream event: %v", err)
+		}
+
+		if event.Model != "" {
+			result.Model = event.Model
+		}
+		if event.Usage != nil {
+			result.Usage = *event.Usage
+		}
+		for _, choice := range event.Choices {
+			if choice.Delta.Content != "" {
+				chunks++
+				content.WriteString(choice.Delta.Content)
+				if onDelta != nil {
+					onDelta(choice.Delta.Content)
+				}
+			}
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				result.FinishReason = *choice.FinishReason
+			}
+		}
+		return true, nil
+	}
+
+	// An event is one or more data lines ended by a blank line
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	more := true
+	for more && scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			more, err = handleEvent(strings.Join(data, "\n"))
+			if err != nil {
+				return nil, err
+			}
+			data = data[:0]
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+		// Other fields (event, id, retry) and comments are not used by the chat API
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %v", err)
+	}
+	if more && len(data) > 0 {
+		// Stream ended without the final blank line
+		if _, err := handleEvent(strings.Join(data, "\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Content = content.String()
+	if result.Usage.CompletionTokens == 0 {
+		result.Usage.CompletionTokens = chunks
+		result.Usage.TotalTokens = result.Usage.PromptTokens + chunks
+	}
+	return result, nil
+}
+
+// postChatRequest posts request to a chat completions endpoint and checks the status
+func postChatRequest(ctx context.Context, endpoint string, request ChatRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if request.Stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+
+	return doRequest(req)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"bessarabov/mac2mqtt/llm"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// chatStreamTimeout caps a streaming chat request, which has no per-request HTTP timeout
+const chatStreamTimeout = 10 * time.Minute
+
+// chatIDPattern limits chat request IDs to a single, wildcard-free topic level
+var chatIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// lmstudioChatRequest is the payload of /command/lmstudio_chat
+type lmstudioChatRequest struct {
+	ID          string            `json:"id"`    // answer goes to /status/lmstudio_chat/<id>
+	Model       string            `json:"model"` // default: the first loaded model
+	Messages    []llm.ChatMessage `json:"messages"`
+	System      string            `json:"system"` // optional system prompt put before the messages
+	Temperature *float64          `json:"temperature"`
+	Stream      bool              `json:"stream"` // publish the answer to /status/lmstudio_chat/<id>/delta as it is generated
+}
+
+// lmstudioChatResult is published to /status/lmstudio_chat/<id>
+type lmstudioChatResult struct {
+	ID                 string         `json:"id"`
+	Model              string         `json:"model,omitempty"`
+	Content            string         `json:"content,omitempty"`
+	FinishReason       string         `json:"finish_reason,omitempty"` // "cancelled" when stopped by lmstudio_chat_cancel
+	Usage              *llm.ChatUsage `json:"usage,omitempty"`
+	LatencyMS          int64          `json:"latency_ms"`
+	TimeToFirstTokenMS int64          `json:"time_to_first_token_ms,omitempty"` // streaming requests only
+	TokensPerSecond    float64        `json:"tokens_per_second,omitempty"`
+	Error              string         `json:"error,omitempty"`
+}
+
+// parseLMStudioChatRequest decodes and validates a chat request.
+// The ID is filled in whenever it is valid, so errors can be reported to the caller.
+func parseLMStudioChatRequest(payload string) (lmstudioChatRequest, error) {
+	var req lmstudioChatRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return req, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if !chatIDPattern.MatchString(req.ID) {
+		id := req.ID
+		req.ID = ""
+		return req, fmt.Errorf("invalid id %q (use 1-64 letters, digits, '.', '_' or '-')", id)
+	}
+	req.Model = strings.TrimSpace(req.Model)
+
+	if len(req.Messages) == 0 {
+		return req, fmt.Errorf("messages must not be empty")
+	}
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "system", "user", "assistant":
+		default:
+			return req, fmt.Errorf("invalid message role %q", m.Role)
+		}
+	}
+	if req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 2) {
+		return req, fmt.Errorf("temperature must be between 0 and 2, got %v", *req.Temperature)
+	}
+	return req, nil
+}
+
+// handleLMStudioChat starts a chat request in the background; the answer is published when it's ready
+func (app *Application) handleLMStudioChat(client mqtt.Client, payload string) {
+	req, err := parseLMStudioChatRequest(payload)
+	if err != nil {
+		lmstudioLog.Warn("Invalid LM Studio chat request", "id", req.ID, "err", err)
+		if req.ID != "" {
+			app.publishLMStudioChatResult(client, lmstudioChatResult{ID: req.ID, Error: err.Error()})
+		}
+		return
+	}
+
+	// Register before starting so a cancel right after the request finds it
+	ctx, cancel := context.WithCancel(context.Background())
+	app.chatMutex.Lock()
+	if _, running := app.chats[req.ID]; running {
+		app.chatMutex.Unlock()
+		cancel()
+		lmstudioLog.Warn("LM Studio chat request is already running", "id", req.ID)
+		app.publishLMStudioChatResult(client, lmstudioChatResult{ID: req.ID, Error: "a request with this id is already running"})
+		return
+	}
+	app.chats[req.ID] = cancel
+	app.chatMutex.Unlock()
+
+	go func() {
+		defer func() {
+			app.chatMutex.Lock()
+			delete(app.chats, req.ID)
+			app.chatMutex.Unlock()
+			cancel()
+		}()
+		app.runLMStudioChat(ctx, client, req)
+	}()
+}
+
+// handleLMStudioChatCancel stops the in-flight chat request with the given ID, or all of them for "all"
+func (app *Application) handleLMStudioChatCancel(payload string) {
+	id := strings.TrimSpace(payload)
+
+	app.chatMutex.Lock()
+	defer app.chatMutex.Unlock()
+	if strings.EqualFold(id, "all") {
+		for chatID, cancel := range app.chats {
+			lmstudioLog.Info("Cancelling LM Studio chat request", "id", chatID)
+			cancel()
+		}
+		return
+	}
+	cancel, ok := app.chats[id]
+	if !ok {
+		lmstudioLog.Warn("No running LM Studio chat request to cancel", "id", id)
+		return
+	}
+	lmstudioLog.Info("Cancelling LM Studio chat request", "id", id)
+	cancel()
+}
+
+// runLMStudioChat sends a chat request to a loaded model and publishes the answer
+func (app *Application) runLMStudioChat(ctx context.Context, client mqtt.Client, req lmstudioChatRequest) {
+	start := time.Now()
+	result := lmstudioChatResult{ID: req.ID}
+	defer func() {
+		result.LatencyMS = time.Since(start).Milliseconds()
+		app.publishLMStudioChatResult(client, result)
+		app.lmstudioStats.record(result)
+		app.publishLMStudioStats(client)
+	}()
+
+	model, err := app.lmstudioChatModel(req.Model)
+	if err != nil {
+		lmstudioLog.Warn("Rejected LM Studio chat request", "id", req.ID, "err", err)
+		result.Error = err.Error()
+		return
+	}
+	result.Model = model
+	app.lmstudioIdle.touch(model, start)
+	defer func() { app.lmstudioIdle.touch(model, time.Now()) }()
+
+	messages := req.Messages
+	if req.System != "" {
+		messages = append([]llm.ChatMessage{{Role: "system", Content: req.System}}, messages...)
+	}
+
+	// Streamed answers are published piece by piece; the first piece starts the generation clock
+	var firstToken time.Time
+	var partial strings.Builder
+	var onDelta func(string)
+	if req.Stream {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, chatStreamTimeout)
+		defer cancel()
+
+		deltaTopic := app.getTopicPrefix() + "/status/lmstudio_chat/" + req.ID + "/delta"
+		onDelta = func(content string) {
+			if firstToken.IsZero() {
+				firstToken = time.Now()
+			}
+			partial.WriteString(content)
+			client.Publish(deltaTopic, 0, false, content)
+		}
+	}
+
+	lmstudioLog.Info("Running LM Studio chat request", "id", req.ID, "model", model, "messages", len(messages), "stream", req.Stream)
+	resp, err := app.llmServer.Chat(ctx, llm.ChatRequest{
+		Model:       model,
+		Messages:    messages,
+		Temperature: req.Temperature,
+		MaxTokens:   -1,
+		Stream:      req.Stream,
+	}, onDelta)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			// Cancelled on request: report what was generated so far
+			lmstudioLog.Info("LM Studio chat request cancelled", "id", req.ID, "model", model)
+			result.Content = partial.String()
+			result.FinishReason = "cancelled"
+			return
+		}
+		lmstudioLog.Error("LM Studio chat request failed", "id", req.ID, "model", model, "err", err)
+		result.Error = err.Error()
+		return
+	}
+
+	result.Content = resp.Content
+	result.FinishReason = resp.FinishReason
+	result.Usage = &resp.Usage
+
+	generationStart := start
+	if !firstToken.IsZero() {
+		result.TimeToFirstTokenMS = firstToken.Sub(start).Milliseconds()
+		generationStart = firstToken
+	}
+	if elapsed := time.Since(generationStart).Seconds(); elapsed > 0 && resp.Usage.CompletionTokens > 0 {
+		result.TokensPerSecond = math.Round(float64(resp.Usage.CompletionTokens)/elapsed*100) / 100
+	}
+
+	lmstudioLog.Info("LM Studio chat request finished", "id", req.ID, "model", model,
+		"tokens", resp.Usage.TotalTokens, "tokens_per_second", result.TokensPerSecond, "latency", time.Since(start).Round(time.Millisecond))
+}
+
+// lmstudioChatModel returns the loaded model to chat with: the requested one, or the first loaded one
+func (app *Application) lmstudioChatModel(requested string) (string, error) {
+	models, err := app.llmServer.ListModels()
+	if err != nil {
+		return "", fmt.Errorf("%s server is not available: %v", app.llmServer.Name(), err)
+	}
+
+	for _, model := range models {
+		if model.State != llm.StateLoaded {
+			continue
+		}
+		if requested == "" || model.ID == requested {
+			return model.ID, nil
+		}
+	}
+
+	if requested != "" {
+		return "", fmt.Errorf("model %s is not loaded", requested)
+	}
+	return "", fmt.Errorf("no model is loaded")
+}
+
+func (app *Application) publishLMStudioChatResult(client mqtt.Client, result lmstudioChatResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		lmstudioLog.Error("Failed to encode LM Studio chat result", "id", result.ID, "err", err)
+		return
+	}
+	client.Publish(app.getTopicPrefix()+"/status/lmstudio_chat/"+result.ID, 0, false, string(data))
+}
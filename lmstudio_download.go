@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"bessarabov/mac2mqtt/llm"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Download states
+const (
+	downloadStateDownloading = "downloading"
+	downloadStateCompleted   = "completed"
+	downloadStateFailed      = "failed"
+	downloadStateCancelled   = "cancelled"
+)
+
+// lmstudioDownloadStatus is published to /status/lmstudio_download
+type lmstudioDownloadStatus struct {
+	Model          string  `json:"model"`
+	State          string  `json:"state"` // downloading, completed, failed or cancelled
+	Progress       float64 `json:"progress"`
+	BytesPerSecond float64 `json:"bytes_per_second,omitempty"`
+	Status         string  `json:"status,omitempty"` // server's description of the current step
+	Error          string  `json:"error,omitempty"`
+}
+
+// handleLMStudioDownload starts downloading a model in the background
+func (app *Application) handleLMStudioDownload(client mqtt.Client, payload string) {
+	modelID := strings.TrimSpace(payload)
+	downloader, ok := app.llmServer.(llm.Downloader)
+	if !ok {
+		lmstudioLog.Warn("The LLM server can't download models", "server", app.llmServer.Name())
+		return
+	}
+	// The model ID is passed to lms get as an argument, so it must not look like a flag
+	if modelID == "" || strings.HasPrefix(modelID, "-") || strings.ContainsAny(modelID, " \t\r\n") {
+		lmstudioLog.Warn("Invalid model to download", "model", modelID)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	app.downloadMutex.Lock()
+	// All downloads report on the one lmstudio_download topic, so only one runs at a time
+	if len(app.downloads) > 0 {
+		app.downloadMutex.Unlock()
+		cancel()
+		lmstudioLog.Warn("A model is already being downloaded, ignoring command", "model", modelID)
+		return
+	}
+	app.downloads[modelID] = cancel
+	app.downloadMutex.Unlock()
+
+	go func() {
+		defer func() {
+			app.downloadMutex.Lock()
+			delete(app.downloads, modelID)
+			app.downloadMutex.Unlock()
+			cancel()
+		}()
+		app.runLMStudioDownload(ctx, client, downloader, modelID)
+	}()
+}
+
+// handleLMStudioDownloadCancel stops the download of a model, or all downloads for "all"
+func (app *Application) handleLMStudioDownloadCancel(payload string) {
+	modelID := strings.TrimSpace(payload)
+
+	app.downloadMutex.Lock()
+	defer app.downloadMutex.Unlock()
+	if strings.EqualFold(modelID, "all") {
+		for id, cancel := range app.downloads {
+			lmstudioLog.Info("Cancelling model download", "model", id)
+			cancel()
+		}
+		return
+	}
+	cancel, ok := app.downloads[modelID]
+	if !ok {
+		lmstudioLog.Warn("No running download to cancel", "model", modelID)
+		return
+	}
+	lmstudioLog.Info("Cancelling model download", "model", modelID)
+	cancel()
+}
+
+// runLMStudioDownload downloads a model, publishing its progress, and refreshes the model list when it's done
+func (app *Application) runLMStudioDownload(ctx context.Context, client mqtt.Client, downloader llm.Downloader, modelID string) {
+	status := lmstudioDownloadStatus{Model: modelID, State: downloadStateDownloading}
+	app.publishLMStudioDownloadStatus(client, status)
+
+	var lastPublish time.Time
+	err := downloader.Download(ctx, modelID, func(progress llm.DownloadProgress) {
+		status.Progress = math.Round(progress.Percent*10) / 10
+		status.BytesPerSecond = math.Round(progress.BytesPerSecond)
+		status.Status = progress.Status
+		if time.Since(lastPublish) >= progressPublishInterval {
+			lastPublish = time.Now()
+			app.publishLMStudioDownloadStatus(client, status)
+		}
+	})
+
+	status.BytesPerSecond = 0
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		lmstudioLog.Info("Model download cancelled", "model", modelID)
+		status.State = downloadStateCancelled
+	case err != nil:
+		lmstudioLog.Error("Model download failed", "model", modelID, "err", err)
+		status.State = downloadStateFailed
+		status.Error = err.Error()
+	default:
+		lmstudioLog.Info("Model download finished", "model", modelID)
+		status.State = downloadStateCompleted
+		status.Progress = 100
+	}
+	app.publishLMStudioDownloadStatus(client, status)
+
+	if status.State == downloadStateCompleted {
+		// Picks up the new model and republishes the per-model discovery
+		app.updateLMStudioStatus(client)
+	}
+}
+
+func (app *Application) publishLMStudioDownloadStatus(client mqtt.Client, status lmstudioDownloadStatus) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		lmstudioLog.Error("Failed to encode download status", "model", status.Model, "err", err)
+		return
+	}
+	client.Publish(app.getTopicPrefix()+"/status/lmstudio_download", 0, true, string(data))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"bessarabov/mac2mqtt/llm"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// lmstudioIdleTracker remembers when each loaded model was last used
+type lmstudioIdleTracker struct {
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+	expires  map[string]time.Time // server-side expiry seen at the last update
+}
+
+func newLMStudioIdleTracker() *lmstudioIdleTracker {
+	return &lmstudioIdleTracker{
+		lastUsed: make(map[string]time.Time),
+		expires:  make(map[string]time.Time),
+	}
+}
+
+// touch records a use of the model
+func (t *lmstudioIdleTracker) touch(modelID string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastUsed[modelID] = now
+}
+
+// observe updates the tracker from the server's model list. Models that are
+// newly loaded count as used now, as do models whose server-side expiry moved
+// later (the server saw a request); unloaded models are forgotten.
+func (t *lmstudioIdleTracker) observe(models []llm.Model, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	loaded := make(map[string]bool)
+	for _, model := range models {
+		if model.State != llm.StateLoaded {
+			continue
+		}
+		loaded[model.ID] = true
+		if _, ok := t.lastUsed[model.ID]; !ok {
+			t.lastUsed[model.ID] = now
+		}
+		if model.ExpiresAt != nil {
+			if previous, ok := t.expires[model.ID]; ok && model.ExpiresAt.After(previous) {
+				t.lastUsed[model.ID] = now
+			}
+			t.expires[model.ID] = *model.ExpiresAt
+		}
+	}
+	for id := range t.lastUsed {
+		if !loaded[id] {
+			delete(t.lastUsed, id)
+			delete(t.expires, id)
+		}
+	}
+}
+
+// lastUse returns when the model was last used, if it is loaded
+func (t *lmstudioIdleTracker) lastUse(modelID string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lastUsed, ok := t.lastUsed[modelID]
+	return lastUsed, ok
+}
+
+// lmstudioModelTTL returns how long the model may stay idle before it is unloaded (0: never)
+func (app *Application) lmstudioModelTTL(modelID string) (ttl time.Duration, pinned bool) {
+	if slices.Contains(app.config.LMStudioPinnedModels, modelID) {
+		return 0, true
+	}
+	if seconds, ok := app.config.LMStudioModelTTLs[modelID]; ok {
+		return time.Duration(seconds) * time.Second, false
+	}
+	return time.Duration(app.config.LMStudioIdleTTL) * time.Second, false
+}
+
+// unloadIdleLMStudioModels unloads the models idle longer than their TTL. The
+// unloads run in the background like the unload command, so the models show
+// "unloading" meanwhile and a load command for them is refused as busy.
+func (app *Application) unloadIdleLMStudioModels(client mqtt.Client, models []llm.Model) {
+	now := time.Now()
+	app.lmstudioIdle.observe(models, now)
+
+	for _, model := range models {
+		if model.State != llm.StateLoaded {
+			continue
+		}
+		// A failed unload isn't retried until the next command, so a model that can't be unloaded doesn't loop
+		if op, ok := app.lmstudioOps.get(model.ID); ok && (op.running() || op.Failed == modelStateUnloading) {
+			continue
+		}
+		ttl, _ := app.lmstudioModelTTL(model.ID)
+		lastUsed, ok := app.lmstudioIdle.lastUse(model.ID)
+		if ttl <= 0 || !ok || now.Sub(lastUsed) < ttl {
+			continue
+		}
+
+		idle := now.Sub(lastUsed).Round(time.Second)
+		lmstudioLog.Info("Unloading idle model", "model", model.ID, "idle", idle, "ttl", ttl)
+		app.unloadLMStudioModel(client, model.ID)
+	}
+}
+
+// lmstudioModelAttributes is the JSON attributes payload of a model switch
+type lmstudioModelAttributes struct {
+	SizeBytes     int64  `json:"size_bytes,omitempty"`   // size on disk
+	MemoryBytes   int64  `json:"memory_bytes,omitempty"` // memory used when loaded, estimated unless the server reports it
+	Quantization  string `json:"quantization,omitempty"`
+	ContextLength int    `json:"context_length,omitempty"` // loaded context window, or the maximum if not loaded
+	LastUsed      string `json:"last_used,omitempty"`      // RFC 3339, loaded models only
+	IdleTTL       int    `json:"idle_ttl"`                 // seconds, 0 when the model is never unloaded automatically
+	UnloadIn      *int   `json:"unload_in"`                // seconds until the model is unloaded, null if it won't be
+	Pinned        bool   `json:"pinned"`
+
+	Operation    string   `json:"operation,omitempty"`     // loading, unloading or error
+	LoadProgress *float64 `json:"load_progress,omitempty"` // 0-100 while loading, if the server reports it
+	Error        string   `json:"error,omitempty"`         // why the last load or unload failed
+}
+
+// publishLMStudioModelAttributes publishes the resources and idle countdown of a model switch
+func (app *Application) publishLMStudioModelAttributes(client mqtt.Client, model llm.Model) {
+	ttl, pinned := app.lmstudioModelTTL(model.ID)
+	attributes := lmstudioModelAttributes{
+		SizeBytes:     model.SizeBytes,
+		MemoryBytes:   lmstudioModelMemory(model),
+		Quantization:  model.Quantization,
+		ContextLength: model.MaxContextLength,
+		IdleTTL:       int(ttl.Seconds()),
+		Pinned:        pinned,
+	}
+	if model.State == llm.StateLoaded && model.LoadedContextLength > 0 {
+		attributes.ContextLength = model.LoadedContextLength
+	}
+	if lastUsed, ok := app.lmstudioIdle.lastUse(model.ID); ok && model.State == llm.StateLoaded {
+		attributes.LastUsed = lastUsed.Format(time.RFC3339)
+		if ttl > 0 {
+			unloadIn := max(0, int((ttl - time.Since(lastUsed)).Seconds()))
+			attributes.UnloadIn = &unloadIn
+		}
+	}
+
+	if op, ok := app.lmstudioOps.get(model.ID); ok {
+		attributes.Operation = op.State
+		attributes.LoadProgress = op.Progress
+		attributes.Error = op.Error
+	}
+
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		lmstudioLog.Error("Failed to encode model attributes", "model", model.ID, "err", err)
+		return
+	}
+	client.Publish(app.getTopicPrefix()+"/status/lmstudio_model_"+app.lmstudioModelTopic(model.ID)+"/attributes", 0, true, string(data))
+}
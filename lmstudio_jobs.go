@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"bessarabov/mac2mqtt/llm"
+	"bessarabov/mac2mqtt/state"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// progressPublishInterval limits how often the progress of long operations is published
+const progressPublishInterval = time.Second
+
+// Transitional model states, published on the model's status topic instead of ON/OFF
+const (
+	modelStateLoading   = "loading"
+	modelStateUnloading = "unloading"
+	modelStateError     = "error"
+)
+
+// lmstudioModelOp is the load or unload running on a model, or the error of the last one
+type lmstudioModelOp struct {
+	State    string   // loading, unloading or error; empty once the server reports another model state after an error
+	Progress *float64 // load progress 0-100, if the server reports it
+	Error    string
+
+	Failed     string // the operation that failed: loading or unloading
+	ModelState string // the model state the server reported when it failed
+}
+
+func (op lmstudioModelOp) running() bool {
+	return op.State == modelStateLoading || op.State == modelStateUnloading
+}
+
+// lmstudioModelOps tracks the loads and unloads of each model
+type lmstudioModelOps struct {
+	mu  sync.Mutex
+	ops map[string]lmstudioModelOp
+}
+
+// start marks an operation as running; it reports false if one is already running on the model
+func (o *lmstudioModelOps) start(modelID, state string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.ops == nil {
+		o.ops = make(map[string]lmstudioModelOp)
+	}
+	if o.ops[modelID].running() {
+		return false
+	}
+	o.ops[modelID] = lmstudioModelOp{State: state}
+	return true
+}
+
+func (o *lmstudioModelOps) progress(modelID string, percent float64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if op, ok := o.ops[modelID]; ok && op.running() {
+		percent = math.Round(percent*10) / 10
+		op.Progress = &percent
+		o.ops[modelID] = op
+	}
+}
+
+// finish ends the running operation, keeping its error until the next one starts
+func (o *lmstudioModelOps) finish(modelID string, err error, modelState string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err == nil {
+		delete(o.ops, modelID)
+		return
+	}
+	o.ops[modelID] = lmstudioModelOp{
+		State:      modelStateError,
+		Error:      err.Error(),
+		Failed:     o.ops[modelID].State,
+		ModelState: modelState,
+	}
+}
+
+// observe returns the operation of a model given the state the server reports now.
+// A failed operation stops showing "error" once the state differs from when it
+// failed, like a load that timed out but finished after all; the message is kept.
+func (o *lmstudioModelOps) observe(modelID, modelState string) (lmstudioModelOp, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	op, ok := o.ops[modelID]
+	if ok && op.State == modelStateError && modelState != op.ModelState {
+		op.State, op.Failed = "", ""
+		o.ops[modelID] = op
+	}
+	return op, ok
+}
+
+func (o *lmstudioModelOps) get(modelID string) (lmstudioModelOp, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	op, ok := o.ops[modelID]
+	return op, ok
+}
+
+// loadLMStudioModel loads a model in the background, publishing its progress
+func (app *Application) loadLMStudioModel(client mqtt.Client, modelID string, opts llm.LoadOptions) {
+	app.runLMStudioModelOp(client, modelID, modelStateLoading, app.lmstudioLoadFunc(client, modelID, opts))
+}
+
+// unloadLMStudioModel unloads a model in the background
+func (app *Application) unloadLMStudioModel(client mqtt.Client, modelID string) {
+	app.runLMStudioModelOp(client, modelID, modelStateUnloading, func() error {
+		return app.llmServer.UnloadModel(modelID)
+	})
+}
+
+// lmstudioLoadFunc returns a function that loads the model, publishing its load progress
+func (app *Application) lmstudioLoadFunc(client mqtt.Client, modelID string, opts llm.LoadOptions) func() error {
+	var lastPublish time.Time
+	opts.Progress = func(percent float64) {
+		app.lmstudioOps.progress(modelID, percent)
+		if time.Since(lastPublish) >= progressPublishInterval {
+			lastPublish = time.Now()
+			app.publishLMStudioModelAttributes(client, app.lmstudioModel(modelID))
+		}
+	}
+
+	return func() error {
+		if err := app.llmServer.LoadModel(modelID, opts); err != nil {
+			return err
+		}
+		app.lmstudioIdle.touch(modelID, time.Now())
+		return nil
+	}
+}
+
+// runLMStudioModelOp runs a load or unload in the background. The model shows the
+// transitional state until it's done, then the status is refreshed right away.
+func (app *Application) runLMStudioModelOp(client mqtt.Client, modelID, opState string, run func() error) {
+	if !app.startLMStudioModelOp(client, modelID, opState) {
+		return
+	}
+	go func() {
+		app.finishLMStudioModelOp(client, modelID, opState, run())
+		app.updateLMStudioStatus(client)
+	}()
+}
+
+// startLMStudioModelOp marks a load or unload as running and publishes the transitional state.
+// It reports false if the model is busy with another one.
+func (app *Application) startLMStudioModelOp(client mqtt.Client, modelID, opState string) bool {
+	if !app.lmstudioOps.start(modelID, opState) {
+		lmstudioLog.Warn("Model is busy, ignoring command", "model", modelID, "command", opState)
+		return false
+	}
+	lmstudioLog.Info("Model operation started", "model", modelID, "state", opState)
+	app.publishLMStudioModelOp(client, modelID)
+	return true
+}
+
+// finishLMStudioModelOp ends a load or unload, publishing the error if it failed
+func (app *Application) finishLMStudioModelOp(client mqtt.Client, modelID, opState string, err error) {
+	app.lmstudioOps.finish(modelID, err, app.lmstudioModel(modelID).State)
+	if err != nil {
+		lmstudioLog.Error("Model operation failed", "model", modelID, "state", opState, "err", err)
+		app.publishLMStudioModelOp(client, modelID)
+		return
+	}
+	lmstudioLog.Info("Model operation finished", "model", modelID, "state", opState)
+}
+
+// unloadAllLMStudioModels unloads every model in the background; the loaded ones show "unloading" meanwhile
+func (app *Application) unloadAllLMStudioModels(client mqtt.Client) {
+	var unloading []string
+	for _, model := range state.Value(app.store, lmstudioModelsKey) {
+		if model.State == llm.StateLoaded && app.startLMStudioModelOp(client, model.ID, modelStateUnloading) {
+			unloading = append(unloading, model.ID)
+		}
+	}
+
+	go func() {
+		err := app.llmServer.UnloadAllModels()
+		for _, modelID := range unloading {
+			app.finishLMStudioModelOp(client, modelID, modelStateUnloading, err)
+		}
+		app.updateLMStudioStatus(client)
+	}()
+}
+
+// publishLMStudioModelOp publishes the transitional state of a model and its attributes
+func (app *Application) publishLMStudioModelOp(client mqtt.Client, modelID string) {
+	op, ok := app.lmstudioOps.get(modelID)
+	if !ok {
+		return
+	}
+	client.Publish(app.getTopicPrefix()+"/status/lmstudio_model_"+app.lmstudioModelTopic(modelID), 0, true, op.State)
+	app.publishLMStudioModelAttributes(client, app.lmstudioModel(modelID))
+}
+
+// lmstudioModel returns the model from the last model list, or just its ID if it isn't listed
+func (app *Application) lmstudioModel(modelID string) llm.Model {
+	if model, ok := findLMStudioModel(state.Value(app.store, lmstudioModelsKey), modelID); ok {
+		return model
+	}
+	return llm.Model{ID: modelID}
+}
+
+func findLMStudioModel(models []llm.Model, modelID string) (llm.Model, bool) {
+	for _, model := range models {
+		if model.ID == modelID {
+			return model, true
+		}
+	}
+	return llm.Model{}, false
+}
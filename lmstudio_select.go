@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"bessarabov/mac2mqtt/llm"
+	"bessarabov/mac2mqtt/state"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// lmstudioSelectNone is the model select option that unloads all models
+const lmstudioSelectNone = "None"
+
+// lmstudioSelectable reports whether a model can be chosen in the model select;
+// embedding models are used next to a chat model, so they are left alone
+func lmstudioSelectable(model llm.Model) bool {
+	return model.Type != "embeddings"
+}
+
+// lmstudioSelectOptions returns the options of the model select: None, then the selectable models
+func lmstudioSelectOptions(models []llm.Model) []string {
+	options := []string{lmstudioSelectNone}
+	for _, model := range models {
+		if lmstudioSelectable(model) {
+			options = append(options, model.ID)
+		}
+	}
+	return options
+}
+
+// lmstudioSelectState returns the selected option: the first loaded selectable model, or None
+func lmstudioSelectState(models []llm.Model) string {
+	for _, model := range models {
+		if lmstudioSelectable(model) && model.State == llm.StateLoaded {
+			return model.ID
+		}
+	}
+	return lmstudioSelectNone
+}
+
+// handleLMStudioModelSelect loads the selected model and unloads the others ("exclusive mode")
+func (app *Application) handleLMStudioModelSelect(client mqtt.Client, payload string) {
+	target := strings.TrimSpace(payload)
+	models := state.Value(app.store, lmstudioModelsKey)
+
+	targetModel, found := findLMStudioModel(models, target)
+	if target == lmstudioSelectNone {
+		target = ""
+	} else if !found || !lmstudioSelectable(targetModel) {
+		lmstudioLog.Warn("Selected model is not available", "model", target)
+		return
+	}
+
+	// Free the memory first, then load the selected model
+	var unloading []string
+	for _, model := range models {
+		if lmstudioSelectable(model) && model.State == llm.StateLoaded && model.ID != target &&
+			app.startLMStudioModelOp(client, model.ID, modelStateUnloading) {
+			unloading = append(unloading, model.ID)
+		}
+	}
+	loading := target != "" && targetModel.State != llm.StateLoaded &&
+		app.startLMStudioModelOp(client, target, modelStateLoading)
+
+	selected := target
+	if selected == "" {
+		selected = lmstudioSelectNone
+	}
+	lmstudioLog.Info("Model selected", "model", selected, "unloading", len(unloading), "loading", loading)
+	client.Publish(app.getTopicPrefix()+"/status/lmstudio_model_select", 0, true, selected)
+
+	go func() {
+		for _, modelID := range unloading {
+			app.finishLMStudioModelOp(client, modelID, modelStateUnloading, app.llmServer.UnloadModel(modelID))
+		}
+		if loading {
+			load := app.lmstudioLoadFunc(client, target, llm.LoadOptions{})
+			app.finishLMStudioModelOp(client, target, modelStateLoading, load())
+		}
+		app.updateLMStudioStatus(client)
+	}()
+}
+
+// publishLMStudioModelSelect publishes the selected model, unless a selection is still being applied
+func (app *Application) publishLMStudioModelSelect(client mqtt.Client, models []llm.Model) {
+	for _, model := range models {
+		if op, ok := app.lmstudioOps.get(model.ID); ok && op.running() {
+			return
+		}
+	}
+	client.Publish(app.getTopicPrefix()+"/status/lmstudio_model_select", 0, true, lmstudioSelectState(models))
+}
+
+// publishLMStudioModelSelectDiscovery publishes the model select with the current models as
+// options, or removes it when it is disabled
+func (app *Application) publishLMStudioModelSelectDiscovery(client mqtt.Client, device, origin map[string]interface{}) {
+	basePrefix := app.getTopicPrefix()
+	topic := app.config.DiscoveryPrefix + "/select/" + app.hostname + "/lmstudio_model_select/config"
+	if !app.config.LMStudioModelSelect {
+		client.Publish(topic, 0, true, "")
+		return
+	}
+
+	selectConfig := map[string]interface{}{
+		"name":               app.llmServer.Name() + " Model",
+		"unique_id":          app.hostname + "_lmstudio_model_select",
+		"object_id":          "lmstudio_model_select",
+		"command_topic":      basePrefix + "/command/lmstudio_model_select",
+		"state_topic":        basePrefix + "/status/lmstudio_model_select",
+		"options":            lmstudioSelectOptions(state.Value(app.store, lmstudioModelsKey)),
+		"icon":               "mdi:brain",
+		"device":             device,
+		"origin":             origin,
+		"availability_topic": basePrefix + "/status/alive",
+	}
+	selectJSON, _ := json.Marshal(selectConfig)
+	client.Publish(topic, 0, true, selectJSON)
+}
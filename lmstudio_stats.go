@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"bessarabov/mac2mqtt/llm"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// lmstudioStats aggregates the chat requests that passed through mac2mqtt since it started
+type lmstudioStats struct {
+	mu                   sync.Mutex
+	requests             int     // answered requests
+	tokensPerSecondSum   float64 // over requests that reported a rate
+	tokensPerSecondCount int
+	firstTokenMSSum      int64 // over streaming requests
+	firstTokenMSCount    int
+}
+
+// record adds an answered chat request; failed and cancelled requests are not counted
+func (s *lmstudioStats) record(result lmstudioChatResult) {
+	if result.Error != "" || result.FinishReason == "cancelled" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if result.TokensPerSecond > 0 {
+		s.tokensPerSecondSum += result.TokensPerSecond
+		s.tokensPerSecondCount++
+	}
+	if result.TimeToFirstTokenMS > 0 {
+		s.firstTokenMSSum += result.TimeToFirstTokenMS
+		s.firstTokenMSCount++
+	}
+}
+
+// averages returns the request count and the average rate and time to first token (0 without data)
+func (s *lmstudioStats) averages() (requests int, tokensPerSecond float64, firstTokenMS int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokensPerSecondCount > 0 {
+		tokensPerSecond = math.Round(s.tokensPerSecondSum/float64(s.tokensPerSecondCount)*100) / 100
+	}
+	if s.firstTokenMSCount > 0 {
+		firstTokenMS = s.firstTokenMSSum / int64(s.firstTokenMSCount)
+	}
+	return s.requests, tokensPerSecond, firstTokenMS
+}
+
+// lmstudioModelMemory returns the memory a model uses when loaded: what the
+// server reports, otherwise its size on disk as an estimate (the KV cache comes on top)
+func lmstudioModelMemory(model llm.Model) int64 {
+	if model.MemoryBytes > 0 {
+		return model.MemoryBytes
+	}
+	return model.SizeBytes
+}
+
+// publishLMStudioModelsMemory publishes the memory used by the loaded models
+func (app *Application) publishLMStudioModelsMemory(client mqtt.Client, models []llm.Model) {
+	var total int64
+	for _, model := range models {
+		if model.State == llm.StateLoaded {
+			total += lmstudioModelMemory(model)
+		}
+	}
+	client.Publish(app.getTopicPrefix()+"/status/lmstudio_stats/models_memory", 0, false, fmt.Sprintf("%d", total))
+}
+
+// publishLMStudioStats publishes the chat request statistics
+func (app *Application) publishLMStudioStats(client mqtt.Client) {
+	requests, tokensPerSecond, firstTokenMS := app.lmstudioStats.averages()
+	basePrefix := app.getTopicPrefix() + "/status/lmstudio_stats"
+	client.Publish(basePrefix+"/requests", 0, false, fmt.Sprintf("%d", requests))
+	client.Publish(basePrefix+"/tokens_per_second", 0, false, fmt.Sprintf("%.2f", tokensPerSecond))
+	client.Publish(basePrefix+"/time_to_first_token", 0, false, fmt.Sprintf("%d", firstTokenMS))
+}
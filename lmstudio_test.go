@@ -0,0 +1,749 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"bessarabov/mac2mqtt/llm"
+	"bessarabov/mac2mqtt/mqtttest"
+)
+
+func TestParseLMStudioLoadRequest(t *testing.T) {
+	cases := []struct {
+		payload string
+		want    lmstudioLoadRequest
+		wantErr bool
+	}{
+		{payload: "qwen/qwen3-8b", want: lmstudioLoadRequest{Model: "qwen/qwen3-8b"}},
+		{payload: "  all\n", want: lmstudioLoadRequest{Model: "all"}},
+		{payload: `{"model": "qwen/qwen3-8b", "gpu": 0.8, "context_length": 8192, "ttl": 600}`,
+			want: lmstudioLoadRequest{Model: "qwen/qwen3-8b", GPU: 0.8, ContextLength: 8192, TTL: 600}},
+		{payload: "", wantErr: true},
+		{payload: "--help", wantErr: true},
+		{payload: `{"model": ""}`, wantErr: true},
+		{payload: `{"model": "x", "gpu": 2}`, wantErr: true},
+		{payload: `{"model": "x", "ttl": -1}`, wantErr: true},
+		{payload: `{"model": `, wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := parseLMStudioLoadRequest(c.payload)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseLMStudioLoadRequest(%q) error = %v, wantErr %v", c.payload, err, c.wantErr)
+			continue
+		}
+		if !c.wantErr && got != c.want {
+			t.Errorf("parseLMStudioLoadRequest(%q) = %+v, want %+v", c.payload, got, c.want)
+		}
+	}
+}
+
+func TestLMStudioLoadUnloadText(t *testing.T) {
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend)
+
+	steps := []struct {
+		topic, payload, call string
+	}{
+		{"lmstudio_load_model", "google/gemma-3-12b", "LoadModel(google/gemma-3-12b,0,0,0)"},
+		{"lmstudio_load_model", `{"model": "qwen/qwen3-8b", "gpu": 0.5, "context_length": 4096, "ttl": 600}`,
+			"LoadModel(qwen/qwen3-8b,0.5,4096,600)"},
+		{"lmstudio_unload_model", "qwen/qwen3-8b", "UnloadModel(qwen/qwen3-8b)"},
+		{"lmstudio_unload_model", "all", "UnloadAllModels()"},
+	}
+	for _, s := range steps {
+		broker.Publish(t, testPrefix+"/command/"+s.topic, s.payload, false)
+		if !backend.waitCalled(s.call, mqtttest.DefaultTimeout) {
+			t.Errorf("%s %q: expected %s", s.topic, s.payload, s.call)
+		}
+	}
+}
+
+func TestLMStudioModelLists(t *testing.T) {
+	backend := newFakeBackend()
+	backend.models[0].Arch = "qwen3"
+	backend.models[0].Quantization = "Q4_K_M"
+	backend.models[0].MaxContextLength = 32768
+	backend.models[0].Publisher = "qwen"
+	backend.models[0].SizeBytes = 5_000_000_000
+	backend.models[0].LoadedContextLength = 8192
+	backend.models[1].SizeBytes = 8_000_000_000
+	app, broker := startTestApp(t, backend)
+	app.updateLMStudioStatus(app.getClient())
+
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_stats/models_memory", "5000000000")
+	var modelAttrs lmstudioModelAttributes
+	if err := json.Unmarshal([]byte(broker.WaitFor(t, testPrefix+"/status/lmstudio_model_qwen_qwen3_8b/attributes", nil)), &modelAttrs); err != nil {
+		t.Fatal(err)
+	}
+	if modelAttrs.SizeBytes != 5_000_000_000 || modelAttrs.MemoryBytes != 5_000_000_000 || modelAttrs.Quantization != "Q4_K_M" || modelAttrs.ContextLength != 8192 {
+		t.Errorf("unexpected model attributes: %+v", modelAttrs)
+	}
+
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_loaded_models_list", "qwen/qwen3-8b (llm, loaded)")
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_available_models_list", "google/gemma-3-12b (llm, not-loaded)")
+
+	var attrs lmstudioModelListAttributes
+	if err := json.Unmarshal([]byte(broker.WaitFor(t, testPrefix+"/status/lmstudio_loaded_models", nil)), &attrs); err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Count != 1 || len(attrs.Models) != 1 {
+		t.Fatalf("unexpected loaded models attributes: %+v", attrs)
+	}
+	if m := attrs.Models[0]; m.Arch != "qwen3" || m.Quantization != "Q4_K_M" || m.MaxContextLength != 32768 || m.Publisher != "qwen" || m.Type != "llm" {
+		t.Errorf("model details missing from attributes: %+v", m)
+	}
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_server", "stop", false)
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_server", "offline")
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_loaded_models_list", "")
+	// The attributes topics must stay JSON for Home Assistant
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_available_models", `{"count":0,"models":[]}`)
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_loaded_models", `{"count":0,"models":[]}`)
+	if topics := broker.RetainedTopics(testPrefix + "/status/lmstudio_loaded_models_list"); len(topics) != 0 {
+		t.Errorf("model list still retained after the server went offline: %v", topics)
+	}
+}
+
+func TestTruncateState(t *testing.T) {
+	if got := truncateState("short"); got != "short" {
+		t.Errorf("truncateState(short) = %q", got)
+	}
+	long := strings.Repeat("é", 200)
+	got := truncateState(long)
+	if len(got) > maxSensorState || !utf8.ValidString(got) || !strings.HasSuffix(got, "…") {
+		t.Errorf("truncateState produced %d bytes, valid=%v", len(got), utf8.ValidString(got))
+	}
+}
+
+// fakeChatAPI stands in for LM Studio's /api/v0/chat/completions and records the requests it gets
+func fakeChatAPI(t *testing.T) (*httptest.Server, <-chan llm.ChatRequest) {
+	t.Helper()
+	requests := make(chan llm.ChatRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/chat/completions" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		var req llm.ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- req
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"model": %q, "choices": [{"index": 0, "message": {"role": "assistant", "content": "Three emails, nothing urgent."}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 42, "completion_tokens": 7, "total_tokens": 49}}`, req.Model)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestLMStudioChat(t *testing.T) {
+	api, requests := fakeChatAPI(t)
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend, func(c *config) { c.LMStudioAPIURL = api.URL })
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_chat", `{"id": "summary-1", "system": "Be brief.",
+		"messages": [{"role": "user", "content": "Summarize my inbox"}], "temperature": 0.2}`, false)
+
+	var result lmstudioChatResult
+	if err := json.Unmarshal([]byte(broker.WaitFor(t, testPrefix+"/status/lmstudio_chat/summary-1", nil)), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Error != "" || result.Content != "Three emails, nothing urgent." || result.FinishReason != "stop" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.Model != "qwen/qwen3-8b" {
+		t.Errorf("expected the loaded model to be picked, got %q", result.Model)
+	}
+	if result.Usage == nil || result.Usage.PromptTokens != 42 || result.Usage.CompletionTokens != 7 || result.Usage.TotalTokens != 49 {
+		t.Errorf("unexpected usage: %+v", result.Usage)
+	}
+	if result.LatencyMS < 0 {
+		t.Errorf("negative latency %d", result.LatencyMS)
+	}
+
+	req := <-requests
+	if req.Model != "qwen/qwen3-8b" || req.Stream || req.Temperature == nil || *req.Temperature != 0.2 {
+		t.Errorf("unexpected request sent to LM Studio: %+v", req)
+	}
+	if len(req.Messages) != 2 || req.Messages[0].Role != "system" || req.Messages[0].Content != "Be brief." || req.Messages[1].Role != "user" {
+		t.Errorf("system prompt should come before the messages: %+v", req.Messages)
+	}
+}
+
+func TestLMStudioChatRejected(t *testing.T) {
+	api, requests := fakeChatAPI(t)
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend, func(c *config) { c.LMStudioAPIURL = api.URL })
+
+	cases := []struct {
+		id, payload, wantErr string
+	}{
+		{"not-loaded", `{"id": "not-loaded", "model": "google/gemma-3-12b", "messages": [{"role": "user", "content": "hi"}]}`, "not loaded"},
+		{"bad-role", `{"id": "bad-role", "messages": [{"role": "robot", "content": "hi"}]}`, "invalid message role"},
+		{"empty", `{"id": "empty", "messages": []}`, "messages must not be empty"},
+	}
+	for _, c := range cases {
+		broker.Publish(t, testPrefix+"/command/lmstudio_chat", c.payload, false)
+		payload := broker.WaitFor(t, testPrefix+"/status/lmstudio_chat/"+c.id, nil)
+		if !strings.Contains(payload, c.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %s", c.id, c.wantErr, payload)
+		}
+	}
+
+	// Nothing loaded at all
+	broker.Publish(t, testPrefix+"/command/lmstudio_unload_model", "all", false)
+	backend.waitCalled("UnloadAllModels()", mqtttest.DefaultTimeout)
+	broker.Publish(t, testPrefix+"/command/lmstudio_chat", `{"id": "idle", "messages": [{"role": "user", "content": "hi"}]}`, false)
+	if payload := broker.WaitFor(t, testPrefix+"/status/lmstudio_chat/idle", nil); !strings.Contains(payload, "no model is loaded") {
+		t.Errorf("expected rejection without a loaded model, got %s", payload)
+	}
+
+	select {
+	case req := <-requests:
+		t.Errorf("rejected requests must not reach LM Studio, got %+v", req)
+	default:
+	}
+}
+
+func TestParseLMStudioChatRequestID(t *testing.T) {
+	for _, id := range []string{"", "a/b", "x+", "#", strings.Repeat("x", 65)} {
+		payload, _ := json.Marshal(map[string]interface{}{"id": id, "messages": []map[string]string{{"role": "user", "content": "hi"}}})
+		req, err := parseLMStudioChatRequest(string(payload))
+		if err == nil || req.ID != "" {
+			t.Errorf("id %q should be rejected without being used as a topic, got %+v %v", id, req, err)
+		}
+	}
+}
+
+// fakeStreamingChatAPI is an SSE stand-in for LM Studio's streaming chat completions.
+// It sends chunks, then holds the stream open until release is closed or the client goes away.
+func fakeStreamingChatAPI(t *testing.T, chunks []string, release <-chan struct{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req llm.ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Stream {
+			http.Error(w, "expected a streaming request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for _, chunk := range chunks {
+			data, _ := json.Marshal(map[string]interface{}{
+				"model":   req.Model,
+				"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{"content": chunk}, "finish_reason": nil}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, `data: {"choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}],`+"\n"+
+			`data: "usage": {"prompt_tokens": 12, "completion_tokens": 3, "total_tokens": 15}}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLMStudioChatStream(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	api := fakeStreamingChatAPI(t, []string{"Lights", " are", " off."}, release)
+	_, broker := startTestApp(t, newFakeBackend(), func(c *config) { c.LMStudioAPIURL = api.URL })
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_chat", `{"id": "s1", "stream": true, "messages": [{"role": "user", "content": "Status?"}]}`, false)
+
+	var result lmstudioChatResult
+	if err := json.Unmarshal([]byte(broker.WaitFor(t, testPrefix+"/status/lmstudio_chat/s1", nil)), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "Lights are off." || result.FinishReason != "stop" || result.Error != "" {
+		t.Errorf("unexpected final message: %+v", result)
+	}
+	if result.Usage == nil || result.Usage.CompletionTokens != 3 || result.TokensPerSecond <= 0 {
+		t.Errorf("expected usage and tokens/sec, got %+v", result)
+	}
+
+	deltas := broker.Topic(testPrefix + "/status/lmstudio_chat/s1/delta")
+	if strings.Join(deltas, "|") != "Lights| are| off." {
+		t.Errorf("unexpected deltas %q", deltas)
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_stats/requests", "1")
+	broker.WaitFor(t, testPrefix+"/status/lmstudio_stats/tokens_per_second", func(tps string) bool { return tps != "0.00" })
+}
+
+func TestLMStudioStats(t *testing.T) {
+	var stats lmstudioStats
+	stats.record(lmstudioChatResult{TokensPerSecond: 20, TimeToFirstTokenMS: 300})
+	stats.record(lmstudioChatResult{TokensPerSecond: 30})
+	stats.record(lmstudioChatResult{Error: "no model is loaded"})
+	stats.record(lmstudioChatResult{FinishReason: "cancelled", TimeToFirstTokenMS: 100})
+
+	requests, tokensPerSecond, firstTokenMS := stats.averages()
+	if requests != 2 || tokensPerSecond != 25 || firstTokenMS != 300 {
+		t.Errorf("averages() = %d, %v, %d", requests, tokensPerSecond, firstTokenMS)
+	}
+}
+
+func TestLMStudioChatCancel(t *testing.T) {
+	api := fakeStreamingChatAPI(t, []string{"Once upon"}, make(chan struct{}))
+	_, broker := startTestApp(t, newFakeBackend(), func(c *config) { c.LMStudioAPIURL = api.URL })
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_chat", `{"id": "story", "stream": true, "messages": [{"role": "user", "content": "Tell me a story"}]}`, false)
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_chat/story/delta", "Once upon")
+
+	// The ID is taken while the request runs
+	broker.Publish(t, testPrefix+"/command/lmstudio_chat", `{"id": "story", "messages": [{"role": "user", "content": "again"}]}`, false)
+	broker.WaitFor(t, testPrefix+"/status/lmstudio_chat/story", func(p string) bool { return strings.Contains(p, "already running") })
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_chat_cancel", "story", false)
+	payload := broker.WaitFor(t, testPrefix+"/status/lmstudio_chat/story", func(p string) bool { return strings.Contains(p, "finish_reason") })
+
+	var result lmstudioChatResult
+	if err := json.Unmarshal([]byte(payload), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.FinishReason != "cancelled" || result.Content != "Once upon" || result.Error != "" {
+		t.Errorf("unexpected result after cancel: %+v", result)
+	}
+}
+
+func TestLMStudioIdleUnload(t *testing.T) {
+	backend := newFakeBackend()
+	backend.models = append(backend.models, llm.Model{ID: "meta/llama-3.2-3b", Type: "llm", State: llm.StateLoaded})
+	app, broker := startTestApp(t, backend, func(c *config) {
+		c.LMStudioIdleTTL = 60
+		c.LMStudioModelTTLs = map[string]int{"google/gemma-3-12b": 600}
+		c.LMStudioPinnedModels = []string{"meta/llama-3.2-3b"}
+	})
+	app.updateLMStudioStatus(app.getClient())
+
+	// gemma is loaded now, qwen and llama have been idle for two minutes
+	broker.Publish(t, testPrefix+"/command/lmstudio_load_model", "google/gemma-3-12b", false)
+	if !backend.waitCalled("LoadModel(google/gemma-3-12b,0,0,0)", mqtttest.DefaultTimeout) {
+		t.Fatal("expected model load")
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_google_gemma_3_12b", "ON")
+	idleSince := time.Now().Add(-2 * time.Minute)
+	app.lmstudioIdle.touch("qwen/qwen3-8b", idleSince)
+	app.lmstudioIdle.touch("meta/llama-3.2-3b", idleSince)
+	broker.Reset()
+	app.updateLMStudioStatus(app.getClient())
+
+	// The idle model is unloaded like with the unload command, showing "unloading" meanwhile
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_qwen_qwen3_8b", "unloading")
+	if !backend.waitCalled("UnloadModel(qwen/qwen3-8b)", mqtttest.DefaultTimeout) {
+		t.Error("expected the idle model to be unloaded")
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_qwen_qwen3_8b", "OFF")
+	if backend.called("UnloadModel(meta/llama-3.2-3b)") || backend.called("UnloadModel(google/gemma-3-12b)") {
+		t.Error("pinned or recently used model was unloaded")
+	}
+
+	// Check the attributes once the unload is done
+	broker.Reset()
+	app.updateLMStudioStatus(app.getClient())
+
+	attributes := func(model string) lmstudioModelAttributes {
+		var attrs lmstudioModelAttributes
+		payload := broker.WaitFor(t, testPrefix+"/status/lmstudio_model_"+model+"/attributes", nil)
+		if err := json.Unmarshal([]byte(payload), &attrs); err != nil {
+			t.Fatal(err)
+		}
+		return attrs
+	}
+	if a := attributes("google_gemma_3_12b"); a.IdleTTL != 600 || a.UnloadIn == nil || *a.UnloadIn < 590 || a.LastUsed == "" {
+		t.Errorf("gemma attributes = %+v", a)
+	}
+	if a := attributes("meta_llama_3_2_3b"); !a.Pinned || a.IdleTTL != 0 || a.UnloadIn != nil {
+		t.Errorf("pinned model attributes = %+v", a)
+	}
+	if a := attributes("qwen_qwen3_8b"); a.IdleTTL != 60 || a.UnloadIn != nil || a.LastUsed != "" {
+		t.Errorf("unloaded model attributes = %+v", a)
+	}
+}
+
+func TestLMStudioIdleTrackerExpiry(t *testing.T) {
+	tracker := newLMStudioIdleTracker()
+	start := time.Now()
+	expires := start.Add(5 * time.Minute)
+	models := []llm.Model{{ID: "llama3.2:3b", State: llm.StateLoaded, ExpiresAt: &expires}}
+
+	tracker.observe(models, start)
+	tracker.observe(models, start.Add(time.Minute))
+	if lastUsed, _ := tracker.lastUse("llama3.2:3b"); !lastUsed.Equal(start) {
+		t.Errorf("unchanged expiry counted as use: %v", lastUsed)
+	}
+
+	// The server pushed the expiry back, so the model was used
+	later := expires.Add(2 * time.Minute)
+	models[0].ExpiresAt = &later
+	tracker.observe(models, start.Add(2*time.Minute))
+	if lastUsed, _ := tracker.lastUse("llama3.2:3b"); !lastUsed.Equal(start.Add(2 * time.Minute)) {
+		t.Errorf("expected the later expiry to count as use, got %v", lastUsed)
+	}
+
+	models[0].State = llm.StateNotLoaded
+	tracker.observe(models, start.Add(3*time.Minute))
+	if _, ok := tracker.lastUse("llama3.2:3b"); ok {
+		t.Error("unloaded model is still tracked")
+	}
+}
+
+func TestLMStudioDownload(t *testing.T) {
+	backend := newFakeBackend()
+	backend.downloads = make(chan struct{})
+	app, broker := startTestApp(t, backend)
+	app.updateLMStudioStatus(app.getClient())
+
+	downloadStatus := func(state string) lmstudioDownloadStatus {
+		var status lmstudioDownloadStatus
+		broker.WaitFor(t, testPrefix+"/status/lmstudio_download", func(payload string) bool {
+			return json.Unmarshal([]byte(payload), &status) == nil && status.State == state
+		})
+		return status
+	}
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_download", "mistralai/devstral-small", false)
+	if !backend.waitCalled("Download(mistralai/devstral-small)", mqtttest.DefaultTimeout) {
+		t.Fatal("expected a download")
+	}
+	broker.Publish(t, testPrefix+"/command/lmstudio_download", "mistralai/devstral-small", false)
+	broker.Publish(t, testPrefix+"/command/lmstudio_download", "google/gemma-3-27b", false)
+	time.Sleep(200 * time.Millisecond) // let the duplicate and the other model arrive while the first download runs
+	close(backend.downloads)
+
+	if s := downloadStatus(downloadStateCompleted); s.Model != "mistralai/devstral-small" || s.Progress != 100 {
+		t.Errorf("unexpected final status %+v", s)
+	}
+	broker.WaitFor(t, "homeassistant/switch/testmac/lmstudio_model_mistralai_devstral_small/config", nil)
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_mistralai_devstral_small", "OFF")
+
+	calls := 0
+	backend.mu.Lock()
+	for _, call := range backend.calls {
+		if call == "Download(mistralai/devstral-small)" {
+			calls++
+		}
+	}
+	backend.mu.Unlock()
+	if calls != 1 {
+		t.Errorf("duplicate download started %d jobs", calls)
+	}
+	if backend.called("Download(google/gemma-3-27b)") {
+		t.Error("a second download started while the first one ran")
+	}
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_download", "broken/model", false)
+	if s := downloadStatus(downloadStateFailed); s.Error != "model not found" {
+		t.Errorf("unexpected failed status %+v", s)
+	}
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_download", "--help", false)
+	if backend.waitCalled("Download(--help)", 200*time.Millisecond) {
+		t.Error("flag-like model ID reached the backend")
+	}
+}
+
+func TestLMStudioDownloadCancel(t *testing.T) {
+	backend := newFakeBackend()
+	backend.downloads = make(chan struct{})
+	_, broker := startTestApp(t, backend)
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_download", "google/gemma-3-27b", false)
+	broker.WaitFor(t, testPrefix+"/status/lmstudio_download", func(payload string) bool {
+		return strings.Contains(payload, `"progress":50`)
+	})
+	broker.Publish(t, testPrefix+"/command/lmstudio_download_cancel", "all", false)
+	broker.WaitFor(t, testPrefix+"/status/lmstudio_download", func(payload string) bool {
+		return strings.Contains(payload, `"state":"cancelled"`)
+	})
+}
+
+func TestLMStudioModelTransitionalState(t *testing.T) {
+	backend := newFakeBackend()
+	backend.loads = make(chan struct{})
+	app, broker := startTestApp(t, backend)
+	app.updateLMStudioStatus(app.getClient())
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_google_gemma_3_12b", "OFF")
+
+	modelAttributes := func(model string, match func(lmstudioModelAttributes) bool) lmstudioModelAttributes {
+		var attrs lmstudioModelAttributes
+		broker.WaitFor(t, testPrefix+"/status/lmstudio_model_"+model+"/attributes", func(payload string) bool {
+			attrs = lmstudioModelAttributes{}
+			return json.Unmarshal([]byte(payload), &attrs) == nil && match(attrs)
+		})
+		return attrs
+	}
+
+	broker.Publish(t, testPrefix+"/command/lmstudio_model_google_gemma_3_12b", "load", false)
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_google_gemma_3_12b", "loading")
+	modelAttributes("google_gemma_3_12b", func(a lmstudioModelAttributes) bool {
+		return a.Operation == "loading" && a.LoadProgress != nil && *a.LoadProgress == 40
+	})
+
+	// The periodic status update must not flip the switch back while the load runs
+	broker.Reset()
+	app.updateLMStudioStatus(app.getClient())
+	broker.WaitFor(t, testPrefix+"/status/lmstudio_loaded_models_count", nil) // published after the model states
+	if state, _ := broker.Last(testPrefix + "/status/lmstudio_model_google_gemma_3_12b"); state != "loading" {
+		t.Errorf("status during load = %q, want loading", state)
+	}
+
+	close(backend.loads)
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_google_gemma_3_12b", "ON")
+	modelAttributes("google_gemma_3_12b", func(a lmstudioModelAttributes) bool { return a.Operation == "" && a.LoadProgress == nil })
+
+	// A failed load reports the error on the model
+	broker.Publish(t, testPrefix+"/command/lmstudio_load_model", "missing/model", false)
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_missing_model", "error")
+	if a := modelAttributes("missing_model", func(a lmstudioModelAttributes) bool { return a.Operation == "error" }); !strings.Contains(a.Error, "not found") {
+		t.Errorf("expected the load error in the attributes, got %+v", a)
+	}
+}
+
+func TestLMStudioModelLoadError(t *testing.T) {
+	backend := newFakeBackend()
+	backend.loadErr = fmt.Errorf("not enough memory")
+	app, broker := startTestApp(t, backend, func(c *config) { c.LMStudioIdleTTL = 60 })
+	app.updateLMStudioStatus(app.getClient())
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_google_gemma_3_12b", "OFF")
+
+	modelAttributes := func() lmstudioModelAttributes {
+		var attrs lmstudioModelAttributes
+		payload, _ := broker.Last(testPrefix + "/status/lmstudio_model_google_gemma_3_12b/attributes")
+		if err := json.Unmarshal([]byte(payload), &attrs); err != nil {
+			t.Fatal(err)
+		}
+		return attrs
+	}
+	update := func() string {
+		broker.Reset()
+		app.updateLMStudioStatus(app.getClient())
+		broker.WaitFor(t, testPrefix+"/status/lmstudio_loaded_models_count", nil) // published after the model states
+		state, _ := broker.Last(testPrefix + "/status/lmstudio_model_google_gemma_3_12b")
+		return state
+	}
+
+	// The status update that follows the failed load must keep the error on the status topic
+	broker.Publish(t, testPrefix+"/command/lmstudio_model_google_gemma_3_12b", "load", false)
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_google_gemma_3_12b", "error")
+	if state := update(); state != "error" {
+		t.Errorf("status after failed load = %q, want error", state)
+	}
+
+	// The server finishes the load after all: the switch follows it, the message stays
+	backend.setModelState("google/gemma-3-12b", llm.StateLoaded)
+	if state := update(); state != "ON" {
+		t.Errorf("status after the server loaded the model = %q, want ON", state)
+	}
+	if a := modelAttributes(); a.Operation != "" || a.Error != "not enough memory" {
+		t.Errorf("attributes after the server loaded the model = %+v", a)
+	}
+
+	// The idle unload isn't held back by the failed load, and its success clears the message
+	app.lmstudioIdle.touch("google/gemma-3-12b", time.Now().Add(-2*time.Minute))
+	update()
+	if !backend.waitCalled("UnloadModel(google/gemma-3-12b)", mqtttest.DefaultTimeout) {
+		t.Fatal("expected the idle model to be unloaded")
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_google_gemma_3_12b", "OFF")
+	if state := update(); state != "OFF" {
+		t.Errorf("status after the idle unload = %q, want OFF", state)
+	}
+	if a := modelAttributes(); a.Error != "" {
+		t.Errorf("error kept after a successful unload: %+v", a)
+	}
+}
+
+func TestLMStudioModelSelect(t *testing.T) {
+	backend := newFakeBackend()
+	backend.models = append(backend.models, llm.Model{ID: "nomic/embed-text", Type: "embeddings", State: llm.StateLoaded})
+	switches := false
+	app, broker := startTestApp(t, backend, func(c *config) {
+		c.LMStudioModelSelect = true
+		c.LMStudioModelSwitches = &switches
+	})
+	app.updateLMStudioStatus(app.getClient())
+
+	var discovery struct {
+		Options []string `json:"options"`
+	}
+	// The options follow the model list
+	broker.WaitFor(t, "homeassistant/select/"+app.hostname+"/lmstudio_model_select/config", func(payload string) bool {
+		return json.Unmarshal([]byte(payload), &discovery) == nil && len(discovery.Options) > 1
+	})
+	if want := "None,qwen/qwen3-8b,google/gemma-3-12b"; strings.Join(discovery.Options, ",") != want {
+		t.Errorf("select options = %v, want %s", discovery.Options, want)
+	}
+	if payload := broker.WaitFor(t, "homeassistant/switch/"+app.hostname+"/lmstudio_model_qwen_qwen3_8b/config", nil); payload != "" {
+		t.Errorf("expected the model switch to be removed, got %q", payload)
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_select", "qwen/qwen3-8b")
+
+	// Selecting a model unloads the other chat models first, embeddings stay loaded
+	broker.Publish(t, testPrefix+"/command/lmstudio_model_select", "google/gemma-3-12b", false)
+	if !backend.waitCalled("LoadModel(google/gemma-3-12b,0,0,0)", mqtttest.DefaultTimeout) {
+		t.Fatal("expected the selected model to be loaded")
+	}
+	if !backend.called("UnloadModel(qwen/qwen3-8b)") {
+		t.Error("expected the previous model to be unloaded")
+	}
+	if backend.called("UnloadModel(nomic/embed-text)") {
+		t.Error("embedding model must not be unloaded")
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_google_gemma_3_12b", "ON")
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_select", "google/gemma-3-12b")
+
+	// Unknown models are ignored, None unloads the selected model
+	broker.Publish(t, testPrefix+"/command/lmstudio_model_select", "missing/model", false)
+	broker.Publish(t, testPrefix+"/command/lmstudio_model_select", "None", false)
+	if !backend.waitCalled("UnloadModel(google/gemma-3-12b)", mqtttest.DefaultTimeout) {
+		t.Fatal("expected the selected model to be unloaded")
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_google_gemma_3_12b", "OFF")
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_select", "None")
+	if backend.called("LoadModel(missing/model,0,0,0)") {
+		t.Error("unknown model must not be loaded")
+	}
+}
+
+func TestLMStudioStaleModels(t *testing.T) {
+	stateDir := t.TempDir()
+	published := filepath.Join(stateDir, lmstudioTopicsFile)
+	if err := os.WriteFile(published, []byte(`{"old/model": "old_model", "qwen/qwen3-8b": "qwen_qwen3_8b"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	backend := newFakeBackend()
+	app, broker := startTestApp(t, backend, func(c *config) { c.StateDir = stateDir })
+	broker.Publish(t, testPrefix+"/status/lmstudio_model_old_model", "OFF", true)
+
+	// A model deleted while mac2mqtt wasn't running is removed at the first update
+	app.updateLMStudioStatus(app.getClient())
+	broker.WaitForPayload(t, "homeassistant/switch/testmac/lmstudio_model_old_model/config", "")
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_old_model", "")
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_old_model/attributes", "")
+
+	// A model deleted later is removed as well
+	backend.mu.Lock()
+	backend.models = backend.models[1:]
+	backend.mu.Unlock()
+	broker.Reset()
+	app.updateLMStudioStatus(app.getClient())
+	broker.WaitForPayload(t, "homeassistant/switch/testmac/lmstudio_model_qwen_qwen3_8b/config", "")
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_qwen_qwen3_8b", "")
+	// The remaining model keeps its switch
+	broker.WaitFor(t, "homeassistant/switch/testmac/lmstudio_model_google_gemma_3_12b/config", func(payload string) bool { return payload != "" })
+
+	data, err := os.ReadFile(published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var topics map[string]string
+	if err := json.Unmarshal(data, &topics); err != nil || len(topics) != 1 || topics["google/gemma-3-12b"] != "google_gemma_3_12b" {
+		t.Errorf("published models = %s, want only gemma", data)
+	}
+}
+
+func TestLMStudioModelTopics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), lmstudioTopicsFile)
+	topics, err := loadLMStudioModelTopics(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := topics.update([]llm.Model{{ID: "foo-bar/7b"}, {ID: "foo/bar-7b"}, {ID: "vendor/select"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The first ID in order keeps the plain name, "select" is taken by the model select
+	want := map[string]string{
+		"foo-bar/7b":    "foo_bar_7b",
+		"foo/bar-7b":    "foo_bar_7b_" + lmstudioTopicHash("foo/bar-7b"),
+		"vendor/select": "vendor_select",
+	}
+	for id, topic := range want {
+		if got := topics.topic(id); got != topic {
+			t.Errorf("topic(%q) = %q, want %q", id, got, topic)
+		}
+		if got, ok := topics.model(topic); !ok || got != id {
+			t.Errorf("model(%q) = %q, want %q", topic, got, id)
+		}
+	}
+	if got := topics.topic("select"); got != "select_"+lmstudioTopicHash("select") {
+		t.Errorf("a model must not take the select topic, got %q", got)
+	}
+
+	// Names are kept after a restart, even if the model that had the plain name is gone
+	topics, err = loadLMStudioModelTopics(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := topics.update([]llm.Model{{ID: "foo/bar-7b"}, {ID: "aaa/foo-bar-7b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 2 || stale["foo-bar/7b"] != "foo_bar_7b" {
+		t.Errorf("stale = %v, want foo-bar/7b and vendor/select", stale)
+	}
+	if got := topics.topic("foo/bar-7b"); got != want["foo/bar-7b"] {
+		t.Errorf("topic changed after restart: %q", got)
+	}
+	if got := topics.topic("aaa/foo-bar-7b"); got != "aaa_foo_bar_7b" {
+		t.Errorf("topic(aaa/foo-bar-7b) = %q", got)
+	}
+}
+
+func TestLMStudioModelTopicsHashedNameTaken(t *testing.T) {
+	topics, err := loadLMStudioModelTopics("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A model whose ID is the hashed name the colliding model would get
+	hashed := "foo_bar_7b_" + lmstudioTopicHash("foo/bar-7b")
+	if _, err := topics.update([]llm.Model{{ID: "foo-bar/7b"}, {ID: hashed}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := topics.update([]llm.Model{{ID: "foo-bar/7b"}, {ID: hashed}, {ID: "foo/bar-7b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := topics.topic(hashed); got != hashed {
+		t.Errorf("topic(%q) = %q, want it unchanged", hashed, got)
+	}
+	if got := topics.topic("foo/bar-7b"); got != hashed+"_2" {
+		t.Errorf("topic(foo/bar-7b) = %q, want %q", got, hashed+"_2")
+	}
+}
+
+func TestLMStudioCollidingModelSwitches(t *testing.T) {
+	backend := newFakeBackend()
+	backend.models = []llm.Model{
+		{ID: "foo-bar/7b", Type: "llm", State: llm.StateNotLoaded},
+		{ID: "foo/bar-7b", Type: "llm", State: llm.StateNotLoaded},
+	}
+	app, broker := startTestApp(t, backend)
+	app.updateLMStudioStatus(app.getClient())
+
+	hashed := "foo_bar_7b_" + lmstudioTopicHash("foo/bar-7b")
+	broker.WaitFor(t, "homeassistant/switch/testmac/lmstudio_model_"+hashed+"/config", nil)
+	broker.Publish(t, testPrefix+"/command/lmstudio_model_"+hashed, "load", false)
+	if !backend.waitCalled("LoadModel(foo/bar-7b,0,0,0)", mqtttest.DefaultTimeout) {
+		t.Fatal("expected the model of the hashed topic to be loaded")
+	}
+	broker.WaitForPayload(t, testPrefix+"/status/lmstudio_model_"+hashed, "ON")
+	if backend.called("LoadModel(foo-bar/7b,0,0,0)") {
+		t.Error("the colliding model must not be loaded")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"bessarabov/mac2mqtt/llm"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// lmstudioTopicsFile is the file in state_dir that keeps the model topic names between restarts
+const lmstudioTopicsFile = "lmstudio_models.json"
+
+// lmstudioReservedTopics are topic names of other entities that a model must not take
+var lmstudioReservedTopics = []string{"select"}
+
+// lmstudioModelTopics maps model IDs to the topic names of their switches
+// (lmstudio_model_<name>). A name never changes while the model is listed, so
+// entity IDs stay the same when other models are downloaded, even after a restart.
+type lmstudioModelTopics struct {
+	mu     sync.Mutex
+	path   string            // empty: kept in memory only
+	topics map[string]string // model ID -> topic name
+}
+
+// loadLMStudioModelTopics reads the topic names from path; a missing file is an empty mapping
+func loadLMStudioModelTopics(path string) (*lmstudioModelTopics, error) {
+	t := &lmstudioModelTopics{path: path, topics: make(map[string]string)}
+	if path == "" {
+		return t, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	var topics map[string]string
+	if err := json.Unmarshal(data, &topics); err != nil {
+		return t, err
+	}
+	maps.Copy(t.topics, topics)
+	return t, nil
+}
+
+// lmstudioTopicHash returns a short hash of the model ID that tells apart IDs with the same sanitized name
+func lmstudioTopicHash(modelID string) string {
+	sum := sha256.Sum256([]byte(modelID))
+	return hex.EncodeToString(sum[:3])
+}
+
+// unusedName returns the sanitized ID, with a hash suffix if another model already
+// uses it, and a counter after the hash if even that name is taken
+func (t *lmstudioModelTopics) unusedName(modelID string) string {
+	name := sanitizeModelID(modelID)
+	if name != "" && !t.taken(name, modelID) {
+		return name
+	}
+	base := lmstudioTopicHash(modelID)
+	if name != "" {
+		base = name + "_" + base
+	}
+	candidate := base
+	for i := 2; t.taken(candidate, modelID); i++ {
+		candidate = base + "_" + strconv.Itoa(i)
+	}
+	return candidate
+}
+
+// taken reports whether another model or entity uses the topic name
+func (t *lmstudioModelTopics) taken(name, modelID string) bool {
+	if slices.Contains(lmstudioReservedTopics, name) {
+		return true
+	}
+	for id, topic := range t.topics {
+		if topic == name && id != modelID {
+			return true
+		}
+	}
+	return false
+}
+
+// update gives the new models a topic name and forgets the models that are gone. It
+// returns the removed models with their topic names. Models are named in ID order,
+// so colliding IDs that appear together are told apart the same way every time.
+func (t *lmstudioModelTopics) update(models []llm.Model) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	listed := make(map[string]bool, len(models))
+	for _, model := range models {
+		listed[model.ID] = true
+	}
+	stale := make(map[string]string)
+	for id, topic := range t.topics {
+		if !listed[id] {
+			stale[id] = topic
+			delete(t.topics, id)
+		}
+	}
+
+	added := false
+	for _, id := range slices.Sorted(maps.Keys(listed)) {
+		if _, ok := t.topics[id]; ok {
+			continue
+		}
+		t.topics[id] = t.unusedName(id)
+		if t.topics[id] != sanitizeModelID(id) {
+			lmstudioLog.Info("Model ID collides with another model, adding a hash to its topic", "model", id, "topic", "lmstudio_model_"+t.topics[id])
+		}
+		added = true
+	}
+
+	if !added && len(stale) == 0 {
+		return stale, nil
+	}
+	return stale, t.save()
+}
+
+// topic returns the topic name of a model. Models that were never listed, like
+// one that failed to load, get the name they would get if listed now.
+func (t *lmstudioModelTopics) topic(modelID string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if topic, ok := t.topics[modelID]; ok {
+		return topic
+	}
+	return t.unusedName(modelID)
+}
+
+// model returns the model ID of a topic name
+func (t *lmstudioModelTopics) model(topic string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, name := range t.topics {
+		if name == topic {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (t *lmstudioModelTopics) save() error {
+	if t.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(t.topics, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// lmstudioModelTopic returns the topic name of a model, used in lmstudio_model_<name>
+func (app *Application) lmstudioModelTopic(modelID string) string {
+	return app.lmstudioTopics.topic(modelID)
+}
+
+// updateLMStudioModelTopics names the new models, then removes the switch and the
+// retained state of the models that are no longer listed by the server
+func (app *Application) updateLMStudioModelTopics(client mqtt.Client, models []llm.Model) {
+	stale, err := app.lmstudioTopics.update(models)
+	if err != nil {
+		lmstudioLog.Error("Failed to save the model topics", "file", app.lmstudioTopics.path, "err", err)
+	}
+
+	basePrefix := app.getTopicPrefix()
+	for modelID, topic := range stale {
+		lmstudioLog.Info("Removing model that is no longer available", "model", modelID)
+		client.Publish(app.config.DiscoveryPrefix+"/switch/"+app.hostname+"/lmstudio_model_"+topic+"/config", 0, true, "")
+		client.Publish(basePrefix+"/status/lmstudio_model_"+topic, 0, true, "")
+		client.Publish(basePrefix+"/status/lmstudio_model_"+topic+"/attributes", 0, true, "")
+	}
+}
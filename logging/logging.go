@@ -0,0 +1,171 @@
+// Package logging provides the levelled, per-subsystem loggers used by mac2mqtt
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Options controls how log output is formatted and where it is written
+type Options struct {
+	Level      string            // "debug", "info", "warn" or "error" (default: info)
+	Format     string            // "text" or "json" (default: text)
+	File       string            // Log file path, only used when not running under launchd
+	MaxSizeMB  int               // Rotate the log file once it exceeds this size (default: 10)
+	MaxBackups int               // Number of rotated files to keep (default: 3)
+	Levels     map[string]string // Per-subsystem level overrides, e.g. {"mqtt": "debug"}
+}
+
+// root holds the currently configured output state shared by all subsystem loggers
+type root struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+var current atomic.Pointer[root]
+
+func init() {
+	current.Store(&root{
+		handler: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		level:   slog.LevelInfo,
+	})
+}
+
+// Subsystem loggers. They stay valid across Setup calls, so packages can keep
+// them in package-level variables.
+var (
+	MQTT     = New("mqtt")
+	Media    = New("media")
+	LMStudio = New("lmstudio")
+	Display  = New("display")
+	Activity = New("activity")
+	Audio    = New("audio")
+	System   = New("system")
+)
+
+// New returns a logger tagged with the given subsystem name
+func New(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{subsystem: subsystem})
+}
+
+// Setup configures the level, format and destination of all loggers.
+// The standard library logger is redirected as well, so libraries that
+// still use log.Printf end up in the same output.
+func Setup(opts Options) error {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+
+	levels := make(map[string]slog.Level, len(opts.Levels))
+	for name, l := range opts.Levels {
+		parsed, err := ParseLevel(l)
+		if err != nil {
+			return fmt.Errorf("log level for %s: %w", name, err)
+		}
+		levels[name] = parsed
+	}
+
+	var out io.Writer = os.Stderr
+	if opts.File != "" && !UnderLaunchd() {
+		out, err = NewRotatingFile(opts.File, opts.MaxSizeMB, opts.MaxBackups)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The handler itself lets everything through; filtering happens in
+	// subsystemHandler.Enabled so per-subsystem overrides can be lower
+	// than the global level.
+	handlerOpts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(out, handlerOpts)
+	default:
+		return fmt.Errorf("unknown log format %q (expected text or json)", opts.Format)
+	}
+
+	current.Store(&root{handler: handler, level: level, levels: levels})
+
+	slog.SetDefault(New("main"))
+
+	return nil
+}
+
+// NewLogLogger returns a *log.Logger that writes through the given subsystem at a fixed level.
+// It is used to hook third-party loggers such as the paho MQTT client into slog.
+func NewLogLogger(subsystem string, level slog.Level) *log.Logger {
+	return slog.NewLogLogger(&subsystemHandler{subsystem: subsystem}, level)
+}
+
+// ParseLevel converts a config level name into a slog.Level
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", s)
+}
+
+// UnderLaunchd reports whether the process was started by launchd.
+// launchd sets XPC_SERVICE_NAME to the job label; interactive shells have it unset or "0".
+func UnderLaunchd() bool {
+	name := os.Getenv("XPC_SERVICE_NAME")
+	return name != "" && name != "0"
+}
+
+// Fatal logs an error and exits, mirroring log.Fatal
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// subsystemHandler resolves the configured handler on every call, so loggers
+// created before Setup pick up the final configuration.
+type subsystemHandler struct {
+	subsystem string
+	attrs     []slog.Attr
+}
+
+func (h *subsystemHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	r := current.Load()
+	min := r.level
+	if l, ok := r.levels[h.subsystem]; ok {
+		min = l
+	}
+	return level >= min && r.handler.Enabled(ctx, level)
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, record slog.Record) error {
+	attrs := append([]slog.Attr{slog.String("subsystem", h.subsystem)}, h.attrs...)
+	return current.Load().handler.WithAttrs(attrs).Handle(ctx, record)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	merged := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	merged = append(merged, h.attrs...)
+	merged = append(merged, attrs...)
+	return &subsystemHandler{subsystem: h.subsystem, attrs: merged}
+}
+
+// WithGroup binds to the handler configured at the time of the call
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	attrs := append([]slog.Attr{slog.String("subsystem", h.subsystem)}, h.attrs...)
+	return current.Load().handler.WithAttrs(attrs).WithGroup(name)
+}
@@ -2,31 +2,43 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"bessarabov/mac2mqtt/broker"
+	"bessarabov/mac2mqtt/llm"
+	"bessarabov/mac2mqtt/logging"
 	"bessarabov/mac2mqtt/macos"
+	"bessarabov/mac2mqtt/simulator"
+	"bessarabov/mac2mqtt/state"
 
 	"gopkg.in/yaml.v2"
 
-	sigar "github.com/cloudfoundry/gosigar"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
-func init() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-}
+// Subsystem loggers
+var (
+	mqttLog     = logging.MQTT
+	mediaLog    = logging.Media
+	lmstudioLog = logging.LMStudio
+	displayLog  = logging.Display
+	activityLog = logging.Activity
+	systemLog   = logging.System
+)
 
 // Constants for the application
 const (
@@ -49,38 +61,52 @@ func (e *BetterDisplayCLIError) Error() string {
 	return e.message
 }
 
-// MediaControlError represents an error when Media Control is not available
-type MediaControlError struct {
-	message string
-}
-
-func (e *MediaControlError) Error() string {
-	return e.message
-}
-
 // Type aliases for convenience
 type MediaInfo = macos.MediaInfo
 type Display = macos.Display
 
+// State store keys for entities shared between goroutines
+var (
+	mediaStateKey     = state.Key[macos.MediaInfo]("media_state")       // published media state, see media_state.go
+	mediaSourceKey    = state.Key[macos.MediaInfo]("media_source")      // now playing item as reported by media-control
+	userActivityKey   = state.Key[string]("user_activity")              // "active" or "inactive"
+	lmstudioServerKey = state.Key[bool]("lmstudio_server")              // LM Studio server status
+	lmstudioModelsKey = state.Key[[]llm.Model]("lmstudio_models")       // All models (loaded + available)
+	networkStatsKey   = state.Key[*macos.NetworkStats]("network_stats") // For network speed calculation
+	displaysKey       = state.Key[[]macos.Display]("displays")          // BetterDisplay displays, refreshed with brightness
+	mediaStreamKey    = state.Key[mediaStreamHealth]("media_stream")    // media-control stream health
+)
+
 // Application holds the main application state
 type Application struct {
-	config            *config
-	displays          []macos.Display
-	hostname          string
-	topic             string
-	client            mqtt.Client
-	currentMediaState macos.MediaInfo // persistent media state for streaming
-	userActivityState string           // "active" or "inactive"
-	activityMutex     sync.RWMutex
-	activityTimer     *time.Timer
-	lastCPU           sigar.Cpu // for CPU percentage calculation
-	cpuMutex          sync.RWMutex
-	lmstudioServerRunning bool                 // LM Studio server status
-	lmstudioLoadedModels  []macos.LMStudioModel // Currently loaded models
-	lmstudioAllModels     []macos.LMStudioModel // All models (loaded + available)
-	lmstudioMutex        sync.RWMutex
-	lastNetworkStats     *macos.NetworkStats // For network speed calculation
-	lastNetworkTime      time.Time           // Last network stats update time
+	config        *config
+	hostname      string
+	topic         string
+	client        mqtt.Client
+	clientMutex   sync.RWMutex
+	store         *state.Store // latest value of every tracked entity
+	activityMutex sync.Mutex
+	activityTimer *time.Timer
+	backend       Backend     // macOS or simulated host
+	llmServer     llm.Backend // LM Studio, Ollama or llama.cpp; nil when disabled
+	chatMutex     sync.Mutex
+	chats         map[string]context.CancelFunc // in-flight LM Studio chat requests by ID
+	lmstudioIdle  *lmstudioIdleTracker          // last use of the loaded models, for idle unloading
+	lmstudioStats lmstudioStats                 // chat request statistics
+	lmstudioOps   lmstudioModelOps              // running loads and unloads by model ID
+	downloadMutex sync.Mutex
+	downloads     map[string]context.CancelFunc // running model downloads by model ID
+
+	lmstudioTopics *lmstudioModelTopics // topic names of the models, persisted in state_dir
+
+	mediaStreamOnce sync.Once // the media stream supervisor runs once for all MQTT connections
+	activityOnce    sync.Once // same for the user activity monitor
+
+	mediaMutex   sync.Mutex // keeps concurrent media publishers from overtaking each other
+	artworkMutex sync.Mutex
+	artworkHash  string // SHA-256 of the published artwork, empty when none is published
+
+	scrobbler *listenBrainzScrobbler // nil unless listenbrainz_token is set
 }
 
 type config struct {
@@ -95,38 +121,94 @@ type config struct {
 	IdleActivityTime int    `yaml:"idle_activity_time"` // in seconds
 	LMStudioEnabled  bool   `yaml:"lmstudio_enabled"`   // Enable LM Studio integration
 	LMStudioAPIURL   string `yaml:"lmstudio_api_url"`   // LM Studio API URL (default: http://localhost:1234)
+	LLMBackend       string `yaml:"llm_backend"`        // lmstudio, ollama or llamacpp; setting it enables the LLM integration
+	LLMAPIURL        string `yaml:"llm_api_url"`        // LLM server URL (default depends on llm_backend)
+
+	LMStudioIdleTTL      int            `yaml:"lmstudio_idle_ttl"`      // Unload models idle for this many seconds (0: never)
+	LMStudioModelTTLs    map[string]int `yaml:"lmstudio_model_ttls"`    // Idle TTL overrides by model ID (0: never)
+	LMStudioPinnedModels []string       `yaml:"lmstudio_pinned_models"` // Models that are never unloaded for being idle
+
+	LMStudioModelSelect   bool  `yaml:"lmstudio_model_select"`   // Publish a select that loads one model and unloads the others
+	LMStudioModelSwitches *bool `yaml:"lmstudio_model_switches"` // Publish a switch per model (default: true)
+
+	MediaArtworkSize      int     `yaml:"media_artwork_size"`      // Longest side of the published artwork in pixels (default: 512)
+	MediaPositionInterval float64 `yaml:"media_position_interval"` // Publish the live position every this many seconds while playing (0: only on changes)
+	MediaPlayerDiscovery  bool    `yaml:"media_player_discovery"`  // Announce a media player for the mqtt_media_player integration
+
+	MediaHistory           bool   `yaml:"media_history"`             // Keep a listening history in state_dir
+	MediaHistoryMinPercent int    `yaml:"media_history_min_percent"` // A play counts after this share of the track (default: 50)
+	MediaHistoryMinSeconds int    `yaml:"media_history_min_seconds"` // ... or after this many seconds (default: 240)
+	ListenBrainzToken      string `yaml:"listenbrainz_token"`        // Submit plays to ListenBrainz with this user token
+	ListenBrainzURL        string `yaml:"listenbrainz_url"`          // ListenBrainz compatible API (default: https://api.listenbrainz.org)
+
+	MediaIncludeApps    []string `yaml:"media_include_apps"`    // Only show media of these bundle IDs (default: all)
+	MediaExcludeApps    []string `yaml:"media_exclude_apps"`    // Never show media of these bundle IDs
+	MediaSourcePriority []string `yaml:"media_source_priority"` // Bundle IDs, first wins while it plays; unlisted apps come last
+
+	StateDir string `yaml:"state_dir"` // Directory for the files kept between restarts (default: next to the config file)
+
+	EmbeddedBroker *broker.Options `yaml:"embedded_broker"` // Run a broker inside mac2mqtt instead of using an external one
+
+	Backend    string            `yaml:"backend"`    // macos or simulated (default: macos)
+	Simulation simulator.Options `yaml:"simulation"` // Scenario for the simulated backend
+
+	LogLevel      string            `yaml:"log_level"`       // debug, info, warn or error (default: info)
+	LogFormat     string            `yaml:"log_format"`      // text or json (default: text)
+	LogFile       string            `yaml:"log_file"`        // Rotated log file, ignored when running under launchd
+	LogMaxSizeMB  int               `yaml:"log_max_size_mb"` // Rotate log_file after this many MB (default: 10)
+	LogMaxBackups int               `yaml:"log_max_backups"` // Number of rotated log files to keep (default: 3)
+	LogLevels     map[string]string `yaml:"log_levels"`      // Per-subsystem overrides, e.g. {mqtt: debug}
 }
 
-func (c *config) getConfig() *config {
+// loggingOptions returns the logging configuration
+func (c *config) loggingOptions() logging.Options {
+	return logging.Options{
+		Level:      c.LogLevel,
+		Format:     c.LogFormat,
+		File:       c.LogFile,
+		MaxSizeMB:  c.LogMaxSizeMB,
+		MaxBackups: c.LogMaxBackups,
+		Levels:     c.LogLevels,
+	}
+}
 
-	ex, err := os.Executable()
-	if err != nil {
-		panic(err)
+// getConfig loads the config from path, or from mac2mqtt.yaml next to the executable if path is empty
+func (c *config) getConfig(path string) *config {
+
+	if path == "" {
+		ex, err := os.Executable()
+		if err != nil {
+			panic(err)
+		}
+		path = filepath.Join(filepath.Dir(ex), "mac2mqtt.yaml")
 	}
-	exPath := filepath.Dir(ex)
 
-	log.Printf("Path: %v", exPath)
-	configContent, err := os.ReadFile(exPath + "/mac2mqtt.yaml")
+	systemLog.Debug("Config path", "path", path)
+	configContent, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal("No config file provided")
+		logging.Fatal(systemLog, "No config file provided", "err", err)
 	}
 
 	err = yaml.Unmarshal(configContent, c)
 	if err != nil {
-		log.Fatal("No data in config file")
+		logging.Fatal(systemLog, "No data in config file", "err", err)
+	}
+
+	if c.EmbeddedBroker != nil {
+		c.useEmbeddedBroker()
 	}
 
 	if c.IP == "" {
-		log.Fatal("Must specify mqtt_ip in mac2mqtt.yaml")
+		logging.Fatal(systemLog, "Must specify mqtt_ip in mac2mqtt.yaml")
 	}
 
 	if c.IdleActivityTime == 0 {
-		log.Println("No idle_activity_time specified in config, using default 10 seconds")
+		systemLog.Info("No idle_activity_time specified in config, using default 10 seconds")
 
 	}
 
 	if c.Port == "" {
-		log.Fatal("Must specify mqtt_port in mac2mqtt.yaml")
+		logging.Fatal(systemLog, "Must specify mqtt_port in mac2mqtt.yaml")
 	}
 
 	if c.Hostname == "" {
@@ -135,19 +217,100 @@ func (c *config) getConfig() *config {
 	if c.DiscoveryPrefix == "" {
 		c.DiscoveryPrefix = "homeassistant"
 	}
-	if c.LMStudioAPIURL == "" {
-		c.LMStudioAPIURL = "http://localhost:1234"
+	if c.StateDir == "" {
+		c.StateDir = filepath.Dir(path)
 	}
 	return c
 }
 
-// NewApplication creates and initializes a new Application instance
-func NewApplication() (*Application, error) {
-	app := &Application{}
+// llmEnabled reports whether an LLM server should be controlled
+func (c *config) llmEnabled() bool {
+	return c.LMStudioEnabled || c.LLMBackend != ""
+}
+
+// modelSwitchesEnabled reports whether a switch is published for each model
+func (c *config) modelSwitchesEnabled() bool {
+	return c.LMStudioModelSwitches == nil || *c.LMStudioModelSwitches
+}
+
+// llmAPIURL returns the LLM server URL: llm_api_url, then lmstudio_api_url for LM Studio, then the default
+func (c *config) llmAPIURL() string {
+	if c.LLMAPIURL != "" {
+		return c.LLMAPIURL
+	}
+	if (c.LLMBackend == "" || c.LLMBackend == llm.KindLMStudio) && c.LMStudioAPIURL != "" {
+		return c.LMStudioAPIURL
+	}
+	return llm.DefaultAPIURL(c.LLMBackend)
+}
+
+// useEmbeddedBroker points the MQTT connection at the embedded broker unless
+// mqtt_ip/mqtt_port are set explicitly, and logs in as the first configured user
+func (c *config) useEmbeddedBroker() {
+	listen := c.EmbeddedBroker.Listen
+	if listen == "" {
+		listen = broker.DefaultListen
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		logging.Fatal(systemLog, "Invalid embedded_broker listen address", "listen", listen, "err", err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	if c.IP == "" {
+		c.IP = host
+	}
+	if c.Port == "" {
+		c.Port = port
+	}
+	if c.User == "" && len(c.EmbeddedBroker.Users) > 0 {
+		c.User = c.EmbeddedBroker.Users[0].Username
+		c.Password = c.EmbeddedBroker.Users[0].Password
+	}
+}
 
+// NewApplication loads the config file and creates an Application for the configured backend.
+// simulate forces the simulated backend regardless of the config.
+func NewApplication(configPath string, simulate bool) (*Application, error) {
 	// Load configuration
-	app.config = &config{}
-	app.config.getConfig()
+	cfg := &config{}
+	cfg.getConfig(configPath)
+
+	// Configure logging before anything else logs
+	if err := logging.Setup(cfg.loggingOptions()); err != nil {
+		return nil, fmt.Errorf("invalid logging configuration: %w", err)
+	}
+	mqtt.ERROR = logging.NewLogLogger("mqtt", slog.LevelError)
+	mqtt.CRITICAL = logging.NewLogLogger("mqtt", slog.LevelError)
+	mqtt.WARN = logging.NewLogLogger("mqtt", slog.LevelWarn)
+
+	if simulate {
+		cfg.Backend = "simulated"
+	}
+	var backend Backend
+	switch cfg.Backend {
+	case "", "macos":
+		backend = newMacOSBackend()
+	case "simulated":
+		systemLog.Warn("Running in simulation mode, all sensor data is synthetic")
+		backend = simulator.New(cfg.Simulation)
+	default:
+		return nil, fmt.Errorf("unknown backend %q (expected macos or simulated)", cfg.Backend)
+	}
+
+	return newApplication(cfg, backend)
+}
+
+// newApplication creates an Application for an already loaded config and backend
+func newApplication(cfg *config, backend Backend) (*Application, error) {
+	app := &Application{
+		config:       cfg,
+		backend:      backend,
+		chats:        make(map[string]context.CancelFunc),
+		downloads:    make(map[string]context.CancelFunc),
+		lmstudioIdle: newLMStudioIdleTracker(),
+	}
 
 	// Set hostname and sanitize it (remove spaces and special characters for MQTT topics)
 	if app.config.Hostname == "" {
@@ -175,28 +338,57 @@ func NewApplication() (*Application, error) {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
 	}
 
-	// Initialize displays
-	app.displays = macos.GetDisplays()
+	app.store = state.New()
 
-	// Initialize currentMediaState
-	if macos.IsMediaControlAvailable() {
-		mediaInfo, err := macos.GetMediaInfo()
-		if err == nil && mediaInfo != nil {
-			app.currentMediaState = *mediaInfo
-		} else {
-			app.currentMediaState = macos.MediaInfo{State: "idle"}
+	if cfg.llmEnabled() {
+		server, err := app.backend.NewLLM(cfg.LLMBackend, cfg.llmAPIURL())
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		app.currentMediaState = macos.MediaInfo{State: "idle"}
+		app.llmServer = server
+
+		var topicsPath string
+		if cfg.StateDir != "" {
+			topicsPath = filepath.Join(cfg.StateDir, lmstudioTopicsFile)
+		}
+		topics, err := loadLMStudioModelTopics(topicsPath)
+		if err != nil {
+			lmstudioLog.Warn("Failed to read the model topics, models may get new entity IDs", "file", topicsPath, "err", err)
+		}
+		app.lmstudioTopics = topics
 	}
 
-	// Initialize user activity state
-	app.userActivityState = "inactive"
+	// Initialize displays
+	state.Set(app.store, displaysKey, app.backend.GetDisplays())
+
+	// Initialize media state and publish every later change
+	state.Set(app.store, mediaStateKey, macos.MediaInfo{State: "idle"})
+	state.Set(app.store, mediaSourceKey, macos.MediaInfo{State: "idle"})
+	app.startMediaHistory()
+	if app.backend.IsMediaControlAvailable() {
+		app.refreshMedia()
+	}
+	app.store.Subscribe(string(mediaStateKey), func(change state.Change) {
+		if client := app.getClient(); client != nil && client.IsConnected() {
+			app.publishMedia(client)
+		}
+	})
 
-	// Initialize CPU stats for percentage calculation
-	if err := app.lastCPU.Get(); err != nil {
-		log.Printf("Warning: Failed to initialize CPU stats: %v", err)
-	}
+	// Publish every change of the media stream health
+	app.store.Subscribe(string(mediaStreamKey), func(change state.Change) {
+		if client := app.getClient(); client != nil && client.IsConnected() {
+			app.publishMediaStreamHealth(client)
+		}
+	})
+
+	// Initialize user activity state and publish every later change
+	state.Set(app.store, userActivityKey, "inactive")
+	app.store.Subscribe(string(userActivityKey), func(change state.Change) {
+		activityLog.Info("User activity state changed", "state", change.Current.Value)
+		if client := app.getClient(); client != nil && client.IsConnected() {
+			app.publishUserActivityState(client)
+		}
+	})
 
 	return app, nil
 }
@@ -212,332 +404,82 @@ func (app *Application) validateConfig() error {
 	if app.config.DiscoveryPrefix == "" {
 		app.config.DiscoveryPrefix = DefaultDiscoveryPrefix
 	}
-	return nil
-}
-
-// getTopicPrefix returns the topic prefix for this application
-func (app *Application) getTopicPrefix() string {
-	return app.topic
-}
-
-
-
-
-
-
-
-
-
-// updateMediaPlayer updates the MQTT topics with current media player information
-func (app *Application) updateMediaPlayer(client mqtt.Client) {
-	mediaInfo, err := macos.GetMediaInfo()
-	if err != nil {
-		// Check if it's a Media Control error
-		if _, ok := err.(*MediaControlError); ok {
-			log.Printf("Media Control is not available: %v", err)
-			log.Println("To install Media Control:")
-			log.Println("  1. Install via npm: npm install -g media-control")
-			log.Println("  2. Or install via Homebrew: brew install media-control")
-			log.Println("Media player information will be disabled until Media Control is available")
-		} else {
-			log.Printf("Error getting media info: %v", err)
-		}
-		return
-	}
-
-	// If no media is playing, publish empty state
-	if mediaInfo == nil {
-		log.Println("No media playing - publishing idle state")
-		app.publishMediaState(client, "idle", "", "", "", "", 0, 0)
-		return
-	}
-
-	// Determine the state
-	state := "idle"
-	switch mediaInfo.State {
-	case "playing":
-		state = "playing"
-	case "paused":
-		state = "paused"
-	case "stopped":
-		state = "idle"
+	if app.config.LMStudioIdleTTL < 0 {
+		return fmt.Errorf("lmstudio_idle_ttl must not be negative")
 	}
-
-	log.Printf("Media playing: %s - %s (%s)", mediaInfo.Artist, mediaInfo.Title, state)
-	app.publishMediaState(client, state, mediaInfo.Title, mediaInfo.Artist, mediaInfo.Album, mediaInfo.AppName, mediaInfo.Duration, mediaInfo.Position)
-}
-
-// updateNowPlaying updates the now playing sensor with current media information
-func (app *Application) updateNowPlaying(client mqtt.Client) {
-	mediaInfo, err := macos.GetMediaInfo()
-	if err != nil {
-		if _, ok := err.(*MediaControlError); ok {
-			log.Printf("Media Control is not available: %v", err)
-			return
-			//revive:disable-next-line
-		} else {
-			log.Printf("Error getting media info: %v", err)
-			return
+	for model, ttl := range app.config.LMStudioModelTTLs {
+		if ttl < 0 {
+			return fmt.Errorf("lmstudio_model_ttls: TTL of %s must not be negative", model)
 		}
 	}
-
-	// If no media is playing, publish idle state
-	if mediaInfo == nil {
-		state := "idle"
-		client.Publish(app.getTopicPrefix()+"/status/now_playing", 0, false, state)
-		attr := map[string]interface{}{
-			"state":    state,
-			"title":    "",
-			"artist":   "",
-			"album":    "",
-			"app_name": "",
-			"duration": 0,
-			"position": 0,
-		}
-		attrJSON, _ := json.Marshal(attr)
-		client.Publish(app.getTopicPrefix()+"/status/now_playing_attr", 0, false, string(attrJSON))
-		return
+	if app.config.MediaArtworkSize < 0 {
+		return fmt.Errorf("media_artwork_size must not be negative")
 	}
-
-	// Determine the state
-	state := "idle"
-	switch mediaInfo.State {
-	case "playing":
-		state = "playing"
-	case "paused":
-		state = "paused"
-	case "stopped":
-		state = "idle"
-	}
-
-	// Publish state and attributes
-	client.Publish(app.getTopicPrefix()+"/status/now_playing", 0, false, state)
-	attr := map[string]interface{}{
-		"state":    state,
-		"title":    mediaInfo.Title,
-		"artist":   mediaInfo.Artist,
-		"album":    mediaInfo.Album,
-		"app_name": mediaInfo.AppName,
-		"duration": mediaInfo.Duration,
-		"position": mediaInfo.Position,
-	}
-	attrJSON, _ := json.Marshal(attr)
-	client.Publish(app.getTopicPrefix()+"/status/now_playing_attr", 0, false, string(attrJSON))
-	log.Printf("Updated now playing sensor: %s - %s (%s)", mediaInfo.Artist, mediaInfo.Title, state)
-}
-
-// startMediaStream starts the media-control stream for real-time updates
-func (app *Application) startMediaStream(client mqtt.Client) {
-	if !macos.IsMediaControlAvailable() {
-		log.Println("Media Control not available - skipping media stream")
-		return
+	if app.config.MediaPositionInterval < 0 {
+		return fmt.Errorf("media_position_interval must not be negative")
 	}
-
-	log.Println("Starting media-control stream for real-time updates...")
-
-	cmd := exec.Command("media-control", "stream")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Printf("Error creating stdout pipe for media stream: %v", err)
-		return
+	if app.config.MediaHistoryMinPercent < 0 || app.config.MediaHistoryMinPercent > 100 {
+		return fmt.Errorf("media_history_min_percent must be between 0 and 100")
 	}
-
-	if err := cmd.Start(); err != nil {
-		log.Printf("Error starting media-control stream: %v", err)
-		return
+	if app.config.MediaHistoryMinSeconds < 0 {
+		return fmt.Errorf("media_history_min_seconds must not be negative")
 	}
-
-	// Read the stream in a goroutine with error recovery
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("Media stream goroutine recovered from panic: %v", r)
-			}
-			cmd.Wait()
-			stdout.Close()
-		}()
-
-		scanner := bufio.NewScanner(stdout)
-		// Increase buffer size to handle long JSON lines from media-control stream
-		buf := make([]byte, 0, 64*1024) // 64KB buffer
-		scanner.Buffer(buf, 1024*1024)  // Allow up to 1MB tokens
-
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line == "" {
-				continue
-			}
-
-			// Parse the JSON line from the stream
-			var mediaData map[string]interface{}
-			if err := json.Unmarshal([]byte(line), &mediaData); err != nil {
-				log.Printf("Error parsing media stream JSON: %v", err)
-				continue
-			}
-
-			// Process the media update only if MQTT client is connected
-			if client.IsConnected() {
-				app.processMediaStreamUpdate(client, mediaData)
-			}
-		}
-
-		if err := scanner.Err(); err != nil {
-			log.Printf("Error reading media stream: %v", err)
-			log.Println("Media stream will restart on next application restart")
-		}
-	}()
-
-	log.Println("Media stream started successfully")
+	return nil
 }
 
-// processMediaStreamUpdate processes a single media update from the stream
-func (app *Application) processMediaStreamUpdate(client mqtt.Client, mediaData map[string]interface{}) {
-	// The stream sends {"type":"data","diff":true,"payload":{...}}
-	// Only update fields present in payload
-	payload, ok := mediaData["payload"].(map[string]interface{})
-	if !ok {
-		log.Printf("Media stream: No payload in event, skipping")
-		return
-	}
-
-	// Merge payload into currentMediaState
-	for k, v := range payload {
-		switch k {
-		case "title":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.Title = s
-			}
-		case "artist":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.Artist = s
-			}
-		case "album":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.Album = s
-			}
-		case "appName":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.AppName = s
-			}
-		case "bundleIdentifier":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.AppName = s
-			}
-		case "playing":
-			if b, ok := v.(bool); ok {
-				if b {
-					app.currentMediaState.State = "playing"
-				} else {
-					app.currentMediaState.State = "paused"
-				}
-			}
-		case "duration":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Duration = int(f)
-			}
-		case "durationMicros":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Duration = int(f / 1000000)
-			}
-		case "totalTime":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Duration = int(f)
-			}
-		case "totalDuration":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Duration = int(f)
-			}
-		case "elapsedTime":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Position = int(f)
-			}
-		case "position":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Position = int(f)
-			}
-		case "positionMicros":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Position = int(f / 1000000)
-			}
-		}
-	}
-
-	// If playing is false and no other info, treat as idle
-	if state, ok := payload["playing"]; ok {
-		if b, ok := state.(bool); ok && !b {
-			app.currentMediaState.State = "idle"
-		}
-	}
+// getTopicPrefix returns the topic prefix for this application
+func (app *Application) getTopicPrefix() string {
+	return app.topic
+}
 
-	// Publish state and attributes
-	client.Publish(app.getTopicPrefix()+"/status/now_playing", 0, false, app.currentMediaState.State)
-	attr := map[string]interface{}{
-		"state":    app.currentMediaState.State,
-		"title":    app.currentMediaState.Title,
-		"artist":   app.currentMediaState.Artist,
-		"album":    app.currentMediaState.Album,
-		"app_name": app.currentMediaState.AppName,
-		"duration": app.currentMediaState.Duration,
-		"position": app.currentMediaState.Position,
-	}
-	attrJSON, _ := json.Marshal(attr)
-	client.Publish(app.getTopicPrefix()+"/status/now_playing_attr", 0, false, string(attrJSON))
-	log.Printf("Media stream update: %s - %s (%s)", app.currentMediaState.Artist, app.currentMediaState.Title, app.currentMediaState.State)
+// getClient returns the current MQTT client, which may be nil before the first connection
+func (app *Application) getClient() mqtt.Client {
+	app.clientMutex.RLock()
+	defer app.clientMutex.RUnlock()
+	return app.client
 }
 
 // getUserActivityState gets the current user activity state
 func (app *Application) getUserActivityState() string {
-	app.activityMutex.RLock()
-	defer app.activityMutex.RUnlock()
-	return app.userActivityState
+	return state.Value(app.store, userActivityKey)
 }
 
-// setUserActivityState sets the user activity state and publishes to MQTT
-func (app *Application) setUserActivityState(client mqtt.Client, state string) {
-	app.activityMutex.Lock()
-	defer app.activityMutex.Unlock()
+// setUserActivityState sets the user activity state; changes are published by the store subscriber
+func (app *Application) setUserActivityState(activity string) {
+	state.Set(app.store, userActivityKey, activity)
+}
 
-	if app.userActivityState != state {
-		app.userActivityState = state
-		if client != nil && client.IsConnected() {
-			client.Publish(app.getTopicPrefix()+"/status/user_activity", 0, false, state)
-			log.Printf("User activity state changed to: %s", state)
-		}
-	}
+// publishUserActivityState publishes the current user activity state to MQTT
+func (app *Application) publishUserActivityState(client mqtt.Client) {
+	client.Publish(app.getTopicPrefix()+"/status/user_activity", 0, false, app.getUserActivityState())
 }
 
 // resetActivityTimer resets the inactivity timer
-func (app *Application) resetActivityTimer(client mqtt.Client) {
+func (app *Application) resetActivityTimer() {
+	// Set to active immediately
+	app.setUserActivityState("active")
+
 	app.activityMutex.Lock()
 	defer app.activityMutex.Unlock()
 
-	// Set to active immediately
-	if app.userActivityState != "active" {
-		app.userActivityState = "active"
-		if client != nil && client.IsConnected() {
-			client.Publish(app.getTopicPrefix()+"/status/user_activity", 0, false, "active")
-			log.Printf("User activity detected - state: active")
-		}
-	}
-
 	// Reset or create the timer
 	if app.activityTimer != nil {
 		app.activityTimer.Stop()
 	}
 
 	app.activityTimer = time.AfterFunc(time.Duration(app.config.IdleActivityTime)*time.Second, func() {
-		app.setUserActivityState(client, "inactive")
+		app.setUserActivityState("inactive")
 	})
 }
 
 // startUserActivityMonitoring starts monitoring user activity using system idle time
 func (app *Application) startUserActivityMonitoring(client mqtt.Client) {
-	log.Println("Starting user activity monitoring...")
+	activityLog.Info("Starting user activity monitoring...")
 
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("Activity monitor goroutine recovered from panic: %v", r)
+				activityLog.Error("Activity monitor goroutine recovered from panic", "panic", r)
 			}
 		}()
 
@@ -550,16 +492,16 @@ func (app *Application) startUserActivityMonitoring(client mqtt.Client) {
 				continue
 			}
 
-			idleTime, err := macos.GetSystemIdleTime()
+			idleTime, err := app.backend.GetSystemIdleTime()
 			if err != nil {
-				log.Printf("Error getting system idle time: %v", err)
+				activityLog.Warn("Error getting system idle time", "err", err)
 				time.Sleep(2 * time.Second)
 				continue
 			}
 
 			// If idle time decreased or is very small, user is active
 			if idleTime < lastIdleTime || idleTime < 2 {
-				app.resetActivityTimer(client)
+				app.resetActivityTimer()
 			}
 
 			lastIdleTime = idleTime
@@ -569,65 +511,33 @@ func (app *Application) startUserActivityMonitoring(client mqtt.Client) {
 		}
 	}()
 
-	log.Println("User activity monitoring started successfully")
-}
-
-// publishMediaState publishes the current media state to MQTT
-func (app *Application) publishMediaState(client mqtt.Client, state, title, artist, album, appName string, duration, position int) {
-	// Publish individual attributes
-	client.Publish(app.getTopicPrefix()+"/status/media_state", 0, false, state)
-	client.Publish(app.getTopicPrefix()+"/status/media_title", 0, false, title)
-	client.Publish(app.getTopicPrefix()+"/status/media_artist", 0, false, artist)
-	client.Publish(app.getTopicPrefix()+"/status/media_album", 0, false, album)
-	client.Publish(app.getTopicPrefix()+"/status/media_app", 0, false, appName)
-	client.Publish(app.getTopicPrefix()+"/status/media_duration", 0, false, strconv.Itoa(duration))
-	client.Publish(app.getTopicPrefix()+"/status/media_position", 0, false, strconv.Itoa(position))
-
-	// Publish combined JSON state for media_player entity
-	mediaState := map[string]interface{}{
-		"state":        state,
-		"title":        title,
-		"artist":       artist,
-		"album":        album,
-		"app_name":     appName,
-		"duration":     duration,
-		"position":     position,
-		"media_title":  title,
-		"media_artist": artist,
-		"media_album":  album,
-	}
-
-	stateJSON, _ := json.Marshal(mediaState)
-	mediaPlayerTopic := app.getTopicPrefix() + "/status/media_player"
-	client.Publish(mediaPlayerTopic, 0, false, string(stateJSON))
-	log.Printf("Published media state to %s: %s", mediaPlayerTopic, string(stateJSON))
+	activityLog.Info("User activity monitoring started successfully")
 }
 
 // updateDisplayBrightness updates the MQTT topics with current display brightness values
 func (app *Application) updateDisplayBrightness(client mqtt.Client) {
 	// Skip if no displays are available
-	if len(app.displays) == 0 {
+	if len(state.Value(app.store, displaysKey)) == 0 {
 		return
 	}
 
 	// Refresh display list to handle dynamic display changes (laptop open/close)
-	currentDisplays := macos.GetDisplays()
-	if currentDisplays != nil {
-		app.displays = currentDisplays
+	if currentDisplays := app.backend.GetDisplays(); currentDisplays != nil {
+		state.Set(app.store, displaysKey, currentDisplays)
 	}
 
-	for _, display := range app.displays {
-		brightness, err := macos.GetDisplayBrightness(display.DisplayID)
+	for _, display := range state.Value(app.store, displaysKey) {
+		brightness, err := app.backend.GetDisplayBrightness(display.DisplayID)
 		if err != nil {
 			// Only log error once per minute to avoid spam for unavailable displays (e.g., closed laptop)
 			if display.Name == "Built-in Display" || strings.Contains(display.Name, "Built-in") {
 				// Silently skip built-in display when unavailable (laptop closed)
 				continue
 			}
-			log.Printf("Error getting brightness for display %s: %v", display.Name, err)
+			displayLog.Warn("Error getting brightness", "display", display.Name, "err", err)
 			// Check if it's a BetterDisplay CLI error
-			if !macos.IsBetterDisplayCLIAvailable() {
-				log.Printf("BetterDisplay CLI is not available for display %s", display.Name)
+			if !app.backend.IsBetterDisplayCLIAvailable() {
+				displayLog.Warn("BetterDisplay CLI is not available", "display", display.Name)
 			}
 			continue
 		}
@@ -638,12 +548,12 @@ func (app *Application) updateDisplayBrightness(client mqtt.Client) {
 }
 
 func (app *Application) messagePubHandler(client mqtt.Client, msg mqtt.Message) {
-	log.Printf("Received message: %s from topic: %s\n", msg.Payload(), msg.Topic())
+	mqttLog.Debug("Received message", "topic", msg.Topic(), "payload", string(msg.Payload()))
 	app.listen(client, msg)
 }
 
 func (app *Application) connectHandler(client mqtt.Client) {
-	log.Println("Connected to MQTT")
+	mqttLog.Info("Connected to MQTT")
 
 	// Set up device configuration (in case this is a reconnection)
 	app.setDevice(client)
@@ -651,35 +561,34 @@ func (app *Application) connectHandler(client mqtt.Client) {
 	token := client.Publish(app.getTopicPrefix()+"/status/alive", 0, true, "online")
 	token.Wait()
 
-	log.Println("Sending 'online' to topic: " + app.getTopicPrefix() + "/status/alive")
+	mqttLog.Debug("Sent online status", "topic", app.getTopicPrefix()+"/status/alive")
 	app.sub(client, app.getTopicPrefix()+"/command/#")
 
-	// Start media stream if not already running (for reconnections)
-	if macos.IsMediaControlAvailable() {
-		go app.startMediaStream(client)
+	// Start the media stream and user activity monitoring unless they already run (reconnection)
+	app.startMediaSupervisor()
+	if app.backend.IsMediaControlAvailable() {
+		app.publishMediaStreamHealth(client)
 	}
-
-	// Start user activity monitoring
-	go app.startUserActivityMonitoring(client)
+	app.activityOnce.Do(func() { app.startUserActivityMonitoring(client) })
 
 	// Send initial state updates
 	app.updateVolume(client)
 	app.updateMute(client)
 	app.updateCaffeinateStatus(client)
 	app.updateDisplayBrightness(client)
-	app.updateNowPlaying(client)
-	app.setUserActivityState(client, "inactive") // Initial state
+	app.publishMedia(client)
+	app.publishUserActivityState(client)
 }
 
 func (app *Application) connectLostHandler(_ mqtt.Client, err error) {
-	log.Printf("Disconnected from MQTT: %v", err)
+	mqttLog.Error("Disconnected from MQTT", "err", err)
 
 	// Check if it's a network issue
 	if !app.isNetworkReachable() {
-		log.Println("MQTT broker is not reachable - likely on a different network")
-		log.Println("Will retry connection when network becomes available")
+		mqttLog.Warn("MQTT broker is not reachable - likely on a different network")
+		mqttLog.Info("Will retry connection when network becomes available")
 	} else {
-		log.Println("MQTT client will attempt to reconnect automatically...")
+		mqttLog.Info("MQTT client will attempt to reconnect automatically...")
 	}
 }
 
@@ -689,11 +598,16 @@ func (app *Application) getMQTTClient() error {
 
 // isNetworkReachable checks if the MQTT broker is reachable before attempting connection
 func (app *Application) isNetworkReachable() bool {
+	// The embedded broker runs in-process, probing it only adds log noise
+	if app.config.EmbeddedBroker != nil {
+		return true
+	}
+
 	// Try to connect to the broker with a short timeout
 	timeout := 5 * time.Second
 	conn, err := net.DialTimeout("tcp", net.JoinHostPort(app.config.IP, app.config.Port), timeout)
 	if err != nil {
-		log.Printf("Network check failed: MQTT broker %s:%s is not reachable (%v)", app.config.IP, app.config.Port, err)
+		mqttLog.Warn("Network check failed: MQTT broker is not reachable", "host", app.config.IP, "port", app.config.Port, "err", err)
 		return false
 	}
 	conn.Close()
@@ -708,7 +622,7 @@ func (app *Application) getMQTTClientWithRetry(retryCount int) error {
 
 	// Check network reachability first to avoid long timeouts
 	if !app.isNetworkReachable() {
-		log.Printf("MQTT broker is not reachable on current network, will retry later")
+		mqttLog.Warn("MQTT broker is not reachable on current network, will retry later")
 		return fmt.Errorf("MQTT broker not reachable")
 	}
 
@@ -720,7 +634,7 @@ func (app *Application) getMQTTClientWithRetry(retryCount int) error {
 		protocol = "ssl"
 	}
 	brokerURL := fmt.Sprintf("%s://%s:%s", protocol, app.config.IP, app.config.Port)
-	log.Printf("Connecting to MQTT broker: %s", brokerURL)
+	mqttLog.Info("Connecting to MQTT broker", "url", brokerURL)
 
 	opts.AddBroker(brokerURL)
 	if app.config.User != "" {
@@ -760,27 +674,29 @@ func (app *Application) getMQTTClientWithRetry(retryCount int) error {
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
 		// If SSL connection fails, try falling back to non-SSL
 		if app.config.SSL {
-			log.Printf("SSL connection failed: %v. Trying non-SSL connection...", token.Error())
+			mqttLog.Warn("SSL connection failed, trying non-SSL connection...", "err", token.Error())
 			app.config.SSL = false
 			return app.getMQTTClientWithRetry(retryCount + 1)
 		}
 		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
 	}
 
+	app.clientMutex.Lock()
 	app.client = client
+	app.clientMutex.Unlock()
 	return nil
 }
 
 func (app *Application) sub(client mqtt.Client, topic string) {
 	token := client.Subscribe(topic, 0, app.messagePubHandler)
 	token.Wait()
-	log.Printf("Subscribed to topic: %s\n", topic)
+	mqttLog.Debug("Subscribed to topic", "topic", topic)
 }
 
 func (app *Application) listen(client mqtt.Client, msg mqtt.Message) {
 	topic := msg.Topic()
 	payload := string(msg.Payload())
-	log.Printf("listen() called with topic: %s, payload: %s", topic, payload)
+	mqttLog.Debug("Handling command", "topic", topic, "payload", payload)
 
 	// Handle volume commands
 	if app.handleVolumeCommand(client, topic, payload) {
@@ -817,8 +733,18 @@ func (app *Application) listen(client mqtt.Client, msg mqtt.Message) {
 		return
 	}
 
+	// Handle media player volume commands
+	if app.handleMediaPlayerVolumeCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle media transport commands
+	if app.handleMediaCommand(client, topic, payload) {
+		return
+	}
+
 	// Handle LM Studio commands
-	if app.config.LMStudioEnabled {
+	if app.llmServer != nil {
 		if app.handleLMStudioCommand(client, topic, payload) {
 			return
 		}
@@ -833,11 +759,11 @@ func (app *Application) handleVolumeCommand(client mqtt.Client, topic, payload s
 
 	volume, err := app.validateVolumeInput(payload)
 	if err != nil {
-		log.Printf("Invalid volume value: %v", err)
+		mqttLog.Warn("Invalid volume value", "err", err)
 		return true
 	}
 
-	macos.SetVolume(volume)
+	app.backend.SetVolume(volume)
 	app.updateVolume(client)
 	app.updateMute(client)
 	return true
@@ -851,11 +777,11 @@ func (app *Application) handleMuteCommand(client mqtt.Client, topic, payload str
 
 	mute, err := app.validateMuteInput(payload)
 	if err != nil {
-		log.Printf("Invalid mute value: %v", err)
+		mqttLog.Warn("Invalid mute value", "err", err)
 		return true
 	}
 
-	macos.SetMute(mute)
+	app.backend.SetMute(mute)
 	app.updateVolume(client)
 	app.updateMute(client)
 	return true
@@ -869,17 +795,17 @@ func (app *Application) handleSystemCommand(topic, payload string) bool {
 
 	switch payload {
 	case "sleep":
-		macos.Sleep()
+		app.backend.Sleep()
 	case "displaysleep":
-		macos.DisplaySleep()
+		app.backend.DisplaySleep()
 	case "displaywake":
-		macos.DisplayWake()
+		app.backend.DisplayWake()
 	case "shutdown":
-		macos.Shutdown()
+		app.backend.Shutdown()
 	case "screensaver":
-		macos.Screensaver()
+		app.backend.Screensaver()
 	default:
-		log.Printf("Unknown system command: %s", payload)
+		mqttLog.Warn("Unknown system command", "payload", payload)
 	}
 	return true
 }
@@ -887,25 +813,26 @@ func (app *Application) handleSystemCommand(topic, payload string) bool {
 // handleDisplayBrightnessCommand handles display brightness commands
 func (app *Application) handleDisplayBrightnessCommand(client mqtt.Client, topic, payload string) bool {
 	// Check if we have any displays available
-	if len(app.displays) == 0 {
+	displays := state.Value(app.store, displaysKey)
+	if len(displays) == 0 {
 		return false // Return false so other handlers can process the command
 	}
 
-	for _, display := range app.displays {
+	for _, display := range displays {
 		commandTopic := app.getTopicPrefix() + "/command/display_" + display.DisplayID + "_brightness"
 		if topic == commandTopic {
 			brightness, err := app.validateBrightnessInput(payload)
 			if err != nil {
-				log.Printf("Invalid brightness value for display %s: %v", display.Name, err)
+				displayLog.Warn("Invalid brightness value", "display", display.Name, "err", err)
 				return true
 			}
 
-			err = macos.SetDisplayBrightness(display.DisplayID, brightness)
+			err = app.backend.SetDisplayBrightness(display.DisplayID, brightness)
 			if err != nil {
-				log.Printf("Error setting brightness for display %s: %v", display.Name, err)
+				displayLog.Error("Error setting brightness", "display", display.Name, "err", err)
 				// Check if it's a BetterDisplay CLI error
-				if !macos.IsBetterDisplayCLIAvailable() {
-					log.Println("BetterDisplay CLI is not available. Please install BetterDisplay and enable CLI access.")
+				if !app.backend.IsBetterDisplayCLIAvailable() {
+					displayLog.Warn("BetterDisplay CLI is not available. Please install BetterDisplay and enable CLI access.")
 				}
 			} else {
 				// Update the status immediately
@@ -925,11 +852,11 @@ func (app *Application) handleShortcutCommand(topic, payload string) bool {
 	}
 
 	if err := app.validateShortcutInput(payload); err != nil {
-		log.Printf("Invalid shortcut: %v", err)
+		mqttLog.Warn("Invalid shortcut", "err", err)
 		return true
 	}
 
-	macos.RunShortcut(payload)
+	app.backend.RunShortcut(payload)
 	return true
 }
 
@@ -941,14 +868,14 @@ func (app *Application) handleKeepAwakeCommand(client mqtt.Client, topic, payloa
 
 	keepAwake, err := app.validateKeepAwakeInput(payload)
 	if err != nil {
-		log.Printf("Invalid keep awake value: %v", err)
+		mqttLog.Warn("Invalid keep awake value", "err", err)
 		return true
 	}
 
 	if keepAwake {
-		macos.KeepAwake()
+		app.backend.KeepAwake()
 	} else {
-		macos.AllowSleep()
+		app.backend.AllowSleep()
 	}
 	app.updateCaffeinateStatus(client)
 	return true
@@ -961,10 +888,10 @@ func (app *Application) handlePlayPauseCommand(client mqtt.Client, topic, payloa
 	}
 
 	if payload == "playpause" {
-		macos.PlayPause()
-		// Update the now playing sensor after a short delay to reflect the new state
+		app.backend.PlayPause()
+		// Update the media state after a short delay to reflect the new state
 		time.Sleep(500 * time.Millisecond)
-		app.updateNowPlaying(client)
+		app.refreshMedia()
 	}
 	return true
 }
@@ -972,98 +899,115 @@ func (app *Application) handlePlayPauseCommand(client mqtt.Client, topic, payloa
 // handleLMStudioCommand handles LM Studio control commands
 func (app *Application) handleLMStudioCommand(client mqtt.Client, topic, payload string) bool {
 	basePrefix := app.getTopicPrefix()
-	log.Printf("handleLMStudioCommand called: topic=%s, payload=%s", topic, payload)
+	lmstudioLog.Debug("handleLMStudioCommand called", "topic", topic, "payload", payload)
 
 	// Handle server start/stop
 	if topic == basePrefix+"/command/lmstudio_server" {
 		switch payload {
 		case "start":
-			if err := macos.StartLMStudioServer(); err != nil {
-				log.Printf("Failed to start LM Studio server: %v", err)
+			controller, ok := app.llmServer.(llm.ServerController)
+			if !ok {
+				lmstudioLog.Warn("The LLM server can't be started by mac2mqtt", "server", app.llmServer.Name())
+				break
+			}
+			if err := controller.StartServer(); err != nil {
+				lmstudioLog.Error("Failed to start LM Studio server", "err", err)
 			} else {
-				log.Println("LM Studio server start command sent")
+				lmstudioLog.Info("LM Studio server start command sent")
 				// Wait a bit for the server to start and then update status
 				time.Sleep(3 * time.Second)
 				app.updateLMStudioStatus(client)
 			}
 		case "stop":
+			controller, ok := app.llmServer.(llm.ServerController)
+			if !ok {
+				lmstudioLog.Warn("The LLM server can't be stopped by mac2mqtt", "server", app.llmServer.Name())
+				break
+			}
 			// First, unload all models before stopping the server
-			log.Println("Unloading all models before stopping LM Studio server...")
-			if err := macos.UnloadAllLMStudioModels(); err != nil {
-				log.Printf("Warning: Failed to unload all models: %v", err)
+			lmstudioLog.Info("Unloading all models before stopping LM Studio server...")
+			if err := app.llmServer.UnloadAllModels(); err != nil {
+				lmstudioLog.Warn("Failed to unload all models", "err", err)
 				// Continue with server stop even if unload fails
 			} else {
-				log.Println("All models unloaded successfully")
+				lmstudioLog.Info("All models unloaded successfully")
 				// Wait a bit for models to fully unload
 				time.Sleep(2 * time.Second)
 			}
 
 			// Now stop the server
-			if err := macos.StopLMStudioServer(); err != nil {
-				log.Printf("Failed to stop LM Studio server: %v", err)
+			if err := controller.StopServer(); err != nil {
+				lmstudioLog.Error("Failed to stop LM Studio server", "err", err)
 			} else {
-				log.Println("LM Studio server stop command sent")
+				lmstudioLog.Info("LM Studio server stop command sent")
 				// Wait a bit for the server to stop and then update status
 				time.Sleep(2 * time.Second)
 				app.updateLMStudioStatus(client)
 			}
 		default:
-			log.Printf("Unknown LM Studio server command: %s", payload)
+			lmstudioLog.Warn("Unknown LM Studio server command", "payload", payload)
 		}
 		return true
 	}
 
+	// Handle the load/unload text inputs
+	if topic == basePrefix+"/command/lmstudio_load_model" {
+		app.handleLMStudioLoadModel(client, payload)
+		return true
+	}
+	if topic == basePrefix+"/command/lmstudio_unload_model" {
+		app.handleLMStudioUnloadModel(client, payload)
+		return true
+	}
+
+	// Handle chat requests
+	if topic == basePrefix+"/command/lmstudio_chat" {
+		app.handleLMStudioChat(client, payload)
+		return true
+	}
+	if topic == basePrefix+"/command/lmstudio_chat_cancel" {
+		app.handleLMStudioChatCancel(payload)
+		return true
+	}
+
+	// Handle model downloads
+	if topic == basePrefix+"/command/lmstudio_download" {
+		app.handleLMStudioDownload(client, payload)
+		return true
+	}
+	if topic == basePrefix+"/command/lmstudio_download_cancel" {
+		app.handleLMStudioDownloadCancel(payload)
+		return true
+	}
+
+	// Handle the model select
+	if topic == basePrefix+"/command/lmstudio_model_select" {
+		app.handleLMStudioModelSelect(client, payload)
+		return true
+	}
+
 	// Handle individual model switches (lmstudio_model_*)
 	if strings.HasPrefix(topic, basePrefix+"/command/lmstudio_model_") {
 		// Extract sanitized model ID from topic
 		sanitizedID := strings.TrimPrefix(topic, basePrefix+"/command/lmstudio_model_")
-		log.Printf("Received LM Studio model command: topic=%s, payload=%s, sanitizedID=%s", topic, payload, sanitizedID)
+		lmstudioLog.Debug("Received LM Studio model command", "topic", topic, "payload", payload, "sanitized_id", sanitizedID)
 
-		// Find the actual model ID from our model list
-		app.lmstudioMutex.RLock()
-		models := app.lmstudioAllModels
-		app.lmstudioMutex.RUnlock()
-
-		var actualModelID string
-		for _, model := range models {
-			if sanitizeModelID(model.ID) == sanitizedID {
-				actualModelID = model.ID
-				break
-			}
-		}
-
-		if actualModelID == "" {
-			log.Printf("Could not find model with sanitized ID: %s", sanitizedID)
+		// Find the actual model ID from the topic names of the listed models
+		actualModelID, ok := app.lmstudioTopics.model(sanitizedID)
+		if !ok {
+			lmstudioLog.Warn("Could not find model with sanitized ID", "sanitized_id", sanitizedID)
 			return true
 		}
 
-		log.Printf("Found model ID: %s for sanitized ID: %s", actualModelID, sanitizedID)
+		lmstudioLog.Debug("Found model ID for sanitized ID", "model", actualModelID, "sanitized_id", sanitizedID)
 
 		// Handle load/unload based on payload
 		if payload == "load" {
-			if err := macos.LoadLMStudioModel(actualModelID); err != nil {
-				log.Printf("Failed to load model %s: %v", actualModelID, err)
-			} else {
-				log.Printf("Model %s load command sent", actualModelID)
-				// Update status after a delay
-				go func() {
-					time.Sleep(5 * time.Second)
-					app.updateLMStudioStatus(client)
-				}()
-			}
+			app.loadLMStudioModel(client, actualModelID, llm.LoadOptions{})
 		} else if payload == "unload" {
-			if err := macos.UnloadLMStudioModel(actualModelID); err != nil {
-				log.Printf("Failed to unload model %s: %v", actualModelID, err)
-			} else {
-				log.Printf("Model %s unload command sent", actualModelID)
-				// Update status after a delay
-				go func() {
-					time.Sleep(2 * time.Second)
-					app.updateLMStudioStatus(client)
-				}()
-			}
+			app.unloadLMStudioModel(client, actualModelID)
 		} else {
-			log.Printf("Unknown payload '%s' for model %s (expected 'load' or 'unload')", payload, actualModelID)
+			lmstudioLog.Warn("Unknown payload for model (expected 'load' or 'unload')", "payload", payload, "model", actualModelID)
 		}
 		return true
 	}
@@ -1071,25 +1015,87 @@ func (app *Application) handleLMStudioCommand(client mqtt.Client, topic, payload
 	return false
 }
 
+// lmstudioLoadRequest is the payload of /command/lmstudio_load_model and
+// /command/lmstudio_unload_model: either a plain model ID or this JSON object
+type lmstudioLoadRequest struct {
+	Model         string  `json:"model"`
+	GPU           float64 `json:"gpu"`            // GPU offload ratio 0-1
+	ContextLength int     `json:"context_length"` // in tokens
+	TTL           int     `json:"ttl"`            // idle seconds before LM Studio unloads the model
+}
+
+// parseLMStudioLoadRequest parses a plain model ID or a JSON load request
+func parseLMStudioLoadRequest(payload string) (lmstudioLoadRequest, error) {
+	var req lmstudioLoadRequest
+	payload = strings.TrimSpace(payload)
+	if strings.HasPrefix(payload, "{") {
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return req, fmt.Errorf("invalid JSON: %w", err)
+		}
+		req.Model = strings.TrimSpace(req.Model)
+	} else {
+		req.Model = payload
+	}
+
+	if req.Model == "" {
+		return req, fmt.Errorf("model ID is required")
+	}
+	// Model IDs are passed to lms as arguments, don't let them look like flags
+	if strings.HasPrefix(req.Model, "-") {
+		return req, fmt.Errorf("invalid model ID %q", req.Model)
+	}
+	if req.GPU < 0 || req.GPU > 1 {
+		return req, fmt.Errorf("gpu must be between 0 and 1, got %v", req.GPU)
+	}
+	if req.ContextLength < 0 || req.TTL < 0 {
+		return req, fmt.Errorf("context_length and ttl must not be negative")
+	}
+	return req, nil
+}
+
+// handleLMStudioLoadModel loads a model by ID, optionally with load options
+func (app *Application) handleLMStudioLoadModel(client mqtt.Client, payload string) {
+	req, err := parseLMStudioLoadRequest(payload)
+	if err != nil {
+		lmstudioLog.Warn("Invalid LM Studio load request", "payload", payload, "err", err)
+		return
+	}
+
+	app.loadLMStudioModel(client, req.Model, llm.LoadOptions{GPU: req.GPU, ContextLength: req.ContextLength, TTL: req.TTL})
+}
+
+// handleLMStudioUnloadModel unloads a model by ID, or all models for "all"
+func (app *Application) handleLMStudioUnloadModel(client mqtt.Client, payload string) {
+	req, err := parseLMStudioLoadRequest(payload)
+	if err != nil {
+		lmstudioLog.Warn("Invalid LM Studio unload request", "payload", payload, "err", err)
+		return
+	}
+
+	if strings.EqualFold(req.Model, "all") {
+		app.unloadAllLMStudioModels(client)
+	} else {
+		app.unloadLMStudioModel(client, req.Model)
+	}
+}
+
 // updateLMStudioStatus updates the MQTT topics with current LM Studio status
 func (app *Application) updateLMStudioStatus(client mqtt.Client) {
-	if !app.config.LMStudioEnabled {
+	if app.llmServer == nil {
 		return
 	}
 
 	basePrefix := app.getTopicPrefix()
 
 	// Check if server is running
-	isRunning, err := macos.GetLMStudioServerStatus(app.config.LMStudioAPIURL)
+	isRunning, err := app.llmServer.ServerStatus()
 	if err != nil {
-		log.Printf("Error checking LM Studio server status: %v", err)
+		lmstudioLog.Error("Error checking LM Studio server status", "err", err)
 		return
 	}
 
-	app.lmstudioMutex.Lock()
-	app.lmstudioServerRunning = isRunning
-	oldModels := app.lmstudioAllModels
-	app.lmstudioMutex.Unlock()
+	state.Set(app.store, lmstudioServerKey, isRunning)
+	oldModels := state.Value(app.store, lmstudioModelsKey)
 
 	// Publish server status
 	serverStatus := "offline"
@@ -1101,19 +1107,23 @@ func (app *Application) updateLMStudioStatus(client mqtt.Client) {
 	if !isRunning {
 		// Server is not running, set all model switches to OFF
 		for _, model := range oldModels {
-			sanitizedID := sanitizeModelID(model.ID)
+			sanitizedID := app.lmstudioModelTopic(model.ID)
 			client.Publish(basePrefix+"/status/lmstudio_model_"+sanitizedID, 0, true, "OFF")
 		}
+		app.clearLMStudioModelLists(client)
+		app.publishLMStudioModelsMemory(client, nil)
 		return
 	}
 
 	// Get all models
-	models, err := macos.ListLMStudioModels(app.config.LMStudioAPIURL)
+	models, err := app.llmServer.ListModels()
 	if err != nil {
-		log.Printf("Error listing LM Studio models: %v", err)
+		lmstudioLog.Error("Error listing LM Studio models", "err", err)
 		return
 	}
 
+	app.unloadIdleLMStudioModels(client, models)
+
 	// Count loaded models
 	loadedCount := 0
 	for _, model := range models {
@@ -1122,9 +1132,8 @@ func (app *Application) updateLMStudioStatus(client mqtt.Client) {
 		}
 	}
 
-	app.lmstudioMutex.Lock()
-	app.lmstudioAllModels = models
-	app.lmstudioMutex.Unlock()
+	state.Set(app.store, lmstudioModelsKey, models)
+	app.updateLMStudioModelTopics(client, models)
 
 	// Check if model list has changed - if yes, republish Discovery
 	modelsChanged := len(models) != len(oldModels)
@@ -1138,24 +1147,90 @@ func (app *Application) updateLMStudioStatus(client mqtt.Client) {
 	}
 
 	if modelsChanged {
-		log.Printf("Model list changed, republishing Discovery messages")
+		lmstudioLog.Info("Model list changed, republishing Discovery messages")
 		app.publishLMStudioModelDiscovery(client)
 	}
 
 	// Publish state for each model
 	for _, model := range models {
-		sanitizedID := sanitizeModelID(model.ID)
+		sanitizedID := app.lmstudioModelTopic(model.ID)
 		state := "OFF"
 		if model.State == "loaded" {
 			state = "ON"
 		}
+		// A running load or unload shows its state until it's done, so the switch doesn't flip back and forth,
+		// and a failed one shows "error" until the next load or unload, or until the server reports another state
+		if op, ok := app.lmstudioOps.observe(model.ID, model.State); ok && op.State != "" {
+			state = op.State
+		}
 		client.Publish(basePrefix+"/status/lmstudio_model_"+sanitizedID, 0, true, state)
+		app.publishLMStudioModelAttributes(client, model)
 	}
 
 	// Publish model count
 	client.Publish(basePrefix+"/status/lmstudio_loaded_models_count", 0, false, strconv.Itoa(loadedCount))
 
-	log.Printf("LM Studio status updated: Server=%s, Loaded=%d, Total=%d", serverStatus, loadedCount, len(models))
+	loaded, available := llm.SplitModelsByState(models)
+	app.publishLMStudioModelList(client, "loaded_models", loaded)
+	app.publishLMStudioModelList(client, "available_models", available)
+	app.publishLMStudioModelsMemory(client, models)
+	app.publishLMStudioStats(client)
+	if app.config.LMStudioModelSelect {
+		app.publishLMStudioModelSelect(client, models)
+	}
+
+	lmstudioLog.Debug("LM Studio status updated", "server", serverStatus, "loaded", loadedCount, "total", len(models))
+}
+
+// maxSensorState is the longest state Home Assistant accepts for a sensor
+const maxSensorState = 255
+
+// lmstudioModelListAttributes is the JSON attributes payload of the model list sensors
+type lmstudioModelListAttributes struct {
+	Count  int         `json:"count"`
+	Models []llm.Model `json:"models"`
+}
+
+// publishLMStudioModelList publishes a model list sensor: a readable state on
+// /status/lmstudio_<name>_list and the model details on /status/lmstudio_<name>
+func (app *Application) publishLMStudioModelList(client mqtt.Client, name string, models []llm.Model) {
+	basePrefix := app.getTopicPrefix()
+
+	attributes, err := json.Marshal(lmstudioModelListAttributes{
+		Count:  len(models),
+		Models: append([]llm.Model{}, models...),
+	})
+	if err != nil {
+		lmstudioLog.Error("Failed to encode LM Studio model list", "list", name, "err", err)
+		return
+	}
+
+	client.Publish(basePrefix+"/status/lmstudio_"+name+"_list", 0, true, truncateState(llm.FormatModelList(models)))
+	client.Publish(basePrefix+"/status/lmstudio_"+name, 0, true, string(attributes))
+}
+
+// clearLMStudioModelLists removes the retained model lists while the server is offline.
+// The attributes topics get an empty list, Home Assistant rejects payloads that aren't JSON.
+func (app *Application) clearLMStudioModelLists(client mqtt.Client) {
+	basePrefix := app.getTopicPrefix()
+	empty, _ := json.Marshal(lmstudioModelListAttributes{Models: []llm.Model{}})
+	for _, name := range []string{"loaded_models", "available_models"} {
+		client.Publish(basePrefix+"/status/lmstudio_"+name+"_list", 0, true, "")
+		client.Publish(basePrefix+"/status/lmstudio_"+name, 0, true, string(empty))
+	}
+}
+
+// truncateState shortens s to fit in a Home Assistant sensor state
+func truncateState(s string) string {
+	if len(s) <= maxSensorState {
+		return s
+	}
+	const ellipsis = "…"
+	cut := maxSensorState - len(ellipsis)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + ellipsis
 }
 
 // sanitizeModelID converts a model ID to a valid Home Assistant entity ID
@@ -1178,14 +1253,15 @@ func sanitizeModelID(id string) string {
 	return sanitized
 }
 
-
 func (app *Application) updateVolume(client mqtt.Client) {
-	token := client.Publish(app.getTopicPrefix()+"/status/volume", 0, false, strconv.Itoa(macos.GetVolume()))
+	volume := app.backend.GetVolume()
+	token := client.Publish(app.getTopicPrefix()+"/status/volume", 0, false, strconv.Itoa(volume))
 	token.Wait()
+	app.publishMediaPlayerVolume(client, volume)
 }
 
 func (app *Application) updateMute(client mqtt.Client) {
-	token := client.Publish(app.getTopicPrefix()+"/status/mute", 0, false, strconv.FormatBool(macos.GetMuteStatus()))
+	token := client.Publish(app.getTopicPrefix()+"/status/mute", 0, false, strconv.FormatBool(app.backend.GetMuteStatus()))
 	token.Wait()
 }
 
@@ -1195,63 +1271,20 @@ type CPUUsage = macos.CPUUsage
 type MemoryUsage = macos.MemoryUsage
 type UptimeInfo = macos.UptimeInfo
 
-func (app *Application) getCPUUsage() (*CPUUsage, error) {
-	cpu := sigar.Cpu{}
-	if err := cpu.Get(); err != nil {
-		return nil, fmt.Errorf("failed to get CPU stats: %w", err)
-	}
-
-	app.cpuMutex.Lock()
-	defer app.cpuMutex.Unlock()
-
-	// Calculate the delta since last measurement
-	userDelta := cpu.User - app.lastCPU.User
-	sysDelta := cpu.Sys - app.lastCPU.Sys
-	idleDelta := cpu.Idle - app.lastCPU.Idle
-	waitDelta := cpu.Wait - app.lastCPU.Wait
-	niceDelta := cpu.Nice - app.lastCPU.Nice
-	irqDelta := cpu.Irq - app.lastCPU.Irq
-	softIrqDelta := cpu.SoftIrq - app.lastCPU.SoftIrq
-	stolenDelta := cpu.Stolen - app.lastCPU.Stolen
-
-	// Calculate total time delta
-	totalDelta := userDelta + sysDelta + idleDelta + waitDelta + niceDelta + irqDelta + softIrqDelta + stolenDelta
-
-	// Store current CPU stats for next calculation
-	app.lastCPU = cpu
-
-	// If this is the first measurement or total is zero, return 0% usage
-	if totalDelta == 0 {
-		return &CPUUsage{
-			UsedPercent: 0,
-			FreePercent: 100,
-		}, nil
-	}
-
-	// Calculate idle and used percentages
-	idlePercent := float64(idleDelta) / float64(totalDelta) * 100
-	usedPercent := 100 - idlePercent
-
-	return &CPUUsage{
-		UsedPercent: usedPercent,
-		FreePercent: idlePercent,
-	}, nil
-}
-
 func (app *Application) updateBattery(client mqtt.Client) {
-	token := client.Publish(app.getTopicPrefix()+"/status/battery", 0, false, macos.GetBatteryChargePercent())
+	token := client.Publish(app.getTopicPrefix()+"/status/battery", 0, false, app.backend.GetBatteryChargePercent())
 	token.Wait()
 }
 
 func (app *Application) updateCaffeinateStatus(client mqtt.Client) {
-	token := client.Publish(app.getTopicPrefix()+"/status/caffeinate", 0, false, strconv.FormatBool(macos.GetCaffeinateStatus()))
+	token := client.Publish(app.getTopicPrefix()+"/status/caffeinate", 0, false, strconv.FormatBool(app.backend.GetCaffeinateStatus()))
 	token.Wait()
 }
 
 func (app *Application) updateDiskUsage(client mqtt.Client) {
-	diskUsage, err := macos.GetDiskUsage()
+	diskUsage, err := app.backend.GetDiskUsage()
 	if err != nil {
-		log.Printf("Failed to get disk usage: %v", err)
+		systemLog.Error("Failed to get disk usage", "err", err)
 		return
 	}
 
@@ -1264,9 +1297,9 @@ func (app *Application) updateDiskUsage(client mqtt.Client) {
 }
 
 func (app *Application) updateCPUUsage(client mqtt.Client) {
-	cpuUsage, err := app.getCPUUsage()
+	cpuUsage, err := app.backend.GetCPUUsage()
 	if err != nil {
-		log.Printf("Failed to get CPU usage: %v", err)
+		systemLog.Error("Failed to get CPU usage", "err", err)
 		return
 	}
 
@@ -1276,9 +1309,9 @@ func (app *Application) updateCPUUsage(client mqtt.Client) {
 }
 
 func (app *Application) updateMemoryUsage(client mqtt.Client) {
-	memUsage, err := macos.GetMemoryUsage()
+	memUsage, err := app.backend.GetMemoryUsage()
 	if err != nil {
-		log.Printf("Failed to get memory usage: %v", err)
+		systemLog.Error("Failed to get memory usage", "err", err)
 		return
 	}
 
@@ -1291,9 +1324,9 @@ func (app *Application) updateMemoryUsage(client mqtt.Client) {
 }
 
 func (app *Application) updateUptime(client mqtt.Client) {
-	uptime, err := macos.GetSystemUptime()
+	uptime, err := app.backend.GetSystemUptime()
 	if err != nil {
-		log.Printf("Failed to get uptime: %v", err)
+		systemLog.Error("Failed to get uptime", "err", err)
 		return
 	}
 
@@ -1303,63 +1336,55 @@ func (app *Application) updateUptime(client mqtt.Client) {
 }
 
 func (app *Application) updateTemperatures(client mqtt.Client) {
-	log.Printf("updateTemperatures called")
-	temps, err := macos.GetTemperatures()
+	temps, err := app.backend.GetTemperatures()
 	if err != nil {
-		log.Printf("Failed to get temperatures: %v", err)
+		systemLog.Error("Failed to get temperatures", "err", err)
 		return
 	}
 
-	log.Printf("Got temperatures - CPU: %.1f°C, GPU: %.1f°C", temps.CPU, temps.GPU)
+	systemLog.Debug("Got temperatures", "cpu", temps.CPU, "gpu", temps.GPU)
 
 	// Only publish if we have actual temperature readings (> 0)
 	if temps.CPU > 0 {
 		client.Publish(app.getTopicPrefix()+"/status/temperature/cpu", 0, false, fmt.Sprintf("%.1f", temps.CPU))
-		log.Printf("Published CPU temperature: %.1f to %s/status/temperature/cpu", temps.CPU, app.getTopicPrefix())
 	} else {
-		log.Printf("CPU temperature not available (value: %.1f), skipping publish", temps.CPU)
+		systemLog.Debug("CPU temperature not available, skipping publish", "value", temps.CPU)
 	}
 
 	if temps.GPU > 0 {
 		client.Publish(app.getTopicPrefix()+"/status/temperature/gpu", 0, false, fmt.Sprintf("%.1f", temps.GPU))
-		log.Printf("Published GPU temperature: %.1f to %s/status/temperature/gpu", temps.GPU, app.getTopicPrefix())
 	}
 }
 
 func (app *Application) updateNetworkStats(client mqtt.Client) {
-	log.Printf("updateNetworkStats called")
-	now := time.Now()
+	lastStats, lastTime, ok := state.Get(app.store, networkStatsKey)
 	var interval time.Duration
-	if !app.lastNetworkTime.IsZero() {
-		interval = now.Sub(app.lastNetworkTime)
+	if ok {
+		interval = time.Since(lastTime)
 	}
-	log.Printf("Network stats interval: %v", interval)
 
-	stats, err := macos.GetNetworkStats(app.lastNetworkStats, interval)
+	stats, err := app.backend.GetNetworkStats(lastStats, interval)
 	if err != nil {
-		log.Printf("Failed to get network stats: %v", err)
+		systemLog.Error("Failed to get network stats", "err", err)
 		return
 	}
 
-	log.Printf("Got network stats - Recv: %d bytes, Sent: %d bytes, Down: %.2f MB/s, Up: %.2f MB/s",
-		stats.BytesRecv, stats.BytesSent, stats.DownloadMBps, stats.UploadMBps)
+	systemLog.Debug("Got network stats", "interval", interval, "bytes_recv", stats.BytesRecv, "bytes_sent", stats.BytesSent, "download_mbps", stats.DownloadMBps, "upload_mbps", stats.UploadMBps)
 
 	// Update stored values
-	app.lastNetworkStats = stats
-	app.lastNetworkTime = now
+	state.Set(app.store, networkStatsKey, stats)
 
 	// Publish network metrics
 	client.Publish(app.getTopicPrefix()+"/status/network/bytes_received", 0, false, fmt.Sprintf("%d", stats.BytesRecv))
 	client.Publish(app.getTopicPrefix()+"/status/network/bytes_sent", 0, false, fmt.Sprintf("%d", stats.BytesSent))
 	client.Publish(app.getTopicPrefix()+"/status/network/download_speed", 0, false, fmt.Sprintf("%.2f", stats.DownloadMBps))
 	client.Publish(app.getTopicPrefix()+"/status/network/upload_speed", 0, false, fmt.Sprintf("%.2f", stats.UploadMBps))
-	log.Printf("Published network stats to %s/status/network/*", app.getTopicPrefix())
 }
 
 func (app *Application) updateMediaDevices(client mqtt.Client) {
-	isMicOn, isCameraOn, err := macos.GetMediaDevicesState()
+	isMicOn, isCameraOn, err := app.backend.GetMediaDevicesState()
 	if err != nil {
-		log.Printf("Failed to get media devices state: %v", err)
+		systemLog.Warn("Failed to get media devices state", "err", err)
 		// Publish "unknown" state on error
 		client.Publish(app.getTopicPrefix()+"/status/microphone", 0, false, "OFF")
 		client.Publish(app.getTopicPrefix()+"/status/camera", 0, false, "OFF")
@@ -1381,9 +1406,9 @@ func (app *Application) updateMediaDevices(client mqtt.Client) {
 }
 
 func (app *Application) updatePublicIP(client mqtt.Client) {
-	publicIP, err := macos.GetPublicIP()
+	publicIP, err := app.backend.GetPublicIP()
 	if err != nil {
-		log.Printf("Failed to get public IP: %v", err)
+		systemLog.Warn("Failed to get public IP", "err", err)
 		// Publish empty string on error
 		client.Publish(app.getTopicPrefix()+"/status/public_ip", 0, false, "unavailable")
 		return
@@ -1727,34 +1752,34 @@ func (app *Application) setDevice(client mqtt.Client) {
 	}
 
 	components := map[string]interface{}{
-		"sleep":               sleep,
-		"shutdown":            shutdown,
-		"volume":              volume,
-		"mute":                mute,
-		"displaywake":         displaywake,
-		"displaysleep":        displaysleep,
-		"screensaver":         screensaver,
-		"battery":             battery,
-		"keepawake":           keepawake,
-		"disk_total":          diskTotal,
-		"disk_used":           diskUsed,
-		"disk_free":           diskFree,
-		"disk_used_percent":   diskUsedPercent,
-		"disk_free_percent":   diskFreePercent,
-		"cpu_used_percent":    cpuUsedPercent,
-		"cpu_free_percent":    cpuFreePercent,
-		"memory_total":        memoryTotal,
-		"memory_used":         memoryUsed,
-		"memory_free":         memoryFree,
-		"memory_used_percent": memoryUsedPercent,
-		"memory_free_percent": memoryFreePercent,
-		"uptime_seconds":      uptimeSeconds,
-		"uptime_human":        uptimeHuman,
-		"microphone":          microphone,
-		"camera":              camera,
-		"public_ip":           publicIP,
-		"cpu_temperature":     cpuTemp,
-		"gpu_temperature":     gpuTemp,
+		"sleep":                  sleep,
+		"shutdown":               shutdown,
+		"volume":                 volume,
+		"mute":                   mute,
+		"displaywake":            displaywake,
+		"displaysleep":           displaysleep,
+		"screensaver":            screensaver,
+		"battery":                battery,
+		"keepawake":              keepawake,
+		"disk_total":             diskTotal,
+		"disk_used":              diskUsed,
+		"disk_free":              diskFree,
+		"disk_used_percent":      diskUsedPercent,
+		"disk_free_percent":      diskFreePercent,
+		"cpu_used_percent":       cpuUsedPercent,
+		"cpu_free_percent":       cpuFreePercent,
+		"memory_total":           memoryTotal,
+		"memory_used":            memoryUsed,
+		"memory_free":            memoryFree,
+		"memory_used_percent":    memoryUsedPercent,
+		"memory_free_percent":    memoryFreePercent,
+		"uptime_seconds":         uptimeSeconds,
+		"uptime_human":           uptimeHuman,
+		"microphone":             microphone,
+		"camera":                 camera,
+		"public_ip":              publicIP,
+		"cpu_temperature":        cpuTemp,
+		"gpu_temperature":        gpuTemp,
 		"network_bytes_received": networkBytesRecv,
 		"network_bytes_sent":     networkBytesSent,
 		"network_download_speed": networkDownloadSpeed,
@@ -1788,7 +1813,7 @@ func (app *Application) setDevice(client mqtt.Client) {
 	components["idle_time_seconds"] = idleTime
 
 	// Add media control components if Media Control is available
-	if macos.IsMediaControlAvailable() {
+	if app.backend.IsMediaControlAvailable() {
 		playPause := map[string]interface{}{
 			"p":             "button",
 			"name":          "Play/Pause",
@@ -1807,14 +1832,45 @@ func (app *Application) setDevice(client mqtt.Client) {
 			"icon":                  "mdi:music",
 		}
 
+		artwork := map[string]interface{}{
+			"p":            "image",
+			"name":         "Now Playing Artwork",
+			"unique_id":    app.hostname + "_media_artwork",
+			"image_topic":  app.getTopicPrefix() + "/status/media_artwork",
+			"content_type": "image/jpeg",
+		}
+
 		components["playpause"] = playPause
 		components["now_playing"] = nowPlaying
+		components["media_artwork"] = artwork
+		components["media_stream"] = map[string]interface{}{
+			"p":                     "binary_sensor",
+			"name":                  "Media Stream",
+			"unique_id":             app.hostname + "_media_stream",
+			"state_topic":           app.getTopicPrefix() + "/status/media_stream",
+			"json_attributes_topic": app.getTopicPrefix() + "/status/media_stream/attributes",
+			"payload_on":            "online",
+			"payload_off":           "offline",
+			"device_class":          "connectivity",
+			"entity_category":       "diagnostic",
+		}
+		maps.Copy(components, app.mediaControlComponents())
+		if app.config.MediaHistory || app.config.ListenBrainzToken != "" {
+			components["media_last_track"] = map[string]interface{}{
+				"p":           "event",
+				"name":        "Last Track",
+				"unique_id":   app.hostname + "_media_last_track",
+				"state_topic": app.getTopicPrefix() + "/status/media_last_track",
+				"event_types": []string{"played"},
+				"icon":        "mdi:history",
+			}
+		}
 	}
 
 	// Note: Media player will be published as separate standard MQTT autodiscovery message
 
 	// Add display brightness controls for each display
-	for _, display := range app.displays {
+	for _, display := range state.Value(app.store, displaysKey) {
 		displayBrightness := map[string]interface{}{
 			"p":             "number",
 			"name":          display.Name + " Brightness",
@@ -1830,27 +1886,41 @@ func (app *Application) setDevice(client mqtt.Client) {
 		components["display_"+display.DisplayID+"_brightness"] = displayBrightness
 	}
 
-	// Add LM Studio control components if enabled
-	if app.config.LMStudioEnabled && macos.IsLMStudioCLIAvailable() {
-		// Server control switch
-		lmstudioServer := map[string]interface{}{
-			"p":             "switch",
-			"name":          "LM Studio Server",
-			"unique_id":     app.hostname + "_lmstudio_server",
-			"command_topic": app.getTopicPrefix() + "/command/lmstudio_server",
-			"state_topic":   app.getTopicPrefix() + "/status/lmstudio_server",
-			"payload_on":    "start",
-			"payload_off":   "stop",
-			"state_on":      "online",
-			"state_off":     "offline",
-			"icon":          "mdi:server",
+	// Add LLM server (LM Studio, Ollama, llama.cpp) control components if enabled
+	if app.llmServer != nil {
+		// Server control switch, or just its state if mac2mqtt can't start and stop it
+		if _, ok := app.llmServer.(llm.ServerController); ok {
+			lmstudioServer := map[string]interface{}{
+				"p":             "switch",
+				"name":          app.llmServer.Name() + " Server",
+				"unique_id":     app.hostname + "_lmstudio_server",
+				"command_topic": app.getTopicPrefix() + "/command/lmstudio_server",
+				"state_topic":   app.getTopicPrefix() + "/status/lmstudio_server",
+				"payload_on":    "start",
+				"payload_off":   "stop",
+				"state_on":      "online",
+				"state_off":     "offline",
+				"icon":          "mdi:server",
+			}
+			components["lmstudio_server"] = lmstudioServer
+		} else {
+			lmstudioServer := map[string]interface{}{
+				"p":            "binary_sensor",
+				"name":         app.llmServer.Name() + " Server",
+				"unique_id":    app.hostname + "_lmstudio_server_state",
+				"state_topic":  app.getTopicPrefix() + "/status/lmstudio_server",
+				"payload_on":   "online",
+				"payload_off":  "offline",
+				"device_class": "running",
+				"icon":         "mdi:server",
+			}
+			components["lmstudio_server_state"] = lmstudioServer
 		}
-		components["lmstudio_server"] = lmstudioServer
 
 		// Loaded models sensor
 		lmstudioLoadedModels := map[string]interface{}{
 			"p":                     "sensor",
-			"name":                  "LM Studio Loaded Models",
+			"name":                  app.llmServer.Name() + " Loaded Models",
 			"unique_id":             app.hostname + "_lmstudio_loaded_models_list",
 			"state_topic":           app.getTopicPrefix() + "/status/lmstudio_loaded_models_list",
 			"json_attributes_topic": app.getTopicPrefix() + "/status/lmstudio_loaded_models",
@@ -1861,7 +1931,7 @@ func (app *Application) setDevice(client mqtt.Client) {
 		// Available models sensor
 		lmstudioAvailableModels := map[string]interface{}{
 			"p":                     "sensor",
-			"name":                  "LM Studio Available Models",
+			"name":                  app.llmServer.Name() + " Available Models",
 			"unique_id":             app.hostname + "_lmstudio_available_models_list",
 			"state_topic":           app.getTopicPrefix() + "/status/lmstudio_available_models_list",
 			"json_attributes_topic": app.getTopicPrefix() + "/status/lmstudio_available_models",
@@ -1872,7 +1942,7 @@ func (app *Application) setDevice(client mqtt.Client) {
 		// Loaded models count
 		lmstudioLoadedCount := map[string]interface{}{
 			"p":                   "sensor",
-			"name":                "LM Studio Loaded Models Count",
+			"name":                app.llmServer.Name() + " Loaded Models Count",
 			"unique_id":           app.hostname + "_lmstudio_loaded_models_count",
 			"state_topic":         app.getTopicPrefix() + "/status/lmstudio_loaded_models_count",
 			"unit_of_measurement": "models",
@@ -1884,7 +1954,7 @@ func (app *Application) setDevice(client mqtt.Client) {
 		// Load model text input (for manual model ID entry)
 		lmstudioLoadModel := map[string]interface{}{
 			"p":             "text",
-			"name":          "LM Studio Load Model",
+			"name":          app.llmServer.Name() + " Load Model",
 			"unique_id":     app.hostname + "_lmstudio_load_model",
 			"command_topic": app.getTopicPrefix() + "/command/lmstudio_load_model",
 			"icon":          "mdi:upload",
@@ -1895,13 +1965,81 @@ func (app *Application) setDevice(client mqtt.Client) {
 		// Unload model text input (for manual model ID entry or "all")
 		lmstudioUnloadModel := map[string]interface{}{
 			"p":             "text",
-			"name":          "LM Studio Unload Model",
+			"name":          app.llmServer.Name() + " Unload Model",
 			"unique_id":     app.hostname + "_lmstudio_unload_model",
 			"command_topic": app.getTopicPrefix() + "/command/lmstudio_unload_model",
 			"icon":          "mdi:download",
 			"mode":          "text",
 		}
 		components["lmstudio_unload_model"] = lmstudioUnloadModel
+
+		// Resource and inference statistics sensors
+		components["lmstudio_models_memory"] = map[string]interface{}{
+			"p":                   "sensor",
+			"name":                app.llmServer.Name() + " Models Memory",
+			"unique_id":           app.hostname + "_lmstudio_models_memory",
+			"state_topic":         app.getTopicPrefix() + "/status/lmstudio_stats/models_memory",
+			"unit_of_measurement": "B",
+			"device_class":        "data_size",
+			"state_class":         "measurement",
+			"icon":                "mdi:memory",
+		}
+		components["lmstudio_requests"] = map[string]interface{}{
+			"p":           "sensor",
+			"name":        app.llmServer.Name() + " Requests",
+			"unique_id":   app.hostname + "_lmstudio_requests",
+			"state_topic": app.getTopicPrefix() + "/status/lmstudio_stats/requests",
+			"state_class": "total_increasing",
+			"icon":        "mdi:message-processing",
+		}
+		components["lmstudio_tokens_per_second"] = map[string]interface{}{
+			"p":                   "sensor",
+			"name":                app.llmServer.Name() + " Tokens per Second",
+			"unique_id":           app.hostname + "_lmstudio_tokens_per_second",
+			"state_topic":         app.getTopicPrefix() + "/status/lmstudio_stats/tokens_per_second",
+			"unit_of_measurement": "tokens/s",
+			"state_class":         "measurement",
+			"icon":                "mdi:speedometer",
+		}
+		components["lmstudio_time_to_first_token"] = map[string]interface{}{
+			"p":                   "sensor",
+			"name":                app.llmServer.Name() + " Time to First Token",
+			"unique_id":           app.hostname + "_lmstudio_time_to_first_token",
+			"state_topic":         app.getTopicPrefix() + "/status/lmstudio_stats/time_to_first_token",
+			"unit_of_measurement": "ms",
+			"device_class":        "duration",
+			"state_class":         "measurement",
+			"icon":                "mdi:timer-outline",
+		}
+
+		// Model download controls, if the server can download models
+		if _, ok := app.llmServer.(llm.Downloader); ok {
+			components["lmstudio_download"] = map[string]interface{}{
+				"p":             "text",
+				"name":          app.llmServer.Name() + " Download Model",
+				"unique_id":     app.hostname + "_lmstudio_download",
+				"command_topic": app.getTopicPrefix() + "/command/lmstudio_download",
+				"icon":          "mdi:cloud-download",
+				"mode":          "text",
+			}
+			components["lmstudio_download_status"] = map[string]interface{}{
+				"p":                     "sensor",
+				"name":                  app.llmServer.Name() + " Download",
+				"unique_id":             app.hostname + "_lmstudio_download_status",
+				"state_topic":           app.getTopicPrefix() + "/status/lmstudio_download",
+				"value_template":        "{{ value_json.state }}",
+				"json_attributes_topic": app.getTopicPrefix() + "/status/lmstudio_download",
+				"icon":                  "mdi:progress-download",
+			}
+			components["lmstudio_download_cancel"] = map[string]interface{}{
+				"p":             "button",
+				"name":          app.llmServer.Name() + " Cancel Downloads",
+				"unique_id":     app.hostname + "_lmstudio_download_cancel",
+				"command_topic": app.getTopicPrefix() + "/command/lmstudio_download_cancel",
+				"payload_press": "all",
+				"icon":          "mdi:cancel",
+			}
+		}
 	}
 
 	origin := map[string]interface{}{
@@ -1909,10 +2047,10 @@ func (app *Application) setDevice(client mqtt.Client) {
 	}
 
 	device := map[string]interface{}{
-		"ids":  macos.GetSerialnumber(),
+		"ids":  app.backend.SerialNumber(),
 		"name": app.hostname,
 		"mf":   "Apple",
-		"mdl":  macos.GetModel(),
+		"mdl":  app.backend.Model(),
 	}
 
 	object := map[string]interface{}{
@@ -1925,18 +2063,19 @@ func (app *Application) setDevice(client mqtt.Client) {
 	objectJSON, _ := json.Marshal(object)
 
 	discoveryTopic := app.config.DiscoveryPrefix + "/device" + "/" + app.hostname + "/config"
-	log.Printf("Publishing MQTT Discovery to topic: %s", discoveryTopic)
-	log.Printf("Discovery payload includes %d components", len(components))
+	mqttLog.Info("Publishing MQTT Discovery", "topic", discoveryTopic, "components", len(components))
+	mqttLog.Debug("Discovery payload", "payload", string(objectJSON))
 
 	token := client.Publish(discoveryTopic, 0, true, objectJSON)
 	token.Wait()
 
 	// Publish separate LM Studio entities for better Home Assistant compatibility
-	if app.config.LMStudioEnabled && macos.IsLMStudioCLIAvailable() {
+	if app.llmServer != nil {
 		app.publishLMStudioDiscovery(client, device, origin)
 	}
 
-	// Note: Media player functionality replaced with play/pause button and now playing sensor
+	// Media player entity for the mqtt_media_player integration
+	app.publishMediaPlayerDiscovery(client, device, origin)
 }
 
 // publishLMStudioDiscovery publishes separate MQTT Discovery messages for LM Studio entities
@@ -1944,40 +2083,59 @@ func (app *Application) publishLMStudioDiscovery(client mqtt.Client, device map[
 	basePrefix := app.getTopicPrefix()
 	discoveryPrefix := app.config.DiscoveryPrefix
 
-	// Server control switch
-	serverConfig := map[string]interface{}{
-		"name":              "LM Studio Server",
-		"unique_id":         app.hostname + "_lmstudio_server",
-		"command_topic":     basePrefix + "/command/lmstudio_server",
-		"state_topic":       basePrefix + "/status/lmstudio_server",
-		"payload_on":        "start",
-		"payload_off":       "stop",
-		"state_on":          "online",
-		"state_off":         "offline",
-		"icon":              "mdi:server",
-		"device":            device,
-		"origin":            origin,
-		"availability_topic": basePrefix + "/status/alive",
-	}
-	serverJSON, _ := json.Marshal(serverConfig)
-	client.Publish(discoveryPrefix+"/switch/"+app.hostname+"/lmstudio_server/config", 0, true, serverJSON)
+	// Server control switch, or a running sensor for servers mac2mqtt can't start and stop
+	if _, ok := app.llmServer.(llm.ServerController); ok {
+		serverConfig := map[string]interface{}{
+			"name":               app.llmServer.Name() + " Server",
+			"unique_id":          app.hostname + "_lmstudio_server",
+			"command_topic":      basePrefix + "/command/lmstudio_server",
+			"state_topic":        basePrefix + "/status/lmstudio_server",
+			"payload_on":         "start",
+			"payload_off":        "stop",
+			"state_on":           "online",
+			"state_off":          "offline",
+			"icon":               "mdi:server",
+			"device":             device,
+			"origin":             origin,
+			"availability_topic": basePrefix + "/status/alive",
+		}
+		serverJSON, _ := json.Marshal(serverConfig)
+		client.Publish(discoveryPrefix+"/switch/"+app.hostname+"/lmstudio_server/config", 0, true, serverJSON)
+		client.Publish(discoveryPrefix+"/binary_sensor/"+app.hostname+"/lmstudio_server_state/config", 0, true, "")
+	} else {
+		serverConfig := map[string]interface{}{
+			"name":               app.llmServer.Name() + " Server",
+			"unique_id":          app.hostname + "_lmstudio_server_state",
+			"state_topic":        basePrefix + "/status/lmstudio_server",
+			"payload_on":         "online",
+			"payload_off":        "offline",
+			"device_class":       "running",
+			"icon":               "mdi:server",
+			"device":             device,
+			"origin":             origin,
+			"availability_topic": basePrefix + "/status/alive",
+		}
+		serverJSON, _ := json.Marshal(serverConfig)
+		client.Publish(discoveryPrefix+"/binary_sensor/"+app.hostname+"/lmstudio_server_state/config", 0, true, serverJSON)
+		client.Publish(discoveryPrefix+"/switch/"+app.hostname+"/lmstudio_server/config", 0, true, "")
+	}
 
 	// Loaded models count sensor
 	loadedCountConfig := map[string]interface{}{
-		"name":                  "LM Studio Loaded Models Count",
-		"unique_id":             app.hostname + "_lmstudio_loaded_models_count",
-		"state_topic":           basePrefix + "/status/lmstudio_loaded_models_count",
-		"unit_of_measurement":   "models",
-		"state_class":           "measurement",
-		"icon":                  "mdi:counter",
-		"device":                device,
-		"origin":                origin,
-		"availability_topic":    basePrefix + "/status/alive",
+		"name":                app.llmServer.Name() + " Loaded Models Count",
+		"unique_id":           app.hostname + "_lmstudio_loaded_models_count",
+		"state_topic":         basePrefix + "/status/lmstudio_loaded_models_count",
+		"unit_of_measurement": "models",
+		"state_class":         "measurement",
+		"icon":                "mdi:counter",
+		"device":              device,
+		"origin":              origin,
+		"availability_topic":  basePrefix + "/status/alive",
 	}
 	loadedCountJSON, _ := json.Marshal(loadedCountConfig)
 	client.Publish(discoveryPrefix+"/sensor/"+app.hostname+"/lmstudio_loaded_models_count/config", 0, true, loadedCountJSON)
 
-	log.Printf("Published %d LM Studio base Discovery messages", 2)
+	lmstudioLog.Debug("Published LM Studio base Discovery messages", "count", 2)
 
 	// Publish Discovery for all models
 	app.publishLMStudioModelDiscovery(client)
@@ -1985,7 +2143,7 @@ func (app *Application) publishLMStudioDiscovery(client mqtt.Client, device map[
 
 // publishLMStudioModelDiscovery publishes Discovery messages for individual model switches
 func (app *Application) publishLMStudioModelDiscovery(client mqtt.Client) {
-	if !app.config.LMStudioEnabled {
+	if app.llmServer == nil {
 		return
 	}
 
@@ -2005,12 +2163,16 @@ func (app *Application) publishLMStudioModelDiscovery(client mqtt.Client) {
 		"sw":   "1.0.0",
 	}
 
-	app.lmstudioMutex.RLock()
-	models := app.lmstudioAllModels
-	app.lmstudioMutex.RUnlock()
+	models := state.Value(app.store, lmstudioModelsKey)
 
 	for _, model := range models {
-		sanitizedID := sanitizeModelID(model.ID)
+		sanitizedID := app.lmstudioModelTopic(model.ID)
+		switchTopic := discoveryPrefix + "/switch/" + app.hostname + "/lmstudio_model_" + sanitizedID + "/config"
+		if !app.config.modelSwitchesEnabled() {
+			// Removes the switch if it was published before the switches were turned off
+			client.Publish(switchTopic, 0, true, "")
+			continue
+		}
 
 		// Create a friendly name from the model ID
 		modelName := model.ID
@@ -2019,33 +2181,37 @@ func (app *Application) publishLMStudioModelDiscovery(client mqtt.Client) {
 		}
 
 		modelConfig := map[string]interface{}{
-			"name":               modelName,
-			"unique_id":          app.hostname + "_lmstudio_model_" + sanitizedID,
-			"command_topic":      basePrefix + "/command/lmstudio_model_" + sanitizedID,
-			"state_topic":        basePrefix + "/status/lmstudio_model_" + sanitizedID,
-			"payload_on":         "load",
-			"payload_off":        "unload",
-			"state_on":           "ON",
-			"state_off":          "OFF",
-			"icon":               "mdi:brain",
-			"device":             device,
-			"origin":             origin,
-			"availability_topic": basePrefix + "/status/alive",
+			"name":                  modelName,
+			"unique_id":             app.hostname + "_lmstudio_model_" + sanitizedID,
+			"command_topic":         basePrefix + "/command/lmstudio_model_" + sanitizedID,
+			"state_topic":           basePrefix + "/status/lmstudio_model_" + sanitizedID,
+			"value_template":        "{{ 'ON' if value in ('ON', 'loading') else 'OFF' }}",
+			"json_attributes_topic": basePrefix + "/status/lmstudio_model_" + sanitizedID + "/attributes",
+			"payload_on":            "load",
+			"payload_off":           "unload",
+			"state_on":              "ON",
+			"state_off":             "OFF",
+			"icon":                  "mdi:brain",
+			"device":                device,
+			"origin":                origin,
+			"availability_topic":    basePrefix + "/status/alive",
 		}
-		modelJSON, _ := json.Marshal(modelConfig)
 		// Use object_id to ensure the entity ID includes lmstudio_model_ prefix
 		modelConfig["object_id"] = "lmstudio_model_" + sanitizedID
-		modelJSON, _ = json.Marshal(modelConfig)
-		client.Publish(discoveryPrefix+"/switch/"+app.hostname+"/lmstudio_model_"+sanitizedID+"/config", 0, true, modelJSON)
+		modelJSON, _ := json.Marshal(modelConfig)
+		client.Publish(switchTopic, 0, true, modelJSON)
 	}
 
-	log.Printf("Published Discovery for %d LM Studio model switches", len(models))
+	app.publishLMStudioModelSelectDiscovery(client, device, origin)
+
+	lmstudioLog.Info("Published Discovery for LM Studio models", "count", len(models),
+		"switches", app.config.modelSwitchesEnabled(), "select", app.config.LMStudioModelSelect)
 }
 
 // handleOfflineMode manages application behavior when MQTT broker is unreachable
 func (app *Application) handleOfflineMode() {
-	log.Println("Operating in offline mode - MQTT broker not reachable")
-	log.Println("Application will continue monitoring system state and attempt to reconnect periodically")
+	mqttLog.Warn("Operating in offline mode - MQTT broker not reachable")
+	mqttLog.Info("Application will continue monitoring system state and attempt to reconnect periodically")
 
 	// Continue basic system monitoring even when offline
 	// This ensures the application doesn't crash and can recover when network returns
@@ -2053,60 +2219,68 @@ func (app *Application) handleOfflineMode() {
 
 // Run starts the application and runs the main loop
 func (app *Application) Run() error {
-	log.Println("=== MAC2MQTT STARTING ===")
-	log.Printf("Working directory: %s", macos.GetWorkingDirectory())
-	log.Printf("Hostname set to: %s", app.hostname)
-	log.Printf("Discovery Prefix: %s", app.config.DiscoveryPrefix)
-	log.Printf("MQTT Broker: %s:%s", app.config.IP, app.config.Port)
-	log.Printf("MQTT Topic: %s", app.topic)
+	systemLog.Info("=== MAC2MQTT STARTING ===")
+	systemLog.Info("Working directory", "path", macos.GetWorkingDirectory())
+	systemLog.Info("Hostname set", "hostname", app.hostname)
+	systemLog.Info("Discovery prefix", "prefix", app.config.DiscoveryPrefix)
+	systemLog.Info("MQTT broker", "host", app.config.IP, "port", app.config.Port)
+	systemLog.Info("MQTT topic", "topic", app.topic)
 
 	// Initialize displays before MQTT connection
-	log.Println("=== DISCOVERING DISPLAYS ===")
-	if len(app.displays) > 0 {
-		log.Printf("Found %d display(s):", len(app.displays))
-		for _, display := range app.displays {
-			log.Printf("  - %s (ID: %s)", display.Name, display.DisplayID)
+	displayLog.Info("=== DISCOVERING DISPLAYS ===")
+	if displays := state.Value(app.store, displaysKey); len(displays) > 0 {
+		displayLog.Info("Found displays", "count", len(displays))
+		for _, display := range displays {
+			displayLog.Info("Display", "name", display.Name, "id", display.DisplayID)
 		}
 	} else {
-		log.Println("No displays found or BetterDisplay CLI not available")
+		displayLog.Info("No displays found or BetterDisplay CLI not available")
 	}
-	log.Println("=== DISPLAY DISCOVERY COMPLETE ===")
+	displayLog.Info("=== DISPLAY DISCOVERY COMPLETE ===")
 
 	// Check Media Control availability
-	log.Println("=== CHECKING MEDIA CONTROL ===")
-	if macos.IsMediaControlAvailable() {
-		log.Println("Media Control is available - Media player will be enabled")
+	mediaLog.Info("=== CHECKING MEDIA CONTROL ===")
+	if app.backend.IsMediaControlAvailable() {
+		mediaLog.Info("Media Control is available - Media player will be enabled")
 	} else {
-		log.Println("Media Control is not installed or not accessible")
-		log.Println("To install Media Control:")
-		log.Println("  1. Install via npm: npm install -g media-control")
-		log.Println("  2. Or install via Homebrew: brew install media-control")
-		log.Println("Media player information will be disabled until Media Control is available")
-	}
-	log.Println("=== MEDIA CONTROL CHECK COMPLETE ===")
-
-	// Check LM Studio availability
-	if app.config.LMStudioEnabled {
-		log.Println("=== CHECKING LM STUDIO ===")
-		if macos.IsLMStudioCLIAvailable() {
-			log.Println("LM Studio CLI (lms) is available - LM Studio control will be enabled")
-			log.Printf("LM Studio API URL: %s", app.config.LMStudioAPIURL)
+		mediaLog.Warn("Media Control is not installed or not accessible")
+		mediaLog.Info("To install Media Control:")
+		mediaLog.Info("  1. Install via npm: npm install -g media-control")
+		mediaLog.Info("  2. Or install via Homebrew: brew install media-control")
+		mediaLog.Info("Media player information will be disabled until Media Control is available")
+	}
+	mediaLog.Info("=== MEDIA CONTROL CHECK COMPLETE ===")
+
+	// Check LLM server availability
+	if app.llmServer != nil {
+		lmstudioLog.Info("=== CHECKING LLM SERVER ===")
+		if app.llmServer.Available() {
+			lmstudioLog.Info("LLM server control will be enabled", "server", app.llmServer.Name())
+			lmstudioLog.Info("LLM server API URL", "url", app.config.llmAPIURL())
 		} else {
-			log.Println("LM Studio CLI (lms) is not installed or not accessible")
-			log.Println("To install LM Studio:")
-			log.Println("  1. Download from https://lmstudio.ai/download")
-			log.Println("  2. Run LM Studio at least once to install CLI tools")
-			log.Println("LM Studio control will be disabled until CLI is available")
-			app.config.LMStudioEnabled = false
+			lmstudioLog.Warn("LM Studio CLI (lms) is not installed or not accessible")
+			lmstudioLog.Info("To install LM Studio:")
+			lmstudioLog.Info("  1. Download from https://lmstudio.ai/download")
+			lmstudioLog.Info("  2. Run LM Studio at least once to install CLI tools")
+			lmstudioLog.Info("LM Studio control will be disabled until CLI is available")
+			app.llmServer = nil
 		}
-		log.Println("=== LM STUDIO CHECK COMPLETE ===")
+		lmstudioLog.Info("=== LLM SERVER CHECK COMPLETE ===")
 	}
 
-	log.Println("Starting MQTT connection...")
+	if app.config.EmbeddedBroker != nil {
+		b, err := broker.Start(*app.config.EmbeddedBroker)
+		if err != nil {
+			return fmt.Errorf("failed to start embedded MQTT broker: %w", err)
+		}
+		defer b.Close()
+	}
+
+	mqttLog.Info("Starting MQTT connection...")
 	if err := app.getMQTTClient(); err != nil {
-		log.Printf("Initial MQTT connection failed: %v", err)
+		mqttLog.Error("Initial MQTT connection failed", "err", err)
 		if !app.isNetworkReachable() {
-			log.Println("MQTT broker not reachable - starting in offline mode")
+			mqttLog.Warn("MQTT broker not reachable - starting in offline mode")
 			app.handleOfflineMode()
 			// Continue running, the network check ticker will handle reconnection
 		} else {
@@ -2118,7 +2292,7 @@ func (app *Application) Run() error {
 	volumeTicker := time.NewTicker(UpdateInterval)
 	batteryTicker := time.NewTicker(UpdateInterval)
 	awakeTicker := time.NewTicker(UpdateInterval)
-	lmStudioTicker := time.NewTicker(15 * time.Second) // LM Studio updates every 15 seconds
+	lmStudioTicker := time.NewTicker(15 * time.Second)     // LM Studio updates every 15 seconds
 	networkCheckTicker := time.NewTicker(30 * time.Second) // Check network every 30 seconds
 	defer volumeTicker.Stop()
 	defer batteryTicker.Stop()
@@ -2140,29 +2314,29 @@ func (app *Application) Run() error {
 		app.updateMute(app.client)
 		app.updateCaffeinateStatus(app.client)
 		app.updateDisplayBrightness(app.client)
-		app.updateNowPlaying(app.client)                 // Initial now playing update
-		app.setUserActivityState(app.client, "inactive") // Initial user activity state
-		app.updateDiskUsage(app.client)                  // Initial disk usage update
-		app.updateCPUUsage(app.client)                   // Initial CPU usage update
-		app.updateMemoryUsage(app.client)                // Initial memory usage update
-		app.updateUptime(app.client)                     // Initial uptime update
-		app.updateMediaDevices(app.client)               // Initial media devices update
-		app.updatePublicIP(app.client)                   // Initial public IP update
-		app.updateTemperatures(app.client)               // Initial temperature update
-		app.updateNetworkStats(app.client)               // Initial network stats update
+		app.publishMedia(app.client)             // Initial media state
+		app.publishUserActivityState(app.client) // Initial user activity state
+		app.updateDiskUsage(app.client)          // Initial disk usage update
+		app.updateCPUUsage(app.client)           // Initial CPU usage update
+		app.updateMemoryUsage(app.client)        // Initial memory usage update
+		app.updateUptime(app.client)             // Initial uptime update
+		app.updateMediaDevices(app.client)       // Initial media devices update
+		app.updatePublicIP(app.client)           // Initial public IP update
+		app.updateTemperatures(app.client)       // Initial temperature update
+		app.updateNetworkStats(app.client)       // Initial network stats update
 
 		// Update LM Studio status if enabled
-		if app.config.LMStudioEnabled {
+		if app.llmServer != nil {
 			app.updateLMStudioStatus(app.client)
 		}
 
 		// Start media stream for real-time updates
-		app.startMediaStream(app.client)
+		app.startMediaSupervisor()
 
 		// Start user activity monitoring
-		app.startUserActivityMonitoring(app.client)
+		app.activityOnce.Do(func() { app.startUserActivityMonitoring(app.client) })
 	} else {
-		log.Println("Skipping initial MQTT setup - will configure when connection is established")
+		mqttLog.Info("Skipping initial MQTT setup - will configure when connection is established")
 	}
 
 	// Main event loop
@@ -2176,7 +2350,7 @@ func (app *Application) Run() error {
 				app.updateMediaDevices(app.client)
 				app.client.Publish(app.getTopicPrefix()+"/status/alive", 0, true, "online")
 			} else if networkReachable {
-				log.Println("MQTT client not connected but network is reachable, connection may be recovering")
+				mqttLog.Debug("MQTT client not connected but network is reachable, connection may be recovering")
 			}
 
 		case <-batteryTicker.C:
@@ -2190,7 +2364,7 @@ func (app *Application) Run() error {
 				app.updateTemperatures(app.client)
 				app.updateNetworkStats(app.client)
 			} else if networkReachable {
-				log.Println("MQTT client not connected but network is reachable, skipping battery update")
+				mqttLog.Debug("MQTT client not connected but network is reachable, skipping battery update")
 			}
 
 		case <-awakeTicker.C:
@@ -2198,13 +2372,13 @@ func (app *Application) Run() error {
 				app.updateCaffeinateStatus(app.client)
 				app.updateDisplayBrightness(app.client)
 			} else if networkReachable {
-				log.Println("MQTT client not connected but network is reachable, skipping status updates")
+				mqttLog.Debug("MQTT client not connected but network is reachable, skipping status updates")
 			}
 			// Note: Media updates now come from the media-control stream
 
 		case <-lmStudioTicker.C:
 			// Update LM Studio status every 15 seconds if enabled
-			if app.config.LMStudioEnabled && app.client.IsConnected() {
+			if app.llmServer != nil && app.client.IsConnected() {
 				app.updateLMStudioStatus(app.client)
 			}
 
@@ -2216,9 +2390,9 @@ func (app *Application) Run() error {
 			// Log network state changes
 			if currentNetworkState != networkReachable {
 				if currentNetworkState {
-					log.Println("Network connectivity restored - MQTT broker is now reachable")
+					mqttLog.Info("Network connectivity restored - MQTT broker is now reachable")
 				} else {
-					log.Println("Network connectivity lost - MQTT broker is no longer reachable")
+					mqttLog.Warn("Network connectivity lost - MQTT broker is no longer reachable")
 				}
 				networkReachable = currentNetworkState
 			}
@@ -2226,9 +2400,9 @@ func (app *Application) Run() error {
 			// Log connection state changes
 			if currentConnectionState != lastConnectionState {
 				if currentConnectionState {
-					log.Println("MQTT connection restored")
+					mqttLog.Info("MQTT connection restored")
 				} else {
-					log.Println("MQTT connection lost")
+					mqttLog.Warn("MQTT connection lost")
 				}
 				lastConnectionState = currentConnectionState
 			}
@@ -2237,11 +2411,11 @@ func (app *Application) Run() error {
 			if currentNetworkState && !networkReachable {
 				// Network just became reachable - try to reconnect if not already connected
 				if !currentConnectionState {
-					log.Println("Attempting to reconnect to MQTT broker...")
+					mqttLog.Info("Attempting to reconnect to MQTT broker...")
 					// The auto-reconnect should handle this, but we can force a reconnection attempt
 					go func() {
 						if token := app.client.Connect(); token.Wait() && token.Error() != nil {
-							log.Printf("Reconnection attempt failed: %v", token.Error())
+							mqttLog.Error("Reconnection attempt failed", "err", token.Error())
 						}
 					}()
 				}
@@ -2311,14 +2485,18 @@ func (app *Application) validateKeepAwakeInput(payload string) (bool, error) {
 }
 
 func main() {
+	configPath := flag.String("config", "", "path to mac2mqtt.yaml (default: next to the executable)")
+	simulate := flag.Bool("simulate", false, "use synthetic sensor data instead of macOS (same as backend: simulated)")
+	flag.Parse()
+
 	// Create and initialize the application
-	app, err := NewApplication()
+	app, err := NewApplication(*configPath, *simulate)
 	if err != nil {
-		log.Fatal("Failed to initialize application: ", err)
+		logging.Fatal(systemLog, "Failed to initialize application", "err", err)
 	}
 
 	// Run the application
 	if err := app.Run(); err != nil {
-		log.Fatal("Application error: ", err)
+		logging.Fatal(systemLog, "Application error", "err", err)
 	}
 }
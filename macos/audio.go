@@ -3,16 +3,19 @@ package macos
 import (
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os/exec"
 	"strconv"
 	"strings"
+
+	"bessarabov/mac2mqtt/logging"
 )
 
+var audioLog = logging.Audio
+
 // GetMuteStatus returns the current mute status of the system
 func GetMuteStatus() bool {
-	log.Println("Getting mute status")
+	audioLog.Debug("Getting mute status")
 	output := getCommandOutput("/usr/bin/osascript", "-e", "output muted of (get volume settings)")
 	b, err := strconv.ParseBool(output)
 	//revive:disable-next-line
@@ -29,19 +32,19 @@ func GetMuteStatus() bool {
 		url := fmt.Sprintf("http://localhost:55777/get?name=%s&mute", encodedSource)
 		resp, err = http.Get(url)
 		if err != nil {
-			log.Printf("Error getting mute status for %s: %v", currentsource, err)
+			audioLog.Error("Error getting mute status", "source", currentsource, "err", err)
 			return false
 		}
 		if resp != nil {
 			defer resp.Body.Close()
 			output, err := io.ReadAll(resp.Body)
 			if err != nil {
-				log.Printf("Error getting mute status body for %s: %v", currentsource, err)
+				audioLog.Error("Error reading mute status body", "source", currentsource, "err", err)
 				return false
 			}
 			output = []byte(strings.TrimSuffix(string(output), "\n"))
 			mute := string(output)
-			log.Println("Mute Output: " + mute)
+			audioLog.Debug("Mute output", "source", currentsource, "value", mute)
 			b = mute == "on"
 		}
 	}
@@ -50,7 +53,7 @@ func GetMuteStatus() bool {
 
 // GetVolume returns the current volume level (0-100)
 func GetVolume() int {
-	log.Println("Getting volume status")
+	audioLog.Debug("Getting volume status")
 	output := getCommandOutput("/usr/bin/osascript", "-e", "output volume of (get volume settings)")
 	output = strings.TrimSuffix(output, "\n")
 	i, err := strconv.Atoi(output)
@@ -63,22 +66,22 @@ func GetVolume() int {
 		url := fmt.Sprintf("http://localhost:55777/get?name=%s&volume", encodedSource)
 		resp, err = http.Get(url)
 		if err != nil {
-			log.Printf("Error getting volume status for %s: %v", currentsource, err)
+			audioLog.Error("Error getting volume status", "source", currentsource, "err", err)
 			return 0
 		}
 		if resp != nil {
 			defer resp.Body.Close()
 			output, err := io.ReadAll(resp.Body)
 			if err != nil {
-				log.Printf("Error getting volume status body for %s: %v", currentsource, err)
+				audioLog.Error("Error reading volume status body", "source", currentsource, "err", err)
 				return 0
 			}
 			output = []byte(strings.TrimSuffix(string(output), "\n"))
 			outputStr := string(output)
-			log.Println("Vol Output: " + outputStr)
+			audioLog.Debug("Volume output", "source", currentsource, "value", outputStr)
 			f, err := strconv.ParseFloat(outputStr, 64)
 			if err != nil {
-				log.Printf("Error parsing volume value for %s: %v", currentsource, err)
+				audioLog.Error("Error parsing volume value", "source", currentsource, "err", err)
 				return 0
 			}
 			i = int(f * 100)
@@ -147,8 +150,7 @@ func getCommandOutput(name string, arg ...string) string {
 	cmd := exec.Command(name, arg...)
 	stdout, err := cmd.Output()
 	if err != nil {
-		log.Println("error: " + err.Error())
-		log.Println("output: " + string(stdout))
+		audioLog.Warn("Command failed", "cmd", name, "err", err, "output", string(stdout))
 		return ""
 	}
 	stdoutStr := string(stdout)
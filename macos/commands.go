@@ -1,18 +1,21 @@
 package macos
 
 import (
-	"log"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+
+	"bessarabov/mac2mqtt/logging"
 )
 
+var systemLog = logging.System
+
 // GetHostname returns the sanitized hostname
 func GetHostname() string {
 	hostname, err := os.Hostname()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(systemLog, "Failed to get hostname", "err", err)
 	}
 
 	// "name.local" => "name"
@@ -21,7 +24,7 @@ func GetHostname() string {
 	// remove all symbols, but [a-zA-Z0-9_-]
 	reg, err := regexp.Compile("[^a-zA-Z0-9_-]+")
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(systemLog, "Invalid sanitize pattern", "err", err)
 	}
 	firstPart = reg.ReplaceAllString(firstPart, "")
 
@@ -33,7 +36,7 @@ func GetSerialnumber() string {
 	cmd := "/usr/sbin/ioreg -l | /usr/bin/grep IOPlatformSerialNumber"
 	output, err := exec.Command("/bin/sh", "-c", cmd).Output()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(systemLog, "Failed to get serial number", "err", err)
 	}
 	outputStr := string(output)
 	last := output[strings.LastIndex(outputStr, " ")+1:]
@@ -42,7 +45,7 @@ func GetSerialnumber() string {
 	// remove all symbols, but [a-zA-Z0-9_-]
 	reg, err := regexp.Compile("[^a-zA-Z0-9_-]+")
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(systemLog, "Invalid sanitize pattern", "err", err)
 	}
 	lastStr = reg.ReplaceAllString(lastStr, "")
 
@@ -54,7 +57,7 @@ func GetModel() string {
 	cmd := "/usr/sbin/system_profiler SPHardwareDataType |/usr/bin/grep Chip | /usr/bin/sed 's/\\(^.*: \\)\\(.*\\)/\\2/'"
 	output, err := exec.Command("/bin/sh", "-c", cmd).Output()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(systemLog, "Failed to get model", "err", err)
 	}
 	outputStr := string(output)
 	outputStr = strings.TrimSuffix(outputStr, "\n")
@@ -75,9 +78,7 @@ func GetCommandOutput(name string, arg ...string) string {
 	cmd := exec.Command(name, arg...)
 	stdout, err := cmd.Output()
 	if err != nil {
-		log.Println("error: " + err.Error())
-		log.Println("output: " + string(stdout))
-		log.Fatal(err)
+		logging.Fatal(systemLog, "Command failed", "cmd", name, "err", err, "output", string(stdout))
 	}
 	stdoutStr := string(stdout)
 	stdoutStr = strings.TrimSuffix(stdoutStr, "\n")
@@ -101,7 +102,7 @@ func RunCommand(name string, arg ...string) {
 	cmd := exec.Command(name, arg...)
 	_, err := cmd.Output()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(systemLog, "Command failed", "cmd", name, "err", err)
 	}
 }
 
@@ -134,7 +135,7 @@ func KeepAwake() {
 	cmd := "/usr/bin/caffeinate -d &"
 	err := exec.Command("/bin/sh", "-c", cmd).Start()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(systemLog, "Failed to start caffeinate", "err", err)
 	}
 }
 
@@ -143,7 +144,7 @@ func AllowSleep() {
 	cmd := "/bin/ps ax | /usr/bin/grep caffeinate | /usr/bin/grep -v grep | /usr/bin/awk '{print \"kill \"$1}'|sh"
 	_, err := exec.Command("/bin/sh", "-c", cmd).Output()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(systemLog, "Failed to stop caffeinate", "err", err)
 	}
 }
 
@@ -3,12 +3,15 @@ package macos
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"os/exec"
 	"strconv"
 	"strings"
+
+	"bessarabov/mac2mqtt/logging"
 )
 
+var displayLog = logging.Display
+
 // BetterDisplayCLIError represents an error when BetterDisplay CLI is not available
 type BetterDisplayCLIError struct {
 	Message string
@@ -46,25 +49,25 @@ func IsBetterDisplayCLIAvailable() bool {
 func GetDisplays() []Display {
 	// Check if BetterDisplay CLI is available
 	if !IsBetterDisplayCLIAvailable() {
-		log.Println("BetterDisplay CLI is not installed or not accessible")
-		log.Println("To install BetterDisplay CLI:")
-		log.Println("  1. Install BetterDisplay from https://github.com/waydabber/BetterDisplay")
-		log.Println("  2. Enable CLI access in BetterDisplay preferences")
-		log.Println("  3. Restart the application")
-		log.Println("Display brightness controls will be disabled until BetterDisplay CLI is available")
+		displayLog.Warn("BetterDisplay CLI is not installed or not accessible")
+		displayLog.Debug("To install BetterDisplay CLI:")
+		displayLog.Debug("  1. Install BetterDisplay from https://github.com/waydabber/BetterDisplay")
+		displayLog.Debug("  2. Enable CLI access in BetterDisplay preferences")
+		displayLog.Debug("  3. Restart the application")
+		displayLog.Debug("Display brightness controls will be disabled until BetterDisplay CLI is available")
 		return nil
 	}
 
-	log.Println("Executing: betterdisplaycli get -identifiers")
+	displayLog.Debug("Executing: betterdisplaycli get -identifiers")
 	out, err := exec.Command("betterdisplaycli", "get", "-identifiers").Output()
 	if err != nil {
-		log.Printf("Error getting displays: %v", err)
-		log.Println("BetterDisplay CLI is installed but failed to execute")
-		log.Println("Make sure BetterDisplay is running and CLI access is enabled")
+		displayLog.Error("Error getting displays", "err", err)
+		displayLog.Warn("BetterDisplay CLI is installed but failed to execute")
+		displayLog.Warn("Make sure BetterDisplay is running and CLI access is enabled")
 		return nil
 	}
 
-	log.Printf("BetterDisplay CLI output: %s", string(out))
+	displayLog.Debug("BetterDisplay CLI output", "output", string(out))
 
 	// BetterDisplay CLI returns comma-separated JSON objects, not an array
 	// We need to wrap it in brackets to make it a valid JSON array
@@ -73,8 +76,8 @@ func GetDisplays() []Display {
 	var displays []Display
 	err = json.Unmarshal([]byte(jsonStr), &displays)
 	if err != nil {
-		log.Printf("Error parsing display JSON: %v", err)
-		log.Println("BetterDisplay CLI returned invalid JSON format")
+		displayLog.Error("Error parsing display JSON", "err", err)
+		displayLog.Warn("BetterDisplay CLI returned invalid JSON format")
 		return nil
 	}
 
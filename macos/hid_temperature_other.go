@@ -0,0 +1,11 @@
+//go:build !darwin || !cgo
+
+package macos
+
+import "errors"
+
+// GetTemperaturesHID reads temperatures from the IOHID sensor hub.
+// Only supported on macOS builds with cgo enabled.
+func GetTemperaturesHID() (*TemperatureInfo, error) {
+	return nil, errors.New("HID temperature sensors are only available on macOS")
+}
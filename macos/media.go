@@ -1,12 +1,19 @@
 package macos
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"bessarabov/mac2mqtt/logging"
 )
 
+var mediaLog = logging.Media
+
 // MediaControlError represents an error when Media Control is not available
 type MediaControlError struct {
 	Message string
@@ -23,9 +30,42 @@ type MediaInfo struct {
 	Album       string `json:"album"`
 	AppName     string `json:"app_name"`
 	AppBundleID string `json:"app_bundle_id"`
-	State       string `json:"state"`    // "playing", "paused", "stopped"
+	State       string `json:"state"`    // "idle", "playing", "paused", "stopped"
 	Duration    int    `json:"duration"` // in seconds
-	Position    int    `json:"position"` // in seconds
+
+	Position          float64   `json:"position"`            // in seconds, at PositionUpdatedAt
+	PositionUpdatedAt time.Time `json:"position_updated_at"` // when Position was reported
+	PlaybackRate      float64   `json:"playback_rate"`       // 1 at normal speed, 0 unless playing
+
+	Artwork         []byte `json:"-"` // cover art as sent by media-control, nil if there is none
+	ArtworkMimeType string `json:"-"`
+
+	Kept bool `json:"-"` // still shown while another app is the now playing one, so its state is unknown
+}
+
+// LivePosition returns the position at now, moved on by the playback rate since it was reported
+func (m MediaInfo) LivePosition(now time.Time) float64 {
+	position := m.Position
+	if m.PlaybackRate > 0 && !m.PositionUpdatedAt.IsZero() {
+		position += m.PlaybackRate * now.Sub(m.PositionUpdatedAt).Seconds()
+	}
+	if m.Duration > 0 {
+		position = min(position, float64(m.Duration))
+	}
+	return max(position, 0)
+}
+
+// ParseMediaTimestamp parses the timestamp media-control reports the elapsed time at,
+// either RFC 3339 or microseconds since the epoch
+func ParseMediaTimestamp(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case float64:
+		return time.UnixMicro(int64(v)), v > 0
+	}
+	return time.Time{}, false
 }
 
 // IsMediaControlAvailable checks if Media Control is installed and accessible
@@ -53,12 +93,6 @@ func GetMediaInfo() (*MediaInfo, error) {
 		return nil, fmt.Errorf("error parsing media-control JSON output: %v", err)
 	}
 
-	// Check if media is playing
-	playing, ok := mediaData["playing"].(bool)
-	if !ok || !playing {
-		return nil, nil // No media playing
-	}
-
 	// Extract media information
 	mediaInfo := &MediaInfo{}
 
@@ -78,10 +112,27 @@ func GetMediaInfo() (*MediaInfo, error) {
 	}
 
 	// Get app name
+	if bundleID, ok := mediaData["bundleIdentifier"].(string); ok && bundleID != "" {
+		mediaInfo.AppBundleID = bundleID
+		mediaInfo.AppName = AppNameForBundleID(bundleID)
+	}
 	if appName, ok := mediaData["appName"].(string); ok && appName != "" {
 		mediaInfo.AppName = appName
 	}
 
+	// No now playing item
+	if mediaInfo.Title == "" && mediaInfo.Artist == "" && mediaInfo.AppName == "" {
+		return nil, nil
+	}
+
+	// Get artwork
+	if data, ok := mediaData["artworkData"].(string); ok && data != "" {
+		if artwork, err := base64.StdEncoding.DecodeString(data); err == nil {
+			mediaInfo.Artwork = artwork
+			mediaInfo.ArtworkMimeType, _ = mediaData["artworkMimeType"].(string)
+		}
+	}
+
 	// Get duration (in seconds)
 	duration := 0
 	if d, ok := mediaData["duration"].(float64); ok {
@@ -95,23 +146,62 @@ func GetMediaInfo() (*MediaInfo, error) {
 	}
 	mediaInfo.Duration = duration
 
-	// Get position (in seconds)
-	position := 0
+	// Get position (in seconds) and when it was reported
+	position := 0.0
 	if p, ok := mediaData["elapsedTime"].(float64); ok {
-		position = int(p)
+		position = p
 	} else if p, ok := mediaData["position"].(float64); ok {
-		position = int(p)
+		position = p
+	} else if p, ok := mediaData["elapsedTimeMicros"].(float64); ok {
+		position = p / 1000000
 	} else if p, ok := mediaData["positionMicros"].(float64); ok {
-		position = int(p / 1000000)
+		position = p / 1000000
 	}
 	mediaInfo.Position = position
+	mediaInfo.PositionUpdatedAt = time.Now()
+	if t, ok := ParseMediaTimestamp(mediaData["timestamp"]); ok {
+		mediaInfo.PositionUpdatedAt = t
+	} else if t, ok := ParseMediaTimestamp(mediaData["timestampEpochMicros"]); ok {
+		mediaInfo.PositionUpdatedAt = t
+	}
 
-	// Set state based on playing status
-	mediaInfo.State = "playing"
+	// Set state based on playing status; the position tells paused from stopped
+	mediaInfo.State = "paused"
+	if playing, _ := mediaData["playing"].(bool); playing {
+		mediaInfo.State = "playing"
+		mediaInfo.PlaybackRate = 1
+		if rate, ok := mediaData["playbackRate"].(float64); ok && rate > 0 {
+			mediaInfo.PlaybackRate = rate
+		}
+	} else if position == 0 {
+		mediaInfo.State = "stopped"
+	}
 
 	return mediaInfo, nil
 }
 
+// appNames are the names of common media apps by bundle ID
+var appNames = map[string]string{
+	"com.apple.Music":            "Music",
+	"com.apple.podcasts":         "Podcasts",
+	"com.apple.TV":               "TV",
+	"com.apple.QuickTimePlayerX": "QuickTime Player",
+	"com.apple.Safari":           "Safari",
+	"com.google.Chrome":          "Google Chrome",
+	"org.mozilla.firefox":        "Firefox",
+	"com.spotify.client":         "Spotify",
+	"org.videolan.vlc":           "VLC",
+	"tv.plex.desktop":            "Plex",
+}
+
+// AppNameForBundleID returns the name of the app with the bundle ID, or the bundle ID if it isn't known
+func AppNameForBundleID(bundleID string) string {
+	if name, ok := appNames[bundleID]; ok {
+		return name
+	}
+	return bundleID
+}
+
 // TogglePlayPause toggles media play/pause
 func TogglePlayPause() error {
 	if !IsMediaControlAvailable() {
@@ -125,10 +215,69 @@ func TogglePlayPause() error {
 	return nil
 }
 
+// Shuffle and repeat modes accepted by media-control
+var (
+	ShuffleModes = []string{"off", "albums", "tracks"}
+	RepeatModes  = []string{"off", "track", "playlist"}
+)
+
+// runMediaControl runs a media-control command on the now playing app
+func runMediaControl(args ...string) error {
+	if !IsMediaControlAvailable() {
+		return &MediaControlError{Message: "Media Control is not installed or not accessible"}
+	}
+
+	out, err := exec.Command("media-control", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running media-control %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Play starts or resumes playback
+func Play() error {
+	return runMediaControl("play")
+}
+
+// Pause pauses playback
+func Pause() error {
+	return runMediaControl("pause")
+}
+
+// NextTrack skips to the next track
+func NextTrack() error {
+	return runMediaControl("next-track")
+}
+
+// PreviousTrack goes back to the previous track
+func PreviousTrack() error {
+	return runMediaControl("previous-track")
+}
+
+// StopMedia stops playback
+func StopMedia() error {
+	return runMediaControl("stop")
+}
+
+// SeekMedia moves playback to position, in seconds
+func SeekMedia(position float64) error {
+	return runMediaControl("seek", strconv.FormatFloat(position, 'f', -1, 64))
+}
+
+// SetShuffleMode sets the shuffle mode: off, albums or tracks
+func SetShuffleMode(mode string) error {
+	return runMediaControl("shuffle", mode)
+}
+
+// SetRepeatMode sets the repeat mode: off, track or playlist
+func SetRepeatMode(mode string) error {
+	return runMediaControl("repeat", mode)
+}
+
 // LogMediaControlInstallInstructions logs installation instructions for Media Control
 func LogMediaControlInstallInstructions() {
-	log.Println("To install Media Control:")
-	log.Println("  1. Install via npm: npm install -g media-control")
-	log.Println("  2. Or install via Homebrew: brew install media-control")
-	log.Println("Media player information will be disabled until Media Control is available")
+	mediaLog.Info("To install Media Control:")
+	mediaLog.Info("  1. Install via npm: npm install -g media-control")
+	mediaLog.Info("  2. Or install via Homebrew: brew install media-control")
+	mediaLog.Info("Media player information will be disabled until Media Control is available")
 }
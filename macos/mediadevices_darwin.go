@@ -0,0 +1,22 @@
+package macos
+
+import (
+	"fmt"
+
+	mediadevices "github.com/antonfisher/go-media-devices-state"
+)
+
+// GetMediaDevicesState returns the state of microphone and camera
+func GetMediaDevicesState() (isMicOn bool, isCameraOn bool, err error) {
+	isMicOn, err = mediadevices.IsMicrophoneOn()
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get microphone state: %w", err)
+	}
+
+	isCameraOn, err = mediadevices.IsCameraOn()
+	if err != nil {
+		return isMicOn, false, fmt.Errorf("failed to get camera state: %w", err)
+	}
+
+	return isMicOn, isCameraOn, nil
+}
@@ -0,0 +1,11 @@
+//go:build !darwin
+
+package macos
+
+import "errors"
+
+// GetMediaDevicesState returns the state of microphone and camera.
+// Only supported on macOS.
+func GetMediaDevicesState() (isMicOn bool, isCameraOn bool, err error) {
+	return false, false, errors.New("media device state is only available on macOS")
+}
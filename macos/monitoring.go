@@ -3,7 +3,6 @@ package macos
 import (
 	"context"
 	"fmt"
-	"log"
 	"net"
 	"os/exec"
 	"regexp"
@@ -11,7 +10,6 @@ import (
 	"strings"
 	"time"
 
-	mediadevices "github.com/antonfisher/go-media-devices-state"
 	sigar "github.com/cloudfoundry/gosigar"
 	mem "github.com/shirou/gopsutil/v3/mem"
 )
@@ -198,7 +196,7 @@ func GetBatteryChargePercent() string {
 	cmd := exec.Command("/usr/bin/pmset", "-g", "batt")
 	output, err := cmd.Output()
 	if err != nil {
-		log.Printf("Error getting battery charge: %v", err)
+		systemLog.Warn("Error getting battery charge", "err", err)
 		return ""
 	}
 
@@ -240,21 +238,6 @@ func GetSystemIdleTime() (int, error) {
 	return idleTimeSeconds, nil
 }
 
-// GetMediaDevicesState returns the state of microphone and camera
-func GetMediaDevicesState() (isMicOn bool, isCameraOn bool, err error) {
-	isMicOn, err = mediadevices.IsMicrophoneOn()
-	if err != nil {
-		return false, false, fmt.Errorf("failed to get microphone state: %w", err)
-	}
-
-	isCameraOn, err = mediadevices.IsCameraOn()
-	if err != nil {
-		return isMicOn, false, fmt.Errorf("failed to get camera state: %w", err)
-	}
-
-	return isMicOn, isCameraOn, nil
-}
-
 // GetPublicIP returns the public IP address of the system
 func GetPublicIP() (string, error) {
 	// Use DNS to query Google's whoami service
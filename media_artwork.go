@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // artwork formats media-control may send
+	"image/jpeg"
+	_ "image/png"
+
+	"bessarabov/mac2mqtt/macos"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// defaultArtworkSize is the longest side of the published artwork in pixels
+const defaultArtworkSize = 512
+
+// artworkJPEGQuality is the quality of the re-encoded artwork
+const artworkJPEGQuality = 85
+
+// maxArtworkPixels is the largest artwork that is decoded, so a huge image can't take all the memory
+const maxArtworkPixels = 4096 * 4096
+
+// artworkSize returns the longest side of the published artwork: media_artwork_size or the default
+func (c *config) artworkSize() int {
+	if c.MediaArtworkSize > 0 {
+		return c.MediaArtworkSize
+	}
+	return defaultArtworkSize
+}
+
+// resizeArtwork scales the artwork down so its longest side is at most maxSize and re-encodes it as JPEG
+func resizeArtwork(data []byte, maxSize int) ([]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding artwork: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxArtworkPixels {
+		return nil, fmt.Errorf("%dx%d %s artwork is too large", cfg.Width, cfg.Height, format)
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding artwork: %w", err)
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("empty %s artwork", format)
+	}
+	dstWidth, dstHeight := width, height
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			dstWidth, dstHeight = maxSize, max(1, height*maxSize/width)
+		} else {
+			dstWidth, dstHeight = max(1, width*maxSize/height), maxSize
+		}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	dst := rgba
+	if dstWidth != width || dstHeight != height {
+		dst = downscale(rgba, dstWidth, dstHeight)
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: artworkJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("encoding artwork: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// downscale shrinks src to width x height, averaging the source pixels under each target pixel
+func downscale(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, max((y+1)*srcHeight/height, y*srcHeight/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, max((x+1)*srcWidth/width, x*srcWidth/width+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// publishMediaArtwork publishes the cover art of the now playing item, or clears it
+// when nothing is playing. The same cover is only published once.
+func (app *Application) publishMediaArtwork(client mqtt.Client, media macos.MediaInfo) {
+	var hash string
+	if media.State != "idle" && len(media.Artwork) > 0 {
+		sum := sha256.Sum256(media.Artwork)
+		hash = hex.EncodeToString(sum[:])
+	}
+
+	app.artworkMutex.Lock()
+	defer app.artworkMutex.Unlock()
+	if hash == app.artworkHash {
+		return
+	}
+
+	topic := app.getTopicPrefix() + "/status/media_artwork"
+	if hash == "" {
+		mediaLog.Debug("Clearing media artwork")
+		client.Publish(topic, 0, true, []byte{})
+		app.publishMediaPlayerAlbumArt(client, nil)
+		app.artworkHash = ""
+		return
+	}
+
+	artwork, err := resizeArtwork(media.Artwork, app.config.artworkSize())
+	if err != nil {
+		mediaLog.Warn("Failed to convert media artwork", "mime_type", media.ArtworkMimeType, "err", err)
+		client.Publish(topic, 0, true, []byte{})
+		app.publishMediaPlayerAlbumArt(client, nil)
+		app.artworkHash = hash // don't retry the same artwork
+		return
+	}
+	mediaLog.Debug("Publishing media artwork", "bytes", len(artwork), "title", media.Title)
+	client.Publish(topic, 0, true, artwork)
+	app.publishMediaPlayerAlbumArt(client, artwork)
+	app.artworkHash = hash
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bessarabov/mac2mqtt/macos"
+	"bessarabov/mac2mqtt/state"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mediaSkipSeconds is how far the skip buttons jump
+const mediaSkipSeconds = 15
+
+// mediaCommand is the payload of /command/media, e.g.
+// {"command": "seek", "position": 90, "only_if_app": "com.spotify.client"}
+//
+// media-control always controls the now playing app, so OnlyIfApp can't send a
+// command to another app; it only drops the command if another app is playing.
+type mediaCommand struct {
+	Command   string   `json:"command"`               // playpause, play, pause, next, previous, stop, seek, skip, shuffle or repeat
+	OnlyIfApp string   `json:"only_if_app,omitempty"` // only run if this app (name or bundle ID) is playing
+	Position  *float64 `json:"position,omitempty"`    // seek: seconds from the start
+	Seconds   float64  `json:"seconds,omitempty"`     // skip: seconds forward, negative to go back
+	Mode      string   `json:"mode,omitempty"`        // shuffle: off, albums or tracks; repeat: off, track or playlist
+}
+
+// handleMediaCommand handles the media transport commands
+func (app *Application) handleMediaCommand(client mqtt.Client, topic, payload string) bool {
+	basePrefix := app.getTopicPrefix() + "/command/"
+	if !strings.HasPrefix(topic, basePrefix+"media") {
+		return false
+	}
+	payload = strings.TrimSpace(payload)
+
+	var cmd mediaCommand
+	switch strings.TrimPrefix(topic, basePrefix) {
+	case "media":
+		if err := json.Unmarshal([]byte(payload), &cmd); err != nil {
+			mediaLog.Warn("Invalid media command", "payload", payload, "err", err)
+			return true
+		}
+	case "media_next":
+		cmd.Command = "next"
+	case "media_previous":
+		cmd.Command = "previous"
+	case "media_stop":
+		cmd.Command = "stop"
+	case "media_seek":
+		position, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			mediaLog.Warn("Invalid seek position", "payload", payload)
+			return true
+		}
+		cmd = mediaCommand{Command: "seek", Position: &position}
+	case "media_skip":
+		seconds, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			mediaLog.Warn("Invalid skip seconds", "payload", payload)
+			return true
+		}
+		cmd = mediaCommand{Command: "skip", Seconds: seconds}
+	case "media_shuffle":
+		cmd = mediaCommand{Command: "shuffle", Mode: payload}
+	case "media_repeat":
+		cmd = mediaCommand{Command: "repeat", Mode: payload}
+	default:
+		return false
+	}
+
+	if err := app.runMediaCommand(client, cmd); err != nil {
+		mediaLog.Warn("Media command failed", "command", cmd.Command, "err", err)
+	}
+	return true
+}
+
+// runMediaCommand runs a transport command on the now playing app
+func (app *Application) runMediaCommand(client mqtt.Client, cmd mediaCommand) error {
+	if cmd.OnlyIfApp != "" && !app.mediaAppPlaying(cmd.OnlyIfApp) {
+		current := app.currentMedia()
+		return fmt.Errorf("%s is not the now playing app (now playing: %q)", cmd.OnlyIfApp, current.AppName)
+	}
+
+	mediaLog.Info("Media command", "command", cmd.Command, "only_if_app", cmd.OnlyIfApp)
+	switch cmd.Command {
+	case "playpause":
+		app.backend.PlayPause()
+		return nil
+	case "play":
+		return app.backend.Play()
+	case "pause":
+		return app.backend.Pause()
+	case "next":
+		return app.backend.NextTrack()
+	case "previous":
+		return app.backend.PreviousTrack()
+	case "stop":
+		return app.backend.StopMedia()
+	case "seek":
+		if cmd.Position == nil || *cmd.Position < 0 {
+			return fmt.Errorf("seek needs a position of 0 or more seconds")
+		}
+		return app.backend.SeekMedia(*cmd.Position)
+	case "skip":
+		if cmd.Seconds == 0 {
+			return fmt.Errorf("skip needs the seconds to skip")
+		}
+		current := app.currentMedia()
+		position := current.LivePosition(time.Now()) + cmd.Seconds
+		if current.Duration > 0 {
+			position = min(position, float64(current.Duration))
+		}
+		return app.backend.SeekMedia(max(0, position))
+	case "shuffle":
+		if !slices.Contains(macos.ShuffleModes, cmd.Mode) {
+			return fmt.Errorf("invalid shuffle mode %q, expected one of %v", cmd.Mode, macos.ShuffleModes)
+		}
+		if err := app.backend.SetShuffleMode(cmd.Mode); err != nil {
+			return err
+		}
+		client.Publish(app.getTopicPrefix()+"/status/media_shuffle", 0, true, cmd.Mode)
+		return nil
+	case "repeat":
+		if !slices.Contains(macos.RepeatModes, cmd.Mode) {
+			return fmt.Errorf("invalid repeat mode %q, expected one of %v", cmd.Mode, macos.RepeatModes)
+		}
+		if err := app.backend.SetRepeatMode(cmd.Mode); err != nil {
+			return err
+		}
+		client.Publish(app.getTopicPrefix()+"/status/media_repeat", 0, true, cmd.Mode)
+		return nil
+	default:
+		return fmt.Errorf("unknown media command %q", cmd.Command)
+	}
+}
+
+// currentMedia returns the now playing media with its live position if something
+// is playing, otherwise the last state seen on the media stream
+func (app *Application) currentMedia() macos.MediaInfo {
+	if info, err := app.backend.GetMediaInfo(); err == nil && info != nil {
+		return *info
+	}
+	return state.Value(app.store, mediaStateKey)
+}
+
+// mediaAppPlaying reports whether the now playing app is target, by name or bundle ID
+func (app *Application) mediaAppPlaying(target string) bool {
+	current := app.currentMedia()
+	return current.State != "idle" &&
+		(strings.EqualFold(current.AppName, target) || strings.EqualFold(current.AppBundleID, target))
+}
+
+// mediaControlComponents returns the device discovery components of the transport controls
+func (app *Application) mediaControlComponents() map[string]interface{} {
+	basePrefix := app.getTopicPrefix()
+	button := func(name, command, payload, icon string) map[string]interface{} {
+		return map[string]interface{}{
+			"p":             "button",
+			"name":          name,
+			"unique_id":     app.hostname + "_" + command,
+			"command_topic": basePrefix + "/command/" + command,
+			"payload_press": payload,
+			"icon":          icon,
+		}
+	}
+	modeSelect := func(name, command string, options []string, icon string) map[string]interface{} {
+		return map[string]interface{}{
+			"p":             "select",
+			"name":          name,
+			"unique_id":     app.hostname + "_" + command,
+			"command_topic": basePrefix + "/command/" + command,
+			"state_topic":   basePrefix + "/status/" + command,
+			"options":       options,
+			"icon":          icon,
+		}
+	}
+
+	skipBack := button("Skip Back", "media_skip", strconv.Itoa(-mediaSkipSeconds), "mdi:rewind-15")
+	skipBack["unique_id"] = app.hostname + "_media_skip_back"
+	skipForward := button("Skip Forward", "media_skip", strconv.Itoa(mediaSkipSeconds), "mdi:fast-forward-15")
+	skipForward["unique_id"] = app.hostname + "_media_skip_forward"
+
+	return map[string]interface{}{
+		"media_next":         button("Next Track", "media_next", "next", "mdi:skip-next"),
+		"media_previous":     button("Previous Track", "media_previous", "previous", "mdi:skip-previous"),
+		"media_stop":         button("Stop", "media_stop", "stop", "mdi:stop"),
+		"media_skip_back":    skipBack,
+		"media_skip_forward": skipForward,
+		"media_seek": map[string]interface{}{
+			"p":                   "number",
+			"name":                "Media Position",
+			"unique_id":           app.hostname + "_media_seek",
+			"command_topic":       basePrefix + "/command/media_seek",
+			"state_topic":         basePrefix + "/status/now_playing_attr",
+			"value_template":      "{{ value_json.position }}",
+			"min":                 0,
+			"max":                 36000,
+			"step":                1,
+			"mode":                "box",
+			"unit_of_measurement": "s",
+			"icon":                "mdi:timer-music-outline",
+		},
+		"media_shuffle": modeSelect("Shuffle", "media_shuffle", macos.ShuffleModes, "mdi:shuffle-variant"),
+		"media_repeat":  modeSelect("Repeat", "media_repeat", macos.RepeatModes, "mdi:repeat"),
+	}
+}
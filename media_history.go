@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bessarabov/mac2mqtt/macos"
+	"bessarabov/mac2mqtt/state"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mediaHistoryFile is the listening history in state_dir, one JSON play per line
+const mediaHistoryFile = "media_history.jsonl"
+
+// Default thresholds for a play to count: half of the track or 4 minutes, like ListenBrainz and Last.fm
+const (
+	defaultMediaHistoryMinPercent = 50
+	defaultMediaHistoryMinSeconds = 240
+)
+
+// mediaPlayRecord is a track that was listened to
+type mediaPlayRecord struct {
+	Title       string    `json:"title"`
+	Artist      string    `json:"artist,omitempty"`
+	Album       string    `json:"album,omitempty"`
+	App         string    `json:"app,omitempty"`
+	AppBundleID string    `json:"app_bundle_id,omitempty"`
+	Duration    int       `json:"duration,omitempty"` // in seconds, 0 if unknown
+	Listened    int       `json:"listened"`           // seconds spent playing it
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// mediaPlay is the track that is listened to now
+type mediaPlay struct {
+	record       mediaPlayRecord
+	listened     time.Duration
+	playingSince time.Time // zero unless playing
+	position     float64   // last reported position, to notice the track starting over
+}
+
+// mediaPlayTracker follows the media state and reports the plays that reach the thresholds
+type mediaPlayTracker struct {
+	mu         sync.Mutex
+	minPercent int
+	minListen  time.Duration
+	current    *mediaPlay
+}
+
+func newMediaPlayTracker(minPercent, minSeconds int) *mediaPlayTracker {
+	if minPercent <= 0 {
+		minPercent = defaultMediaHistoryMinPercent
+	}
+	if minSeconds <= 0 {
+		minSeconds = defaultMediaHistoryMinSeconds
+	}
+	return &mediaPlayTracker{minPercent: minPercent, minListen: time.Duration(minSeconds) * time.Second}
+}
+
+// update follows the media state m seen at now. When the track it played before ends,
+// it returns the play if it was listened to long enough.
+func (t *mediaPlayTracker) update(m macos.MediaInfo, now time.Time) *mediaPlayRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var finished *mediaPlayRecord
+	if p := t.current; p != nil {
+		if !p.playingSince.IsZero() && now.After(p.playingSince) {
+			p.listened += now.Sub(p.playingSince)
+		}
+		r := p.record
+		sameTrack := r.Title == m.Title && r.Artist == m.Artist && r.Album == m.Album && r.AppBundleID == m.AppBundleID
+		// Repeated, or skipped back to the start
+		restarted := m.Position < 5 && p.position-m.Position > 10
+		if m.State == "idle" || !sameTrack || restarted {
+			finished = t.finish(p)
+			t.current = nil
+		}
+	}
+
+	if t.current == nil && m.State != "idle" && m.Title != "" {
+		t.current = &mediaPlay{record: mediaPlayRecord{
+			Title:       m.Title,
+			Artist:      m.Artist,
+			Album:       m.Album,
+			App:         m.AppName,
+			AppBundleID: m.AppBundleID,
+			StartedAt:   now,
+		}}
+	}
+	if p := t.current; p != nil {
+		if m.Duration > 0 {
+			p.record.Duration = m.Duration
+		}
+		p.position = m.Position
+		p.playingSince = time.Time{}
+		if m.State == "playing" {
+			p.playingSince = now
+		}
+	}
+	return finished
+}
+
+// finish returns the record of p if it was listened to for minListen or minPercent of the track
+func (t *mediaPlayTracker) finish(p *mediaPlay) *mediaPlayRecord {
+	enough := p.listened >= t.minListen
+	if d := p.record.Duration; d > 0 {
+		enough = enough || p.listened >= time.Duration(d)*time.Second*time.Duration(t.minPercent)/100
+	}
+	if !enough {
+		mediaLog.Debug("Play too short for the history", "title", p.record.Title, "listened", p.listened)
+		return nil
+	}
+	r := p.record
+	r.Listened = int(p.listened.Round(time.Second) / time.Second)
+	return &r
+}
+
+// startMediaHistory follows the media state for the listening history and the scrobbler
+func (app *Application) startMediaHistory() {
+	if !app.config.MediaHistory && app.config.ListenBrainzToken == "" {
+		return
+	}
+	tracker := newMediaPlayTracker(app.config.MediaHistoryMinPercent, app.config.MediaHistoryMinSeconds)
+
+	if app.config.ListenBrainzToken != "" {
+		var queuePath string
+		if app.config.StateDir != "" {
+			queuePath = filepath.Join(app.config.StateDir, listenBrainzQueueFile)
+		}
+		scrobbler, err := newListenBrainzScrobbler(app.config.listenBrainzURL(), app.config.ListenBrainzToken, queuePath)
+		if err != nil {
+			mediaLog.Warn("Failed to read the scrobble queue, unsent listens are lost", "file", queuePath, "err", err)
+		}
+		app.scrobbler = scrobbler
+		go scrobbler.run(listenBrainzRetryInterval)
+	}
+
+	app.store.Subscribe(string(mediaStateKey), func(change state.Change) {
+		m, _ := change.Current.Value.(macos.MediaInfo)
+		if play := tracker.update(m, change.Current.UpdatedAt); play != nil {
+			app.recordMediaPlay(*play)
+		}
+	})
+}
+
+// recordMediaPlay adds a play to the history, publishes it and queues it for scrobbling
+func (app *Application) recordMediaPlay(play mediaPlayRecord) {
+	mediaLog.Info("Track played", "artist", play.Artist, "title", play.Title, "listened", play.Listened)
+
+	if app.config.MediaHistory && app.config.StateDir != "" {
+		path := filepath.Join(app.config.StateDir, mediaHistoryFile)
+		if err := appendMediaHistory(path, play); err != nil {
+			mediaLog.Warn("Failed to write the listening history", "file", path, "err", err)
+		}
+	}
+	if client := app.getClient(); client != nil && client.IsConnected() {
+		app.publishMediaLastTrack(client, play)
+	}
+	if app.scrobbler != nil {
+		app.scrobbler.add(play)
+	}
+}
+
+// appendMediaHistory appends a play to the history file
+func appendMediaHistory(path string, play mediaPlayRecord) error {
+	line, err := json.Marshal(play)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("appending to %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// publishMediaLastTrack publishes a play as a Home Assistant event
+func (app *Application) publishMediaLastTrack(client mqtt.Client, play mediaPlayRecord) {
+	event := map[string]interface{}{
+		"event_type":    "played",
+		"title":         play.Title,
+		"artist":        play.Artist,
+		"album":         play.Album,
+		"app_name":      play.App,
+		"app_bundle_id": play.AppBundleID,
+		"duration":      play.Duration,
+		"listened":      play.Listened,
+		"started_at":    play.StartedAt.UTC().Format(time.RFC3339),
+	}
+	data, _ := json.Marshal(event)
+	client.Publish(app.getTopicPrefix()+"/status/media_last_track", 0, false, string(data))
+}
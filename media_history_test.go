@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bessarabov/mac2mqtt/macos"
+	"bessarabov/mac2mqtt/mqtttest"
+)
+
+func TestMediaPlayTracker(t *testing.T) {
+	tracker := newMediaPlayTracker(50, 240)
+	t0 := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	track := func(title, state string, duration int, position float64) macos.MediaInfo {
+		return macos.MediaInfo{Title: title, Artist: "Massive Attack", AppName: "Music", AppBundleID: "com.apple.Music",
+			State: state, Duration: duration, Position: position}
+	}
+	steps := []struct {
+		media macos.MediaInfo
+		at    time.Duration
+		want  string // title of the finished play, if any
+	}{
+		{track("Teardrop", "playing", 200, 0), 0, ""},
+		{track("Teardrop", "paused", 200, 90), 90 * time.Second, ""},
+		{track("Teardrop", "playing", 200, 90), 200 * time.Second, ""},
+		{track("Angel", "playing", 0, 0), 215 * time.Second, "Teardrop"}, // 105 of 200 seconds
+		{track("Angel", "playing", 380, 10), 225 * time.Second, ""},
+		{macos.MediaInfo{State: "idle"}, 235 * time.Second, ""}, // 20 of 380 seconds
+		{track("Karmacoma", "playing", 60, 0), 300 * time.Second, ""},
+		{track("Karmacoma", "playing", 60, 55), 355 * time.Second, ""},
+		{track("Karmacoma", "playing", 60, 0), 361 * time.Second, "Karmacoma"}, // repeated
+	}
+	for i, step := range steps {
+		got := tracker.update(step.media, t0.Add(step.at))
+		switch {
+		case step.want == "" && got != nil:
+			t.Errorf("step %d: unexpected play %+v", i, got)
+		case step.want != "" && (got == nil || got.Title != step.want):
+			t.Errorf("step %d: got %+v, want a play of %s", i, got, step.want)
+		}
+		if got != nil && got.Title == "Teardrop" {
+			want := mediaPlayRecord{Title: "Teardrop", Artist: "Massive Attack", App: "Music", AppBundleID: "com.apple.Music",
+				Duration: 200, Listened: 105, StartedAt: t0}
+			if *got != want {
+				t.Errorf("got %+v, want %+v", *got, want)
+			}
+		}
+	}
+}
+
+// fakeListenBrainz is a stand-in for the ListenBrainz API that fails while down
+type fakeListenBrainz struct {
+	*httptest.Server
+	mu      sync.Mutex
+	status  int
+	listens []map[string]interface{}
+}
+
+func newFakeListenBrainz(t *testing.T) *fakeListenBrainz {
+	f := &fakeListenBrainz{status: http.StatusOK}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.status != http.StatusOK {
+			http.Error(w, "unavailable", f.status)
+			return
+		}
+		var body struct {
+			Payload []map[string]interface{} `json:"payload"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.listens = append(f.listens, body.Payload...)
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeListenBrainz) setStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+func (f *fakeListenBrainz) received() []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]map[string]interface{}(nil), f.listens...)
+}
+
+func TestListenBrainzScrobbler(t *testing.T) {
+	server := newFakeListenBrainz(t)
+	queuePath := filepath.Join(t.TempDir(), listenBrainzQueueFile)
+	play := mediaPlayRecord{Title: "Teardrop", Artist: "Massive Attack", Album: "Mezzanine", App: "Music", Duration: 330,
+		StartedAt: time.Unix(1760788800, 0)}
+
+	// Offline: the listen is kept, also across restarts
+	server.setStatus(http.StatusServiceUnavailable)
+	s, err := newListenBrainzScrobbler(server.URL, "secret", queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.add(play)
+	s.add(mediaPlayRecord{Title: "Weekly Standup"}) // no artist: not scrobbled
+	if err := s.flush(); err == nil {
+		t.Fatal("expected the submission to fail")
+	}
+	s, err = newListenBrainzScrobbler(server.URL, "secret", queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := s.queued(); n != 1 {
+		t.Fatalf("expected 1 queued listen after a restart, got %d", n)
+	}
+
+	server.setStatus(http.StatusOK)
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+	listens := server.received()
+	if len(listens) != 1 {
+		t.Fatalf("expected 1 listen, got %v", listens)
+	}
+	metadata := listens[0]["track_metadata"].(map[string]interface{})
+	if listens[0]["listened_at"] != 1760788800.0 || metadata["artist_name"] != "Massive Attack" ||
+		metadata["track_name"] != "Teardrop" || metadata["release_name"] != "Mezzanine" {
+		t.Errorf("unexpected listen: %v", listens[0])
+	}
+	if _, err := os.Stat(queuePath); !os.IsNotExist(err) {
+		t.Errorf("expected the empty queue to be removed, got %v", err)
+	}
+
+	// Rejected listens are dropped instead of blocking the queue
+	server.setStatus(http.StatusBadRequest)
+	s.add(play)
+	if err := s.flush(); err != nil {
+		t.Errorf("expected a rejected listen to be dropped, got %v", err)
+	}
+	if n := s.queued(); n != 0 {
+		t.Errorf("expected an empty queue, got %d", n)
+	}
+}
+
+func TestMediaHistory(t *testing.T) {
+	retry := listenBrainzRetryInterval
+	listenBrainzRetryInterval = 50 * time.Millisecond
+	t.Cleanup(func() { listenBrainzRetryInterval = retry })
+
+	server := newFakeListenBrainz(t)
+	server.setStatus(http.StatusServiceUnavailable)
+	stateDir := t.TempDir()
+	backend := newFakeBackend()
+	_, broker := startTestApp(t, backend, func(c *config) {
+		c.StateDir = stateDir
+		c.MediaHistory = true
+		c.MediaHistoryMinSeconds = 1
+		c.ListenBrainzToken = "secret"
+		c.ListenBrainzURL = server.URL + "/"
+	})
+	waitMediaStream(t, backend)
+
+	payload := broker.WaitFor(t, "homeassistant/device/testmac/config", nil)
+	if !strings.Contains(payload, `"media_last_track"`) {
+		t.Error("missing media_last_track event entity")
+	}
+
+	backend.emitMediaSnapshot(map[string]interface{}{"title": "Teardrop", "artist": "Massive Attack", "playing": true, "duration": 330.0})
+	broker.WaitForPayload(t, testPrefix+"/status/media_state", "playing")
+	time.Sleep(1100 * time.Millisecond)
+	backend.emitMedia(map[string]interface{}{"title": "Angel", "elapsedTime": 0.0})
+
+	event := broker.WaitFor(t, testPrefix+"/status/media_last_track", nil)
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(event), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["event_type"] != "played" || got["title"] != "Teardrop" || got["listened"] != 1.0 {
+		t.Errorf("unexpected event: %v", got)
+	}
+
+	history, err := os.ReadFile(filepath.Join(stateDir, mediaHistoryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var play mediaPlayRecord
+	if err := json.Unmarshal(history, &play); err != nil {
+		t.Fatalf("invalid history %q: %v", history, err)
+	}
+	if play.Title != "Teardrop" || play.Artist != "Massive Attack" || play.Duration != 330 {
+		t.Errorf("unexpected history entry: %+v", play)
+	}
+
+	// The listen is submitted once the API is back
+	server.setStatus(http.StatusOK)
+	deadline := time.Now().Add(mqtttest.DefaultTimeout)
+	for len(server.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if listens := server.received(); len(listens) != 1 {
+		t.Errorf("expected the listen to be retried, got %v", listens)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"bessarabov/mac2mqtt/macos"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Home Assistant's MQTT integration has no media player platform. With media_player_discovery,
+// a media player is announced in the schema of the community mqtt_media_player integration
+// (https://github.com/bkbilly/mqtt_media_player), wired to the existing media topics where
+// their format fits and to a few topics published for it otherwise.
+
+// publishMediaPlayerDiscovery publishes the mqtt_media_player entity, or removes it when it is disabled
+func (app *Application) publishMediaPlayerDiscovery(client mqtt.Client, device, origin map[string]interface{}) {
+	basePrefix := app.getTopicPrefix()
+	topic := app.config.DiscoveryPrefix + "/media_player/" + app.hostname + "/config"
+	if !app.config.MediaPlayerDiscovery || !app.backend.IsMediaControlAvailable() {
+		client.Publish(topic, 0, true, "")
+		return
+	}
+
+	playerConfig := map[string]interface{}{
+		"name":                      app.hostname,
+		"unique_id":                 app.hostname + "_media_player",
+		"state_state_topic":         basePrefix + "/status/media_player_state",
+		"state_title_topic":         basePrefix + "/status/media_title",
+		"state_artist_topic":        basePrefix + "/status/media_artist",
+		"state_album_topic":         basePrefix + "/status/media_album",
+		"state_duration_topic":      basePrefix + "/status/media_duration",
+		"state_position_topic":      basePrefix + "/status/media_position",
+		"state_volume_topic":        basePrefix + "/status/media_player_volume",
+		"state_mute_topic":          basePrefix + "/status/mute",
+		"state_albumart_topic":      basePrefix + "/status/media_player_albumart",
+		"command_volume_topic":      basePrefix + "/command/media_player_volume",
+		"command_mute_topic":        basePrefix + "/command/mute",
+		"command_play_topic":        basePrefix + "/command/media",
+		"command_play_payload":      `{"command": "play"}`,
+		"command_pause_topic":       basePrefix + "/command/media",
+		"command_pause_payload":     `{"command": "pause"}`,
+		"command_playpause_topic":   basePrefix + "/command/playpause",
+		"command_playpause_payload": "playpause",
+		"command_next_topic":        basePrefix + "/command/media_next",
+		"command_next_payload":      "next",
+		"command_previous_topic":    basePrefix + "/command/media_previous",
+		"command_previous_payload":  "previous",
+		"device":                    device,
+		"origin":                    origin,
+		"availability": map[string]interface{}{
+			"topic": basePrefix + "/status/alive",
+		},
+	}
+	playerJSON, _ := json.Marshal(playerConfig)
+	mediaLog.Debug("Publishing media player discovery", "topic", topic)
+	client.Publish(topic, 0, true, playerJSON)
+}
+
+// mediaPlayerState returns the Home Assistant media player state for a media state,
+// which has no stopped
+func mediaPlayerState(state string) string {
+	if state == "stopped" {
+		return "idle"
+	}
+	return state
+}
+
+// publishMediaPlayerState publishes the media player state, which can't use media_state
+func (app *Application) publishMediaPlayerState(client mqtt.Client, m macos.MediaInfo) {
+	if !app.config.MediaPlayerDiscovery {
+		return
+	}
+	client.Publish(app.getTopicPrefix()+"/status/media_player_state", 0, false, mediaPlayerState(m.State))
+}
+
+// publishMediaPlayerVolume publishes the volume from 0 to 1 for the media player
+func (app *Application) publishMediaPlayerVolume(client mqtt.Client, volume int) {
+	if !app.config.MediaPlayerDiscovery {
+		return
+	}
+	client.Publish(app.getTopicPrefix()+"/status/media_player_volume", 0, false, strconv.FormatFloat(float64(volume)/100, 'f', -1, 64))
+}
+
+// publishMediaPlayerAlbumArt publishes the artwork base64 encoded for the media player, or clears it
+func (app *Application) publishMediaPlayerAlbumArt(client mqtt.Client, artwork []byte) {
+	if !app.config.MediaPlayerDiscovery {
+		return
+	}
+	client.Publish(app.getTopicPrefix()+"/status/media_player_albumart", 0, true, base64.StdEncoding.EncodeToString(artwork))
+}
+
+// handleMediaPlayerVolumeCommand handles the media player volume from 0 to 1
+func (app *Application) handleMediaPlayerVolumeCommand(client mqtt.Client, topic, payload string) bool {
+	if topic != app.getTopicPrefix()+"/command/media_player_volume" {
+		return false
+	}
+
+	level, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	if err != nil || level < 0 || level > 1 {
+		mqttLog.Warn("Invalid media player volume, expected 0 to 1", "payload", payload)
+		return true
+	}
+
+	app.backend.SetVolume(int(level*100 + 0.5))
+	app.updateVolume(client)
+	app.updateMute(client)
+	return true
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultListenBrainzURL is the API of listenbrainz.org
+const defaultListenBrainzURL = "https://api.listenbrainz.org"
+
+// listenBrainzQueueFile keeps the listens that are not submitted yet in state_dir
+const listenBrainzQueueFile = "listenbrainz_queue.json"
+
+// listenBrainzBatchSize is the most listens submitted at once
+const listenBrainzBatchSize = 100
+
+// listenBrainzRetryInterval is how often unsent listens are retried; tests shorten it
+var listenBrainzRetryInterval = time.Minute
+
+// listenBrainzURL returns the base URL of the ListenBrainz compatible API: listenbrainz_url or listenbrainz.org
+func (c *config) listenBrainzURL() string {
+	if c.ListenBrainzURL != "" {
+		return strings.TrimRight(c.ListenBrainzURL, "/")
+	}
+	return defaultListenBrainzURL
+}
+
+// listenBrainzError is an error response of the API
+type listenBrainzError struct {
+	Status int
+	Body   string
+}
+
+func (e *listenBrainzError) Error() string {
+	return fmt.Sprintf("ListenBrainz returned %d: %s", e.Status, e.Body)
+}
+
+// permanent reports whether submitting the same listens again fails the same way
+func (e *listenBrainzError) permanent() bool {
+	return e.Status >= 400 && e.Status < 500 && e.Status != http.StatusTooManyRequests && e.Status != http.StatusUnauthorized
+}
+
+// listenBrainzScrobbler submits plays to a ListenBrainz compatible API. Plays that can't be
+// submitted, e.g. while offline, are kept in a queue file and retried.
+type listenBrainzScrobbler struct {
+	url    string
+	token  string
+	client *http.Client
+
+	mu    sync.Mutex
+	path  string // queue file, empty to keep the queue in memory only
+	queue []mediaPlayRecord
+	wake  chan struct{}
+}
+
+// newListenBrainzScrobbler creates a scrobbler with the queue left in path. The scrobbler
+// is usable even if the queue can't be read.
+func newListenBrainzScrobbler(url, token, path string) (*listenBrainzScrobbler, error) {
+	s := &listenBrainzScrobbler{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+		path:   path,
+		wake:   make(chan struct{}, 1),
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	return s, json.Unmarshal(data, &s.queue)
+}
+
+// add queues a play for submission
+func (s *listenBrainzScrobbler) add(play mediaPlayRecord) {
+	if play.Artist == "" {
+		mediaLog.Debug("Not scrobbling a track without an artist", "title", play.Title)
+		return
+	}
+	s.mu.Lock()
+	s.queue = append(s.queue, play)
+	s.saveLocked()
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run submits the queued plays when one is added and retries every interval
+func (s *listenBrainzScrobbler) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.flush(); err != nil {
+			mediaLog.Warn("Scrobbling failed, retrying later", "queued", s.queued(), "retry_in", interval, "err", err)
+		}
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// queued returns the number of plays waiting for submission
+func (s *listenBrainzScrobbler) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// flush submits the queue in batches until it is empty or a submission fails
+func (s *listenBrainzScrobbler) flush() error {
+	for {
+		s.mu.Lock()
+		batch := s.queue[:min(len(s.queue), listenBrainzBatchSize)]
+		s.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+
+		err := s.submit(batch)
+		var apiErr *listenBrainzError
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.permanent()) {
+			return err
+		}
+		if err != nil {
+			mediaLog.Warn("ListenBrainz rejected listens, dropping them", "count", len(batch), "err", err)
+		} else {
+			mediaLog.Info("Scrobbled", "count", len(batch))
+		}
+
+		// Plays are only appended, so the batch is still at the front
+		s.mu.Lock()
+		s.queue = s.queue[len(batch):]
+		s.saveLocked()
+		s.mu.Unlock()
+	}
+}
+
+// submit sends plays to the submit-listens endpoint
+func (s *listenBrainzScrobbler) submit(plays []mediaPlayRecord) error {
+	listenType := "single"
+	if len(plays) > 1 {
+		listenType = "import"
+	}
+	listens := make([]map[string]interface{}, len(plays))
+	for i, play := range plays {
+		metadata := map[string]interface{}{
+			"artist_name": play.Artist,
+			"track_name":  play.Title,
+			"additional_info": map[string]interface{}{
+				"media_player":      play.App,
+				"submission_client": "mac2mqtt",
+			},
+		}
+		if play.Album != "" {
+			metadata["release_name"] = play.Album
+		}
+		if play.Duration > 0 {
+			metadata["additional_info"].(map[string]interface{})["duration_ms"] = play.Duration * 1000
+		}
+		listens[i] = map[string]interface{}{
+			"listened_at":    play.StartedAt.Unix(),
+			"track_metadata": metadata,
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"listen_type": listenType, "payload": listens})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &listenBrainzError{Status: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// saveLocked writes the queue to its file. Caller must hold s.mu.
+func (s *listenBrainzScrobbler) saveLocked() {
+	if s.path == "" {
+		return
+	}
+	if len(s.queue) == 0 {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			mediaLog.Warn("Failed to remove the scrobble queue", "file", s.path, "err", err)
+		}
+		return
+	}
+	data, err := json.Marshal(s.queue)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0o755)
+	}
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		mediaLog.Warn("Failed to save the scrobble queue", "file", s.path, "err", err)
+	}
+}
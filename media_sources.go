@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"bessarabov/mac2mqtt/macos"
+	"bessarabov/mac2mqtt/state"
+)
+
+// mediaSourceAllowed reports whether media of the app with the bundle ID is shown,
+// according to media_include_apps and media_exclude_apps
+func (c *config) mediaSourceAllowed(bundleID string) bool {
+	if containsBundleID(c.MediaExcludeApps, bundleID) {
+		return false
+	}
+	return len(c.MediaIncludeApps) == 0 || containsBundleID(c.MediaIncludeApps, bundleID)
+}
+
+// mediaSourceRank returns the position of the app in media_source_priority, lower wins;
+// unlisted apps share the last rank
+func (c *config) mediaSourceRank(bundleID string) int {
+	if i := slices.IndexFunc(c.MediaSourcePriority, func(id string) bool { return strings.EqualFold(id, bundleID) }); i >= 0 {
+		return i
+	}
+	return len(c.MediaSourcePriority)
+}
+
+func containsBundleID(bundleIDs []string, bundleID string) bool {
+	return bundleID != "" && slices.ContainsFunc(bundleIDs, func(id string) bool { return strings.EqualFold(id, bundleID) })
+}
+
+// chooseMediaSource returns the media to show when the now playing item changes to now
+// while shown is shown. A playing app that wins over now keeps being shown until it is
+// reported again or the now playing item goes idle. media-control only reports the now
+// playing app, so the kept app is shown paused at the position it had when now was reported.
+func (c *config) chooseMediaSource(shown, now macos.MediaInfo) macos.MediaInfo {
+	allowed := c.mediaSourceAllowed(now.AppBundleID)
+	if now.State == "idle" || (allowed && strings.EqualFold(now.AppBundleID, shown.AppBundleID)) {
+		return now
+	}
+	if (shown.State == "playing" || shown.Kept) && (!allowed || c.mediaSourceRank(shown.AppBundleID) < c.mediaSourceRank(now.AppBundleID)) {
+		if !shown.Kept {
+			shown.Position = shown.LivePosition(now.PositionUpdatedAt)
+			shown.PositionUpdatedAt = now.PositionUpdatedAt
+			shown.PlaybackRate = 0
+			shown.State = "paused"
+			shown.Kept = true
+		}
+		return shown
+	}
+	if !allowed {
+		return macos.MediaInfo{State: "idle"}
+	}
+	return now
+}
+
+// updateMediaState shows the now playing item, unless another source wins over it
+func (app *Application) updateMediaState(now macos.MediaInfo) {
+	state.Update(app.store, mediaStateKey, func(shown macos.MediaInfo) macos.MediaInfo {
+		m := app.config.chooseMediaSource(shown, now)
+		if m.AppBundleID != now.AppBundleID && m.State != "idle" {
+			mediaLog.Debug("Keeping the media source that wins", "shown", m.AppBundleID, "now_playing", now.AppBundleID)
+		}
+		return m
+	})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"bessarabov/mac2mqtt/macos"
+	"bessarabov/mac2mqtt/state"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// The media-control stream and the poller both update the now playing item in the store
+// under mediaSourceKey. The media state under mediaStateKey is the item of the source that
+// wins (see chooseMediaSource); every change of it is published by publishMedia.
+//
+// Its State is one of:
+//   - idle: no app has a now playing item
+//   - playing
+//   - paused
+//   - stopped: paused at the start of the item, which is what a stop leaves behind
+
+// mediaPlaybackState returns the state of m, given whether the app reports it as playing
+func mediaPlaybackState(m macos.MediaInfo, playing bool) string {
+	switch {
+	case m.Title == "" && m.Artist == "" && m.AppName == "" && m.AppBundleID == "":
+		return "idle"
+	case playing:
+		return "playing"
+	case m.Position == 0:
+		return "stopped"
+	default:
+		return "paused"
+	}
+}
+
+// processMediaStreamUpdate merges an event of the media stream into the now playing item
+func (app *Application) processMediaStreamUpdate(mediaData map[string]interface{}) {
+	// The stream sends {"type":"data","diff":true,"payload":{...}}: a diff only has the
+	// changed fields, anything else is the complete now playing item ({} when idle)
+	payload, ok := mediaData["payload"].(map[string]interface{})
+	if !ok {
+		mediaLog.Debug("Media stream: No payload in event, skipping")
+		return
+	}
+	diff, ok := mediaData["diff"].(bool)
+	if !ok {
+		diff = true
+	}
+
+	var previous macos.MediaInfo
+	current, _ := state.Update(app.store, mediaSourceKey, func(m macos.MediaInfo) macos.MediaInfo {
+		previous = m
+		return mergeMediaPayload(m, payload, diff, time.Now())
+	})
+	// Position and artwork diffs arrive all the time; only app, track and state changes are logged at info
+	log := mediaLog.Debug
+	if current.AppBundleID != previous.AppBundleID || current.Artist != previous.Artist ||
+		current.Title != previous.Title || current.State != previous.State {
+		log = mediaLog.Info
+	}
+	log("Media stream update", "app", current.AppBundleID, "artist", current.Artist, "title", current.Title, "state", current.State)
+	app.updateMediaState(current)
+}
+
+// mergeMediaPayload applies a media-control payload received at now to m. A diff keeps
+// the fields it doesn't mention, except that a new track resets the duration and position
+// and a new album also the artwork; a full payload replaces m.
+func mergeMediaPayload(m macos.MediaInfo, payload map[string]interface{}, diff bool, now time.Time) macos.MediaInfo {
+	if !diff {
+		m = macos.MediaInfo{}
+	}
+	wasPlaying := m.State == "playing"
+	playing := wasPlaying
+	livePosition := m.LivePosition(now)
+
+	str := func(key string) (string, bool) {
+		v, ok := payload[key]
+		if !ok {
+			return "", false
+		}
+		s, _ := v.(string) // a diff sends null for removed fields
+		return s, true
+	}
+	newTrack := false
+	if s, ok := str("bundleIdentifier"); ok && s != m.AppBundleID {
+		// Another app: nothing of the previous item applies
+		m = macos.MediaInfo{AppBundleID: s, AppName: macos.AppNameForBundleID(s)}
+		newTrack = true
+	}
+	if s, ok := str("album"); ok && s != m.Album {
+		m.Artwork, m.ArtworkMimeType = nil, ""
+		newTrack = true
+	}
+	if s, ok := str("title"); ok && s != m.Title {
+		newTrack = true
+	}
+	if s, ok := str("artist"); ok && s != m.Artist {
+		newTrack = true
+	}
+	positionSet := newTrack
+	if newTrack {
+		m.Duration, m.Position = 0, 0
+	}
+
+	var reportedAt time.Time
+	rate := m.PlaybackRate
+	for k, v := range payload {
+		switch k {
+		case "title":
+			m.Title, _ = v.(string)
+		case "artist":
+			m.Artist, _ = v.(string)
+		case "album":
+			m.Album, _ = v.(string)
+		case "appName":
+			if s, ok := v.(string); ok && s != "" {
+				m.AppName = s
+			}
+		case "playing":
+			playing, _ = v.(bool)
+		case "playbackRate":
+			rate, _ = v.(float64)
+		case "artworkData":
+			// A diff sends null when the new item has no artwork
+			m.Artwork = nil
+			if s, ok := v.(string); ok && s != "" {
+				if artwork, err := base64.StdEncoding.DecodeString(s); err == nil {
+					m.Artwork = artwork
+				} else {
+					mediaLog.Warn("Invalid artwork data in media stream", "err", err)
+				}
+			}
+		case "artworkMimeType":
+			m.ArtworkMimeType, _ = v.(string)
+		case "duration", "totalTime", "totalDuration":
+			f, _ := v.(float64)
+			m.Duration = int(f)
+		case "durationMicros":
+			f, _ := v.(float64)
+			m.Duration = int(f / 1000000)
+		case "elapsedTime", "position":
+			m.Position, _ = v.(float64)
+			positionSet = true
+		case "elapsedTimeMicros", "positionMicros":
+			f, _ := v.(float64)
+			m.Position, positionSet = f/1000000, true
+		case "timestamp", "timestampEpochMicros":
+			if t, ok := macos.ParseMediaTimestamp(v); ok {
+				reportedAt = t
+			}
+		}
+	}
+
+	// Playback started or stopped without a new position: it starts or stops where it was
+	if !positionSet && playing != wasPlaying {
+		m.Position, positionSet = livePosition, true
+	}
+	if positionSet {
+		m.PositionUpdatedAt = now
+		if !reportedAt.IsZero() {
+			m.PositionUpdatedAt = reportedAt
+		}
+	}
+
+	m.State = mediaPlaybackState(m, playing)
+	m.PlaybackRate = mediaPlaybackRate(m.State, rate)
+	return m
+}
+
+// mediaPlaybackRate returns the rate the position moves at in state, given the reported rate
+func mediaPlaybackRate(state string, rate float64) float64 {
+	switch {
+	case state != "playing":
+		return 0
+	case rate > 0:
+		return rate
+	default:
+		return 1
+	}
+}
+
+// refreshMedia replaces the now playing item with media-control get
+func (app *Application) refreshMedia() {
+	info, err := app.backend.GetMediaInfo()
+	if err != nil {
+		var mcErr *macos.MediaControlError
+		if errors.As(err, &mcErr) {
+			mediaLog.Debug("Media Control is not available", "err", err)
+		} else {
+			mediaLog.Warn("Error getting media info", "err", err)
+		}
+		return
+	}
+	var current macos.MediaInfo
+	if info != nil {
+		current = *info
+	}
+	current.State = mediaPlaybackState(current, current.State == "playing")
+	current.PlaybackRate = mediaPlaybackRate(current.State, current.PlaybackRate)
+	if current.PositionUpdatedAt.IsZero() {
+		current.PositionUpdatedAt = time.Now()
+	}
+	state.Set(app.store, mediaSourceKey, current)
+	app.updateMediaState(current)
+}
+
+// publishMedia publishes the media state to all media topics: the now playing sensor,
+// the individual media sensors, the media player JSON and the artwork
+func (app *Application) publishMedia(client mqtt.Client) {
+	app.mediaMutex.Lock()
+	defer app.mediaMutex.Unlock()
+
+	// Read under the lock, so the last publisher always publishes the latest state
+	m := state.Value(app.store, mediaStateKey)
+	if m.State == "" {
+		m.State = "idle"
+	}
+	prefix := app.getTopicPrefix() + "/status/"
+
+	client.Publish(prefix+"now_playing", 0, false, m.State)
+	client.Publish(prefix+"media_state", 0, false, m.State)
+	client.Publish(prefix+"media_title", 0, false, m.Title)
+	client.Publish(prefix+"media_artist", 0, false, m.Artist)
+	client.Publish(prefix+"media_album", 0, false, m.Album)
+	client.Publish(prefix+"media_app", 0, false, m.AppName)
+	client.Publish(prefix+"media_app_bundle_id", 0, false, m.AppBundleID)
+	client.Publish(prefix+"media_duration", 0, false, strconv.Itoa(m.Duration))
+	app.publishMediaPosition(client, m)
+	app.publishMediaPlayerState(client, m)
+
+	app.publishMediaArtwork(client, m)
+	mediaLog.Debug("Published media state", "state", m.State, "artist", m.Artist, "title", m.Title)
+}
+
+// publishMediaPosition publishes the topics with the position of m: media_position,
+// now_playing_attr and media_player
+func (app *Application) publishMediaPosition(client mqtt.Client, m macos.MediaInfo) {
+	prefix := app.getTopicPrefix() + "/status/"
+	// Milliseconds are plenty, and keep the JSON readable
+	position := math.Round(m.Position*1000) / 1000
+	var updatedAt interface{}
+	if !m.PositionUpdatedAt.IsZero() {
+		updatedAt = m.PositionUpdatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	client.Publish(prefix+"media_position", 0, false, strconv.FormatFloat(position, 'f', -1, 64))
+	attr := map[string]interface{}{
+		"state":               m.State,
+		"title":               m.Title,
+		"artist":              m.Artist,
+		"album":               m.Album,
+		"app_name":            m.AppName,
+		"app_bundle_id":       m.AppBundleID,
+		"duration":            m.Duration,
+		"position":            position,
+		"position_updated_at": updatedAt,
+		"playback_rate":       m.PlaybackRate,
+	}
+	attrJSON, _ := json.Marshal(attr)
+	client.Publish(prefix+"now_playing_attr", 0, false, string(attrJSON))
+
+	attr["media_title"], attr["media_artist"], attr["media_album"] = m.Title, m.Artist, m.Album
+	playerJSON, _ := json.Marshal(attr)
+	client.Publish(prefix+"media_player", 0, false, string(playerJSON))
+}
+
+// publishLiveMediaPosition publishes the position of the playing media every interval,
+// moved on from the last reported position by the playback rate
+func (app *Application) publishLiveMediaPosition(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		client := app.getClient()
+		if client == nil || !client.IsConnected() {
+			continue
+		}
+		app.mediaMutex.Lock()
+		if m := state.Value(app.store, mediaStateKey); m.State == "playing" {
+			now := time.Now()
+			m.Position, m.PositionUpdatedAt = m.LivePosition(now), now
+			app.publishMediaPosition(client, m)
+		}
+		app.mediaMutex.Unlock()
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"bessarabov/mac2mqtt/state"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mediaStreamTimings controls the media stream supervisor. The stream only sends output
+// when something changes, so a silent stream is checked against media-control get
+// before it counts as stalled.
+type mediaStreamTimings struct {
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	stallTimeout  time.Duration // silence after which a playing stream is checked
+	checkInterval time.Duration
+	pollInterval  time.Duration // polling while the stream is down
+}
+
+// mediaStreamTiming is read when the supervisor starts; tests shorten it
+var mediaStreamTiming = mediaStreamTimings{
+	minBackoff:    time.Second,
+	maxBackoff:    time.Minute,
+	stallTimeout:  2 * time.Minute,
+	checkInterval: 30 * time.Second,
+	pollInterval:  5 * time.Second,
+}
+
+var errMediaStreamStalled = errors.New("media stream stalled")
+
+// mediaStreamHealth is the state of the media-control stream, published as a diagnostic sensor
+type mediaStreamHealth struct {
+	Connected bool   `json:"connected"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"last_error,omitempty"`
+	Polling   bool   `json:"polling"` // media info is polled while the stream is down
+}
+
+// startMediaSupervisor runs the media-control stream in the background, restarting it
+// when it ends or stalls. It is started once, however often MQTT reconnects.
+func (app *Application) startMediaSupervisor() {
+	if !app.backend.IsMediaControlAvailable() {
+		mediaLog.Info("Media Control not available - skipping media stream")
+		return
+	}
+	app.mediaStreamOnce.Do(func() {
+		go app.superviseMediaStream(mediaStreamTiming)
+		if interval := app.config.MediaPositionInterval; interval > 0 {
+			go app.publishLiveMediaPosition(time.Duration(interval * float64(time.Second)))
+		}
+	})
+}
+
+// superviseMediaStream keeps the media stream running. While it is down, media info
+// is polled; restarts back off exponentially unless the stream ran for a while.
+func (app *Application) superviseMediaStream(timing mediaStreamTimings) {
+	backoff := timing.minBackoff
+	for restarts := 0; ; restarts++ {
+		mediaLog.Info("Starting media-control stream for real-time updates...", "restarts", restarts)
+		started := time.Now()
+		err := app.runMediaStream(timing, restarts)
+		if time.Since(started) > timing.maxBackoff {
+			backoff = timing.minBackoff
+		}
+
+		mediaLog.Warn("Media stream stopped, polling until it is restarted", "err", err, "retry_in", backoff)
+		state.Set(app.store, mediaStreamKey, mediaStreamHealth{Restarts: restarts, LastError: err.Error(), Polling: true})
+		app.pollMedia(backoff, timing.pollInterval)
+		backoff = min(backoff*2, timing.maxBackoff)
+	}
+}
+
+// runMediaStream reads the media stream until it ends or stalls
+func (app *Application) runMediaStream(timing mediaStreamTimings, restarts int) error {
+	stream, err := app.backend.StartMediaStream()
+	if err != nil {
+		return fmt.Errorf("starting media-control stream: %w", err)
+	}
+	defer stream.Close()
+
+	lines := make(chan []byte)
+	done := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		done <- scanMediaStream(stream, lines, quit)
+	}()
+
+	state.Set(app.store, mediaStreamKey, mediaStreamHealth{Connected: true, Restarts: restarts})
+	mediaLog.Info("Media stream started successfully")
+
+	check := time.NewTicker(timing.checkInterval)
+	defer check.Stop()
+	lastOutput := time.Now()
+	for {
+		select {
+		case line := <-lines:
+			lastOutput = time.Now()
+			app.handleMediaStreamLine(line)
+		case err := <-done:
+			if err == nil {
+				err = io.EOF
+			}
+			return err
+		case <-check.C:
+			if time.Since(lastOutput) >= timing.stallTimeout && app.mediaStreamStalled() {
+				return errMediaStreamStalled
+			}
+		}
+	}
+}
+
+// scanMediaStream sends the lines of the stream to lines until the stream ends or quit is closed
+func scanMediaStream(stream io.Reader, lines chan<- []byte, quit <-chan struct{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("media stream reader panicked: %v", r)
+		}
+	}()
+
+	scanner := bufio.NewScanner(stream)
+	// Increase buffer size to handle long JSON lines (artwork) from media-control stream
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		select {
+		case lines <- append([]byte(nil), scanner.Bytes()...):
+		case <-quit:
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// handleMediaStreamLine merges a line of the media stream into the media state
+func (app *Application) handleMediaStreamLine(line []byte) {
+	var mediaData map[string]interface{}
+	if err := json.Unmarshal(line, &mediaData); err != nil {
+		mediaLog.Warn("Error parsing media stream JSON", "err", err)
+		return
+	}
+	app.processMediaStreamUpdate(mediaData)
+}
+
+// mediaStreamStalled reports whether the silent stream missed a change: the player
+// is playing according to the stream but media-control get disagrees
+func (app *Application) mediaStreamStalled() bool {
+	known := state.Value(app.store, mediaSourceKey)
+	if known.State != "playing" {
+		return false
+	}
+	info, err := app.backend.GetMediaInfo()
+	if err != nil {
+		return false
+	}
+	if info == nil || info.Title != known.Title || info.Artist != known.Artist {
+		mediaLog.Warn("Media stream is silent but the now playing item changed", "title", known.Title)
+		return true
+	}
+	return false
+}
+
+// pollMedia polls media-control get every interval for d, publishing what it finds
+func (app *Application) pollMedia(d, interval time.Duration) {
+	deadline := time.Now().Add(d)
+	for {
+		app.refreshMedia()
+		wait := min(interval, time.Until(deadline))
+		if wait <= 0 {
+			return
+		}
+		time.Sleep(wait)
+	}
+}
+
+// publishMediaStreamHealth publishes the state of the media stream
+func (app *Application) publishMediaStreamHealth(client mqtt.Client) {
+	health := state.Value(app.store, mediaStreamKey)
+	status := "offline"
+	if health.Connected {
+		status = "online"
+	}
+	client.Publish(app.getTopicPrefix()+"/status/media_stream", 0, true, status)
+	data, _ := json.Marshal(health)
+	client.Publish(app.getTopicPrefix()+"/status/media_stream/attributes", 0, true, string(data))
+}
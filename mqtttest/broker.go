@@ -0,0 +1,217 @@
+// Package mqtttest runs an in-process MQTT broker for tests and records every
+// message published through it
+package mqtttest
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// DefaultTimeout is how long WaitFor waits for a matching message
+const DefaultTimeout = 5 * time.Second
+
+// Message is a message seen by the broker
+type Message struct {
+	Topic    string
+	Payload  string
+	ClientID string // publishing client (the departed client for will messages)
+}
+
+// Broker is an MQTT broker listening on a random local port
+type Broker struct {
+	Server *mqtt.Server
+	Host   string
+	Port   string
+
+	mu       sync.Mutex
+	messages []Message
+	resets   int           // number of Reset calls, so waiters can rescan
+	changed  chan struct{} // closed and replaced on every new message
+}
+
+// Start runs a broker for the duration of the test
+func Start(t testing.TB) *Broker {
+	t.Helper()
+
+	server := mqtt.New(&mqtt.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, err := net.SplitHostPort(tcp.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Broker{Server: server, Host: host, Port: port, changed: make(chan struct{})}
+	err = server.Subscribe("#", 1, func(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+		b.record(Message{Topic: pk.TopicName, Payload: string(pk.Payload), ClientID: pk.Origin})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { server.Close() })
+	return b
+}
+
+func (b *Broker) record(m Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.messages = append(b.messages, m)
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Messages returns all messages seen so far, oldest first
+func (b *Broker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.messages...)
+}
+
+// Topic returns all payloads published to topic, oldest first
+func (b *Broker) Topic(topic string) []string {
+	var out []string
+	for _, m := range b.Messages() {
+		if m.Topic == topic {
+			out = append(out, m.Payload)
+		}
+	}
+	return out
+}
+
+// Last returns the latest payload published to topic
+func (b *Broker) Last(topic string) (string, bool) {
+	payloads := b.Topic(topic)
+	if len(payloads) == 0 {
+		return "", false
+	}
+	return payloads[len(payloads)-1], true
+}
+
+// Reset forgets all messages seen so far
+func (b *Broker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.messages = nil
+	b.resets++
+}
+
+// WaitFor blocks until a message on topic satisfies match (nil matches any payload)
+// and returns its payload. It fails the test after DefaultTimeout.
+// Messages seen before the call count, use Reset to only wait for new ones.
+func (b *Broker) WaitFor(t testing.TB, topic string, match func(payload string) bool) string {
+	t.Helper()
+	deadline := time.After(DefaultTimeout)
+	seen, resets := 0, -1
+	for {
+		b.mu.Lock()
+		changed := b.changed
+		if resets != b.resets {
+			seen, resets = 0, b.resets
+		}
+		pending := b.messages[seen:]
+		seen = len(b.messages)
+		b.mu.Unlock()
+
+		for _, m := range pending {
+			if topicMatches(topic, m.Topic) && (match == nil || match(m.Payload)) {
+				return m.Payload
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("timed out waiting for a matching message on %s (last: %q)", topic, b.lastPayload(topic))
+			return ""
+		}
+	}
+}
+
+// WaitForPayload waits until payload is published to topic
+func (b *Broker) WaitForPayload(t testing.TB, topic, payload string) {
+	t.Helper()
+	b.WaitFor(t, topic, func(p string) bool { return p == payload })
+}
+
+func (b *Broker) lastPayload(topic string) string {
+	p, _ := b.Last(topic)
+	return p
+}
+
+// Publish sends a message as if from another client (e.g. Home Assistant)
+func (b *Broker) Publish(t testing.TB, topic, payload string, retain bool) {
+	t.Helper()
+	if err := b.Server.Publish(topic, []byte(payload), retain, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Retained returns the retained payload for topic
+func (b *Broker) Retained(topic string) (string, bool) {
+	pk, ok := b.Server.Topics.Retained.Get(topic)
+	if !ok {
+		return "", false
+	}
+	return string(pk.Payload), true
+}
+
+// RetainedTopics returns all topics with a retained message under prefix
+func (b *Broker) RetainedTopics(prefix string) []string {
+	var out []string
+	for topic := range b.Server.Topics.Retained.GetAll() {
+		if strings.HasPrefix(topic, prefix) {
+			out = append(out, topic)
+		}
+	}
+	return out
+}
+
+// DropClients drops the connection of every connected client without a clean
+// disconnect, so their will messages fire and they have to reconnect
+func (b *Broker) DropClients() {
+	for _, cl := range b.Server.Clients.GetAll() {
+		if cl.Net.Inline {
+			continue
+		}
+		cl.Stop(net.ErrClosed)
+	}
+}
+
+// topicMatches reports whether topic matches an MQTT filter with + and # wildcards
+func topicMatches(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, part := range f {
+		if part == "#" {
+			return true
+		}
+		if i >= len(t) || (part != "+" && part != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
@@ -0,0 +1,818 @@
+// Package simulator provides a synthetic host backend for running mac2mqtt
+// without macOS, BetterDisplay, media-control or LM Studio.
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bessarabov/mac2mqtt/llm"
+	"bessarabov/mac2mqtt/logging"
+	"bessarabov/mac2mqtt/macos"
+)
+
+var simLog = logging.New("simulator")
+
+// Track is a media item played by the simulated media session
+type Track struct {
+	Title    string `yaml:"title"`
+	Artist   string `yaml:"artist"`
+	Album    string `yaml:"album"`
+	App      string `yaml:"app"`
+	BundleID string `yaml:"bundle_id"`
+	Duration int    `yaml:"duration"` // in seconds
+}
+
+// DisplayOptions describes a simulated display
+type DisplayOptions struct {
+	ID         string `yaml:"id"`
+	Name       string `yaml:"name"`
+	Brightness int    `yaml:"brightness"`
+}
+
+// ModelOptions describes a simulated LM Studio model
+type ModelOptions struct {
+	ID           string `yaml:"id"`
+	Type         string `yaml:"type"`
+	Publisher    string `yaml:"publisher"`
+	Arch         string `yaml:"arch"`
+	Quantization string `yaml:"quantization"`
+	MaxContext   int    `yaml:"max_context_length"`
+	SizeBytes    int64  `yaml:"size_bytes"`
+	Loaded       bool   `yaml:"loaded"`
+}
+
+// Options is the simulation scenario. Everything left empty gets a randomized
+// default, so an empty Options is a valid scenario.
+type Options struct {
+	Seed            int64            `yaml:"seed"`             // Random seed; 0 picks one from the clock
+	Displays        []DisplayOptions `yaml:"displays"`         // Fake BetterDisplay displays
+	Tracks          []Track          `yaml:"tracks"`           // Playlist for the fake media session
+	PauseChance     float64          `yaml:"pause_chance"`     // Chance per second of toggling pause (default: 0.01, negative disables)
+	Models          []ModelOptions   `yaml:"models"`           // Fake LM Studio models
+	LMStudioRunning *bool            `yaml:"lmstudio_running"` // Initial LM Studio server state (default: true)
+	Battery         int              `yaml:"battery"`          // Initial charge in percent (default: 100)
+	BatteryDrain    float64          `yaml:"battery_drain"`    // Percent per minute (default: 0.5)
+	ActiveSeconds   int              `yaml:"active_seconds"`   // Average length of a burst of user input (default: 20)
+	IdleSeconds     int              `yaml:"idle_seconds"`     // Average idle period between bursts (default: 40)
+	TimeScale       float64          `yaml:"time_scale"`       // Speeds up media playback and battery drain (default: 1)
+}
+
+// Backend is a simulated host. All methods are safe for concurrent use.
+type Backend struct {
+	opts  Options
+	start time.Time
+
+	mu          sync.Mutex
+	rnd         *rand.Rand
+	volume      int
+	muted       bool
+	caffeinate  bool
+	brightness  map[string]int
+	displays    []macos.Display
+	battery     float64
+	lastBattery time.Time
+	lastInput   time.Time
+	nextToggle  time.Time
+	userActive  bool
+	media       macos.MediaInfo
+	trackIndex  int
+	trackStart  time.Time // wall clock time position 0 of the current track would have been
+	pausedAt    float64   // position when paused
+	lmsRunning  bool
+	models      []llm.Model
+	netRecv     uint64
+	netSent     uint64
+	streams     []*stream
+}
+
+// stream feeds one StartMediaStream reader. Lines are queued so the
+// simulation never blocks on a slow consumer.
+type stream struct {
+	lines chan []byte
+	done  chan struct{}
+}
+
+// New creates a simulated backend for the given scenario
+func New(opts Options) *Backend {
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	if opts.PauseChance == 0 {
+		opts.PauseChance = 0.01
+	}
+	if opts.Battery == 0 {
+		opts.Battery = 100
+	}
+	if opts.BatteryDrain == 0 {
+		opts.BatteryDrain = 0.5
+	}
+	if opts.ActiveSeconds == 0 {
+		opts.ActiveSeconds = 20
+	}
+	if opts.IdleSeconds == 0 {
+		opts.IdleSeconds = 40
+	}
+	if opts.TimeScale == 0 {
+		opts.TimeScale = 1
+	}
+	if len(opts.Displays) == 0 {
+		opts.Displays = []DisplayOptions{
+			{ID: "1", Name: "Built-in Display", Brightness: 70},
+			{ID: "2", Name: "Studio Display", Brightness: 50},
+		}
+	}
+	if len(opts.Tracks) == 0 {
+		opts.Tracks = defaultTracks
+	}
+	if len(opts.Models) == 0 {
+		opts.Models = defaultModels
+	}
+
+	now := time.Now()
+	b := &Backend{
+		opts:        opts,
+		start:       now,
+		rnd:         rand.New(rand.NewSource(opts.Seed)),
+		volume:      40,
+		brightness:  make(map[string]int),
+		battery:     float64(opts.Battery),
+		lastBattery: now,
+		lastInput:   now,
+		nextToggle:  now,
+		lmsRunning:  opts.LMStudioRunning == nil || *opts.LMStudioRunning,
+		netRecv:     1 << 30,
+		netSent:     1 << 28,
+	}
+
+	for _, d := range opts.Displays {
+		b.displays = append(b.displays, macos.Display{
+			DisplayID:   d.ID,
+			Name:        d.Name,
+			ProductName: d.Name,
+			UUID:        "SIM-DISPLAY-" + d.ID,
+			DeviceType:  "Display",
+			Vendor:      "Simulated",
+		})
+		b.brightness[d.ID] = d.Brightness
+	}
+
+	for _, m := range opts.Models {
+		model := llm.Model{
+			ID:                m.ID,
+			Object:            "model",
+			Type:              m.Type,
+			Publisher:         m.Publisher,
+			Arch:              m.Arch,
+			CompatibilityType: "gguf",
+			Quantization:      m.Quantization,
+			MaxContextLength:  m.MaxContext,
+			SizeBytes:         m.SizeBytes,
+			State:             "not-loaded",
+		}
+		if model.Type == "" {
+			model.Type = "llm"
+		}
+		if m.Loaded {
+			model.State = "loaded"
+		}
+		b.models = append(b.models, model)
+	}
+
+	b.startTrack(0, now)
+	go b.run()
+
+	simLog.Info("Simulation backend started", "seed", opts.Seed, "displays", len(b.displays), "tracks", len(opts.Tracks), "models", len(b.models))
+	return b
+}
+
+var defaultTracks = []Track{
+	{Title: "Blue in Green", Artist: "Miles Davis", Album: "Kind of Blue", App: "Music", BundleID: "com.apple.Music", Duration: 337},
+	{Title: "Teardrop", Artist: "Massive Attack", Album: "Mezzanine", App: "Spotify", BundleID: "com.spotify.client", Duration: 330},
+	{Title: "Weekly Standup", Artist: "", Album: "", App: "Safari", BundleID: "com.apple.Safari", Duration: 1800},
+}
+
+var defaultModels = []ModelOptions{
+	{ID: "qwen/qwen3-8b", Publisher: "qwen", Arch: "qwen3", Quantization: "Q4_K_M", MaxContext: 32768, SizeBytes: 5027782240, Loaded: true},
+	{ID: "google/gemma-3-12b", Publisher: "google", Arch: "gemma3", Quantization: "Q4_K_M", MaxContext: 131072, SizeBytes: 8149190592},
+	{ID: "text-embedding-nomic-embed-text-v1.5", Type: "embeddings", Publisher: "nomic-ai", Arch: "nomic-bert", Quantization: "Q4_K_M", MaxContext: 2048, SizeBytes: 84106624},
+}
+
+// run advances the simulation once per second
+func (b *Backend) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		b.tick(now)
+	}
+}
+
+func (b *Backend) tick(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Battery drains while unplugged and "recharges" once it gets low
+	elapsed := now.Sub(b.lastBattery).Minutes() * b.opts.TimeScale
+	b.lastBattery = now
+	b.battery -= elapsed * b.opts.BatteryDrain
+	if b.battery < 5 {
+		b.battery = 100
+	}
+
+	// User activity alternates between bursts of input and idle periods
+	if !now.Before(b.nextToggle) {
+		b.userActive = !b.userActive
+		mean := b.opts.IdleSeconds
+		if b.userActive {
+			mean = b.opts.ActiveSeconds
+		}
+		b.nextToggle = now.Add(time.Duration(mean/2+b.rnd.Intn(mean+1)) * time.Second)
+	}
+	if b.userActive {
+		b.lastInput = now
+	}
+
+	// Network counters grow at a few hundred KB/s
+	b.netRecv += uint64(b.rnd.Intn(800_000))
+	b.netSent += uint64(b.rnd.Intn(200_000))
+
+	// Media session: occasional pause toggles, next track at the end
+	if b.rnd.Float64() < b.opts.PauseChance {
+		b.togglePlayPauseLocked(now)
+		return
+	}
+	if b.media.State == "playing" && b.positionLocked(now) >= float64(b.media.Duration) {
+		b.startTrack((b.trackIndex+1)%len(b.opts.Tracks), now)
+	}
+}
+
+// startTrack switches the media session to track i. Caller must hold b.mu or be in New.
+func (b *Backend) startTrack(i int, now time.Time) {
+	t := b.opts.Tracks[i]
+	b.trackIndex = i
+	b.trackStart = now
+	b.media = macos.MediaInfo{
+		Title:       t.Title,
+		Artist:      t.Artist,
+		Album:       t.Album,
+		AppName:     t.App,
+		AppBundleID: t.BundleID,
+		State:       "playing",
+		Duration:    t.Duration,
+	}
+	b.emitLocked(b.snapshotLocked(now))
+}
+
+// snapshotLocked is the full media-control payload for the current track
+func (b *Backend) snapshotLocked(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"title":            b.media.Title,
+		"artist":           b.media.Artist,
+		"album":            b.media.Album,
+		"bundleIdentifier": b.media.AppBundleID,
+		"duration":         float64(b.media.Duration),
+		"elapsedTime":      b.positionLocked(now),
+		"timestamp":        now.Format(time.RFC3339Nano),
+		"playbackRate":     b.playbackRateLocked(),
+		"playing":          b.media.State == "playing",
+	}
+}
+
+func (b *Backend) positionLocked(now time.Time) float64 {
+	if b.media.State != "playing" {
+		return b.pausedAt
+	}
+	return now.Sub(b.trackStart).Seconds() * b.opts.TimeScale
+}
+
+// playbackRateLocked is the media-control playback rate: the time scale while playing
+func (b *Backend) playbackRateLocked() float64 {
+	if b.media.State != "playing" {
+		return 0
+	}
+	return b.opts.TimeScale
+}
+
+func (b *Backend) togglePlayPauseLocked(now time.Time) {
+	if b.media.State == "playing" {
+		b.pausedAt = b.positionLocked(now)
+		b.media.State = "paused"
+	} else {
+		b.trackStart = now.Add(-time.Duration(b.pausedAt / b.opts.TimeScale * float64(time.Second)))
+		b.media.State = "playing"
+	}
+	b.emitLocked(map[string]interface{}{
+		"playing":      b.media.State == "playing",
+		"elapsedTime":  b.positionLocked(now),
+		"timestamp":    now.Format(time.RFC3339Nano),
+		"playbackRate": b.playbackRateLocked(),
+	})
+}
+
+// emitLocked queues a media-control style diff on every open stream
+func (b *Backend) emitLocked(payload map[string]interface{}) {
+	line, err := json.Marshal(map[string]interface{}{"type": "data", "diff": true, "payload": payload})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	open := b.streams[:0]
+	for _, st := range b.streams {
+		select {
+		case <-st.done:
+			close(st.lines)
+			continue
+		case st.lines <- line:
+		default:
+			simLog.Warn("Media stream consumer is too slow, dropping update")
+		}
+		open = append(open, st)
+	}
+	b.streams = open
+}
+
+// Device information
+
+func (b *Backend) SerialNumber() string { return fmt.Sprintf("SIM%08X", uint32(b.opts.Seed)) }
+func (b *Backend) Model() string        { return "Simulated Mac" }
+
+// Audio
+
+func (b *Backend) GetVolume() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.volume
+}
+
+func (b *Backend) SetVolume(volume int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.volume = volume
+	return nil
+}
+
+func (b *Backend) GetMuteStatus() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.muted
+}
+
+func (b *Backend) SetMute(mute bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.muted = mute
+	return nil
+}
+
+// System commands only log, they never touch the host
+
+func (b *Backend) Sleep()        { simLog.Info("Simulated sleep") }
+func (b *Backend) DisplaySleep() { simLog.Info("Simulated display sleep") }
+func (b *Backend) DisplayWake()  { simLog.Info("Simulated display wake") }
+func (b *Backend) Shutdown()     { simLog.Info("Simulated shutdown") }
+func (b *Backend) Screensaver()  { simLog.Info("Simulated screensaver") }
+
+func (b *Backend) RunShortcut(shortcut string) {
+	simLog.Info("Simulated shortcut", "shortcut", shortcut)
+}
+
+func (b *Backend) KeepAwake() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.caffeinate = true
+}
+
+func (b *Backend) AllowSleep() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.caffeinate = false
+}
+
+func (b *Backend) GetCaffeinateStatus() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.caffeinate
+}
+
+// Displays
+
+func (b *Backend) IsBetterDisplayCLIAvailable() bool { return true }
+
+func (b *Backend) GetDisplays() []macos.Display {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]macos.Display(nil), b.displays...)
+}
+
+func (b *Backend) GetDisplayBrightness(displayID string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.brightness[displayID]
+	if !ok {
+		return 0, fmt.Errorf("display %s is not currently available", displayID)
+	}
+	return v, nil
+}
+
+func (b *Backend) SetDisplayBrightness(displayID string, brightness int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.brightness[displayID]; !ok {
+		return fmt.Errorf("display %s is not currently available", displayID)
+	}
+	b.brightness[displayID] = brightness
+	return nil
+}
+
+// Media
+
+func (b *Backend) IsMediaControlAvailable() bool { return true }
+
+func (b *Backend) GetMediaInfo() (*macos.MediaInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	info := b.media
+	info.Position = b.positionLocked(now)
+	info.PositionUpdatedAt = now
+	info.PlaybackRate = b.playbackRateLocked()
+	return &info, nil
+}
+
+// StartMediaStream returns a stream of media-control style JSON diffs
+func (b *Backend) StartMediaStream() (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	st := &stream{lines: make(chan []byte, 64), done: make(chan struct{})}
+	go func() {
+		defer close(st.done)
+		for line := range st.lines {
+			if _, err := w.Write(line); err != nil {
+				return // reader closed
+			}
+		}
+	}()
+
+	// Like media-control, start with the full current state
+	b.mu.Lock()
+	b.streams = append(b.streams, st)
+	b.emitLocked(b.snapshotLocked(time.Now()))
+	b.mu.Unlock()
+	return r, nil
+}
+
+func (b *Backend) PlayPause() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.togglePlayPauseLocked(time.Now())
+}
+
+func (b *Backend) Play() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.media.State != "playing" {
+		b.togglePlayPauseLocked(time.Now())
+	}
+	return nil
+}
+
+func (b *Backend) Pause() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.media.State == "playing" {
+		b.togglePlayPauseLocked(time.Now())
+	}
+	return nil
+}
+
+func (b *Backend) NextTrack() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.startTrack((b.trackIndex+1)%len(b.opts.Tracks), time.Now())
+	return nil
+}
+
+// PreviousTrack restarts the track, or goes to the previous one within its first 3 seconds
+func (b *Backend) PreviousTrack() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.positionLocked(now) >= 3 {
+		b.seekLocked(0, now)
+		return nil
+	}
+	b.startTrack((b.trackIndex+len(b.opts.Tracks)-1)%len(b.opts.Tracks), now)
+	return nil
+}
+
+func (b *Backend) StopMedia() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.media.State == "playing" {
+		b.togglePlayPauseLocked(now)
+	}
+	b.seekLocked(0, now)
+	b.media.State = "stopped"
+	return nil
+}
+
+func (b *Backend) SeekMedia(position float64) error {
+	if position < 0 {
+		return fmt.Errorf("invalid position %v", position)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seekLocked(min(position, float64(b.media.Duration)), time.Now())
+	return nil
+}
+
+func (b *Backend) seekLocked(position float64, now time.Time) {
+	if b.media.State == "playing" {
+		b.trackStart = now.Add(-time.Duration(position / b.opts.TimeScale * float64(time.Second)))
+	} else {
+		b.pausedAt = position
+	}
+	b.emitLocked(map[string]interface{}{"elapsedTime": position, "timestamp": now.Format(time.RFC3339Nano)})
+}
+
+func (b *Backend) SetShuffleMode(mode string) error {
+	if !slices.Contains(macos.ShuffleModes, mode) {
+		return fmt.Errorf("invalid shuffle mode %q", mode)
+	}
+	return nil
+}
+
+func (b *Backend) SetRepeatMode(mode string) error {
+	if !slices.Contains(macos.RepeatModes, mode) {
+		return fmt.Errorf("invalid repeat mode %q", mode)
+	}
+	return nil
+}
+
+// Monitoring
+
+func (b *Backend) GetBatteryChargePercent() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strconv.Itoa(int(b.battery))
+}
+
+func (b *Backend) GetSystemIdleTime() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(time.Since(b.lastInput).Seconds()), nil
+}
+
+func (b *Backend) GetDiskUsage() (*macos.DiskUsage, error) {
+	const total = 1 << 40
+	used := uint64(total * 62 / 100)
+	return &macos.DiskUsage{
+		Total:       total,
+		Used:        used,
+		Free:        total - used,
+		UsedPercent: 62,
+		FreePercent: 38,
+	}, nil
+}
+
+func (b *Backend) GetCPUUsage() (*macos.CPUUsage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	used := 5 + b.rnd.Float64()*30
+	return &macos.CPUUsage{UsedPercent: used, FreePercent: 100 - used}, nil
+}
+
+func (b *Backend) GetMemoryUsage() (*macos.MemoryUsage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	const total = 32 << 30
+	usedPercent := 45 + b.rnd.Float64()*10
+	used := uint64(float64(total) * usedPercent / 100)
+	return &macos.MemoryUsage{
+		Total:       total,
+		Used:        used,
+		Free:        total - used,
+		UsedPercent: usedPercent,
+		FreePercent: 100 - usedPercent,
+	}, nil
+}
+
+func (b *Backend) GetSystemUptime() (*macos.UptimeInfo, error) {
+	seconds := uint64(time.Since(b.start).Seconds()) + 3*86400
+	return &macos.UptimeInfo{
+		Seconds: seconds,
+		Human:   fmt.Sprintf("%d days, %d:%02d", seconds/86400, (seconds%86400)/3600, (seconds%3600)/60),
+	}, nil
+}
+
+func (b *Backend) GetTemperatures() (*macos.TemperatureInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &macos.TemperatureInfo{
+		CPU: 42 + b.rnd.Float64()*8,
+		GPU: 38 + b.rnd.Float64()*6,
+	}, nil
+}
+
+func (b *Backend) GetNetworkStats(lastStats *macos.NetworkStats, interval time.Duration) (*macos.NetworkStats, error) {
+	b.mu.Lock()
+	stats := &macos.NetworkStats{BytesRecv: b.netRecv, BytesSent: b.netSent}
+	b.mu.Unlock()
+
+	if lastStats != nil && interval.Seconds() > 0 {
+		stats.DownloadMBps = float64(stats.BytesRecv-lastStats.BytesRecv) / (interval.Seconds() * 1000000)
+		stats.UploadMBps = float64(stats.BytesSent-lastStats.BytesSent) / (interval.Seconds() * 1000000)
+	}
+	return stats, nil
+}
+
+func (b *Backend) GetMediaDevicesState() (bool, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Pretend the "Weekly Standup" style long tracks are video calls
+	inCall := b.media.State == "playing" && b.media.Duration >= 1800
+	return inCall, inCall, nil
+}
+
+func (b *Backend) GetPublicIP() (string, error) { return "203.0.113.10", nil }
+
+// LLM server
+
+// NewLLM returns the simulated LLM server; kind and apiURL are ignored
+func (b *Backend) NewLLM(kind, apiURL string) (llm.Backend, error) {
+	return &simulatedLLM{b: b}, nil
+}
+
+// simulatedLLM is an LM Studio-like server with the configured models
+type simulatedLLM struct {
+	b *Backend
+}
+
+func (l *simulatedLLM) Name() string    { return "LM Studio" }
+func (l *simulatedLLM) Available() bool { return true }
+
+func (l *simulatedLLM) StartServer() error {
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+	l.b.lmsRunning = true
+	return nil
+}
+
+func (l *simulatedLLM) StopServer() error {
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+	l.b.lmsRunning = false
+	return nil
+}
+
+func (l *simulatedLLM) ServerStatus() (bool, error) {
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+	return l.b.lmsRunning, nil
+}
+
+func (l *simulatedLLM) ListModels() ([]llm.Model, error) {
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+	if !l.b.lmsRunning {
+		return nil, fmt.Errorf("failed to connect to LM Studio API: server not running")
+	}
+	return append([]llm.Model(nil), l.b.models...), nil
+}
+
+func (l *simulatedLLM) LoadModel(modelID string, opts llm.LoadOptions) error {
+	simLog.Info("Simulated model load", "model", modelID, "gpu", opts.GPU, "context_length", opts.ContextLength, "ttl", opts.TTL)
+	return l.b.setModelState(modelID, llm.StateLoaded)
+}
+
+func (l *simulatedLLM) UnloadModel(modelID string) error {
+	return l.b.setModelState(modelID, llm.StateNotLoaded)
+}
+
+func (l *simulatedLLM) UnloadAllModels() error {
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+	for i := range l.b.models {
+		l.b.models[i].State = llm.StateNotLoaded
+	}
+	return nil
+}
+
+// simulatedTokenDelay is how long the simulated model takes per word when streaming
+const simulatedTokenDelay = 50 * time.Millisecond
+
+// Chat answers with a canned reply that quotes the last message.
+// Streaming requests get the reply word by word.
+func (l *simulatedLLM) Chat(ctx context.Context, request llm.ChatRequest, onDelta func(string)) (*llm.ChatResponse, error) {
+	l.b.mu.Lock()
+	running := l.b.lmsRunning
+	loaded := false
+	for _, m := range l.b.models {
+		if m.ID == request.Model && m.State == llm.StateLoaded {
+			loaded = true
+		}
+	}
+	l.b.mu.Unlock()
+	if !running {
+		return nil, fmt.Errorf("failed to send request: server not running")
+	}
+	if !loaded {
+		return nil, fmt.Errorf("API returned status 404: model %s is not loaded", request.Model)
+	}
+
+	prompt := 0
+	last := ""
+	for _, m := range request.Messages {
+		prompt += len(strings.Fields(m.Content))
+		last = m.Content
+	}
+	content := "Simulated answer from " + request.Model + " to: " + last
+	words := strings.Fields(content)
+
+	if request.Stream {
+		for i, word := range words {
+			if i > 0 {
+				word = " " + word
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(simulatedTokenDelay):
+			}
+			if onDelta != nil {
+				onDelta(word)
+			}
+		}
+	}
+
+	return &llm.ChatResponse{
+		Model:        request.Model,
+		Content:      strings.Join(words, " "),
+		FinishReason: "stop",
+		Usage:        llm.ChatUsage{PromptTokens: prompt, CompletionTokens: len(words), TotalTokens: prompt + len(words)},
+	}, nil
+}
+
+// simulatedDownloadStep is how long the simulated download takes per 10%
+const simulatedDownloadStep = 500 * time.Millisecond
+
+// Download pretends to download a 4 GB model in five seconds and adds it to the model list
+func (l *simulatedLLM) Download(ctx context.Context, modelID string, onProgress func(llm.DownloadProgress)) error {
+	l.b.mu.Lock()
+	for _, m := range l.b.models {
+		if m.ID == modelID {
+			l.b.mu.Unlock()
+			return fmt.Errorf("model %s is already downloaded", modelID)
+		}
+	}
+	l.b.mu.Unlock()
+
+	const size = 4e9
+	for percent := 10; percent <= 100; percent += 10 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(simulatedDownloadStep):
+		}
+		onProgress(llm.DownloadProgress{
+			Percent:        float64(percent),
+			BytesPerSecond: size / 10 / simulatedDownloadStep.Seconds(),
+			Status:         "downloading",
+		})
+	}
+
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+	l.b.models = append(l.b.models, llm.Model{
+		ID:                modelID,
+		Object:            "model",
+		Type:              "llm",
+		CompatibilityType: "gguf",
+		SizeBytes:         size,
+		State:             llm.StateNotLoaded,
+	})
+	return nil
+}
+
+func (b *Backend) setModelState(modelID, state string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.lmsRunning {
+		return fmt.Errorf("LM Studio server is not running")
+	}
+	for i := range b.models {
+		if b.models[i].ID == modelID {
+			b.models[i].State = state
+			return nil
+		}
+	}
+	return fmt.Errorf("model %s not found", modelID)
+}
@@ -0,0 +1,172 @@
+package simulator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"bessarabov/mac2mqtt/llm"
+)
+
+func TestDisplaysAndAudio(t *testing.T) {
+	b := New(Options{Seed: 1})
+
+	displays := b.GetDisplays()
+	if len(displays) != 2 {
+		t.Fatalf("expected 2 default displays, got %d", len(displays))
+	}
+	if err := b.SetDisplayBrightness(displays[0].DisplayID, 33); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := b.GetDisplayBrightness(displays[0].DisplayID); v != 33 {
+		t.Errorf("brightness = %d, want 33", v)
+	}
+	if _, err := b.GetDisplayBrightness("missing"); err == nil {
+		t.Error("expected error for unknown display")
+	}
+
+	b.SetVolume(70)
+	b.SetMute(true)
+	if b.GetVolume() != 70 || !b.GetMuteStatus() {
+		t.Errorf("volume/mute not kept: %d %v", b.GetVolume(), b.GetMuteStatus())
+	}
+}
+
+func TestLMStudioModels(t *testing.T) {
+	b := New(Options{Seed: 1, Models: []ModelOptions{{ID: "a"}, {ID: "b", Loaded: true}}})
+	server, err := b.NewLLM("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller, ok := server.(llm.ServerController)
+	if !ok {
+		t.Fatal("simulated LLM server should be controllable")
+	}
+
+	if err := server.LoadModel("a", llm.LoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.LoadModel("missing", llm.LoadOptions{}); err == nil {
+		t.Error("expected error for unknown model")
+	}
+	models, _ := server.ListModels()
+	for _, m := range models {
+		if m.State != "loaded" {
+			t.Errorf("model %s state = %s, want loaded", m.ID, m.State)
+		}
+	}
+
+	chat := llm.ChatRequest{Model: "a", Messages: []llm.ChatMessage{{Role: "user", Content: "hello there"}}}
+	resp, err := server.Chat(context.Background(), chat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Usage.PromptTokens != 2 || resp.Usage.TotalTokens != resp.Usage.PromptTokens+resp.Usage.CompletionTokens {
+		t.Errorf("unexpected usage %+v", resp.Usage)
+	}
+
+	server.UnloadAllModels()
+	if _, err := server.Chat(context.Background(), chat, nil); err == nil {
+		t.Error("expected chat error for an unloaded model")
+	}
+	controller.StopServer()
+	if _, err := server.ListModels(); err == nil {
+		t.Error("expected error while server is stopped")
+	}
+	controller.StartServer()
+	models, _ = server.ListModels()
+	for _, m := range models {
+		if m.State != "not-loaded" {
+			t.Errorf("model %s state = %s, want not-loaded", m.ID, m.State)
+		}
+	}
+}
+
+func TestMediaStream(t *testing.T) {
+	b := New(Options{Seed: 1, PauseChance: -1, Tracks: []Track{{Title: "Song", Artist: "Band", App: "Music", BundleID: "com.apple.Music", Duration: 120}}})
+
+	r, err := b.StartMediaStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+
+	read := func() map[string]interface{} {
+		t.Helper()
+		if !scanner.Scan() {
+			t.Fatalf("stream ended: %v", scanner.Err())
+		}
+		var msg struct {
+			Type    string                 `json:"type"`
+			Payload map[string]interface{} `json:"payload"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != "data" {
+			t.Errorf("type = %q, want data", msg.Type)
+		}
+		return msg.Payload
+	}
+
+	if p := read(); p["title"] != "Song" || p["playing"] != true {
+		t.Errorf("unexpected initial payload: %v", p)
+	}
+
+	b.PlayPause()
+	if p := read(); p["playing"] != false {
+		t.Errorf("expected pause update, got %v", p)
+	}
+	if info, _ := b.GetMediaInfo(); info == nil || info.State != "paused" || info.Title != "Song" {
+		t.Errorf("expected paused media info, got %+v", info)
+	}
+}
+
+func TestMediaTransport(t *testing.T) {
+	b := New(Options{Seed: 1, PauseChance: -1, Tracks: []Track{
+		{Title: "One", Duration: 120},
+		{Title: "Two", Duration: 200},
+	}})
+
+	if err := b.NextTrack(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := b.GetMediaInfo(); info == nil || info.Title != "Two" {
+		t.Fatalf("expected the next track, got %+v", info)
+	}
+	if err := b.SeekMedia(90); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := b.GetMediaInfo(); info.Position < 90 || info.Position > 91 {
+		t.Errorf("position after seek = %v, want 90", info.Position)
+	}
+
+	// Late in the track, previous restarts it
+	if err := b.PreviousTrack(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := b.GetMediaInfo(); info.Title != "Two" || info.Position > 1 {
+		t.Errorf("expected the track to restart, got %+v", info)
+	}
+	if err := b.PreviousTrack(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := b.GetMediaInfo(); info.Title != "One" {
+		t.Errorf("expected the previous track, got %+v", info)
+	}
+
+	if err := b.StopMedia(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := b.GetMediaInfo(); info == nil || info.State != "stopped" || info.Position != 0 {
+		t.Errorf("expected stopped media info, got %+v", info)
+	}
+	if err := b.SetShuffleMode("tracks"); err != nil {
+		t.Error(err)
+	}
+	if err := b.SetRepeatMode("album"); err == nil {
+		t.Error("expected an invalid repeat mode to be rejected")
+	}
+}
@@ -0,0 +1,205 @@
+// Package state holds the latest known value of every entity mac2mqtt tracks
+package state
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key identifies an entity in the store and fixes the type of its value
+type Key[T any] string
+
+// Entry is the latest value of an entity and the time it last changed
+type Entry struct {
+	Value     any
+	UpdatedAt time.Time
+}
+
+// Change describes an entity whose value changed
+type Change struct {
+	Key      string
+	Previous Entry // zero Entry if the entity was not set before
+	Current  Entry
+}
+
+// Subscriber is called after an entity changes. Changes are delivered one at a
+// time in the order they were made, usually on the goroutine that made the
+// change; while another goroutine is delivering, that one delivers it instead.
+// A Subscriber must not block for long.
+type Subscriber func(Change)
+
+type subscription struct {
+	prefix string
+	fn     Subscriber
+}
+
+// notification is a change waiting to be delivered to its subscribers
+type notification struct {
+	change Change
+	subs   []Subscriber
+}
+
+// Store is a concurrency-safe map of entity values with change notifications
+type Store struct {
+	mu      sync.RWMutex
+	entries map[string]Entry
+	subs    map[int]subscription
+	nextSub int
+	now     func() time.Time
+
+	pending    []notification // changes not delivered yet, oldest first
+	delivering bool           // a goroutine is delivering pending
+}
+
+// New creates an empty Store
+func New() *Store {
+	return &Store{
+		entries: make(map[string]Entry),
+		subs:    make(map[int]subscription),
+		now:     time.Now,
+	}
+}
+
+// Subscribe registers fn for changes to every key starting with prefix
+// ("" matches all keys). The returned function removes the subscription.
+func (s *Store) Subscribe(prefix string, fn Subscriber) func() {
+	s.mu.Lock()
+	id := s.nextSub
+	s.nextSub++
+	s.subs[id] = subscription{prefix: prefix, fn: fn}
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		delete(s.subs, id)
+		s.mu.Unlock()
+	}
+}
+
+// Entry returns the raw entry for key
+func (s *Store) Entry(key string) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.entries[key]
+	return e, ok
+}
+
+// Snapshot returns a copy of all entries, for exporters that need a consistent view
+func (s *Store) Snapshot() map[string]Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]Entry, len(s.entries))
+	for k, v := range s.entries {
+		out[k] = v
+	}
+	return out
+}
+
+// update applies fn to the current value of key under the lock and stores the
+// result. Subscribers are notified outside the lock when the value changed.
+func (s *Store) update(key string, fn func(old any, ok bool) any) (Entry, bool) {
+	s.mu.Lock()
+	prev, existed := s.entries[key]
+	next := fn(prev.Value, existed)
+	if existed && reflect.DeepEqual(prev.Value, next) {
+		s.mu.Unlock()
+		return prev, false
+	}
+	cur := Entry{Value: next, UpdatedAt: s.now()}
+	s.entries[key] = cur
+	s.queue(Change{Key: key, Previous: prev, Current: cur})
+	s.mu.Unlock()
+
+	s.deliver()
+	return cur, true
+}
+
+// Delete removes key from the store and notifies subscribers with a nil Current value
+func (s *Store) Delete(key string) {
+	s.mu.Lock()
+	prev, existed := s.entries[key]
+	if !existed {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.entries, key)
+	s.queue(Change{Key: key, Previous: prev, Current: Entry{UpdatedAt: s.now()}})
+	s.mu.Unlock()
+
+	s.deliver()
+}
+
+// queue adds change for the subscribers of its key; the caller holds the lock,
+// so changes are queued in the order they were made
+func (s *Store) queue(change Change) {
+	var subs []Subscriber
+	for _, sub := range s.subs {
+		if strings.HasPrefix(change.Key, sub.prefix) {
+			subs = append(subs, sub.fn)
+		}
+	}
+	if len(subs) > 0 {
+		s.pending = append(s.pending, notification{change: change, subs: subs})
+	}
+}
+
+// deliver calls the subscribers of the queued changes, outside the lock. Only one
+// goroutine delivers at a time and the others leave their changes to it, so a
+// subscriber never gets an older value after a newer one.
+func (s *Store) deliver() {
+	s.mu.Lock()
+	if s.delivering {
+		s.mu.Unlock()
+		return
+	}
+	s.delivering = true
+	for len(s.pending) > 0 {
+		n := s.pending[0]
+		s.pending = s.pending[1:]
+		s.mu.Unlock()
+		for _, fn := range n.subs {
+			fn(n.change)
+		}
+		s.mu.Lock()
+	}
+	s.delivering = false
+	s.mu.Unlock()
+}
+
+// Get returns the value stored under k and when it last changed
+func Get[T any](s *Store, k Key[T]) (T, time.Time, bool) {
+	e, ok := s.Entry(string(k))
+	if !ok {
+		var zero T
+		return zero, time.Time{}, false
+	}
+	return e.Value.(T), e.UpdatedAt, true
+}
+
+// Value returns the value stored under k, or the zero value if unset
+func Value[T any](s *Store, k Key[T]) T {
+	v, _, _ := Get(s, k)
+	return v
+}
+
+// Set stores v under k and reports whether the value changed
+func Set[T any](s *Store, k Key[T], v T) bool {
+	_, changed := s.update(string(k), func(any, bool) any { return v })
+	return changed
+}
+
+// Update atomically replaces the value under k with fn(current).
+// It returns the resulting value and whether it changed. fn must return a
+// new value rather than modifying slices or maps reachable from current.
+func Update[T any](s *Store, k Key[T], fn func(T) T) (T, bool) {
+	e, changed := s.update(string(k), func(old any, ok bool) any {
+		var cur T
+		if ok {
+			cur = old.(T)
+		}
+		return fn(cur)
+	})
+	return e.Value.(T), changed
+}
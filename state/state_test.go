@@ -0,0 +1,149 @@
+package state
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetGet(t *testing.T) {
+	s := New()
+	k := Key[int]("volume")
+
+	if _, _, ok := Get(s, k); ok {
+		t.Fatal("expected unset key")
+	}
+	if !Set(s, k, 42) {
+		t.Error("first Set should report a change")
+	}
+	if Set(s, k, 42) {
+		t.Error("setting the same value should not report a change")
+	}
+	v, at, ok := Get(s, k)
+	if !ok || v != 42 || at.IsZero() {
+		t.Errorf("Get = %v, %v, %v", v, at, ok)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := New()
+	var got []Change
+	unsubscribe := s.Subscribe("media", func(c Change) { got = append(got, c) })
+
+	Set(s, Key[string]("media_state"), "playing")
+	Set(s, Key[string]("media_state"), "playing")
+	Set(s, Key[string]("user_activity"), "active")
+	Set(s, Key[string]("media_state"), "paused")
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 changes, got %d", len(got))
+	}
+	if got[1].Previous.Value != "playing" || got[1].Current.Value != "paused" {
+		t.Errorf("unexpected change: %+v", got[1])
+	}
+
+	unsubscribe()
+	Set(s, Key[string]("media_state"), "idle")
+	if len(got) != 2 {
+		t.Error("unsubscribed callback was called")
+	}
+
+	s.Subscribe("", func(c Change) { got = append(got, c) })
+	s.Delete("media_state")
+	if last := got[len(got)-1]; last.Key != "media_state" || last.Current.Value != nil {
+		t.Errorf("unexpected delete change: %+v", last)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	type media struct {
+		Title string
+		State string
+	}
+	s := New()
+	k := Key[media]("media")
+
+	v, changed := Update(s, k, func(m media) media {
+		m.Title = "Song"
+		return m
+	})
+	if !changed || v.Title != "Song" {
+		t.Errorf("Update = %+v, %v", v, changed)
+	}
+	_, changed = Update(s, k, func(m media) media { return m })
+	if changed {
+		t.Error("identity update should not report a change")
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	s := New()
+	counter := Key[int]("counter")
+	var notified sync.Map
+	s.Subscribe("", func(c Change) { notified.Store(c.Key, c.Current.Value) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				Update(s, counter, func(v int) int { return v + 1 })
+				Set(s, Key[string](fmt.Sprintf("worker_%d", i)), fmt.Sprint(j))
+				_ = s.Snapshot()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if v := Value(s, counter); v != 800 {
+		t.Errorf("counter = %d, want 800", v)
+	}
+	if v, _ := notified.Load("worker_3"); v != "99" {
+		t.Errorf("last notification for worker_3 = %v", v)
+	}
+}
+
+func TestNotificationOrder(t *testing.T) {
+	s := New()
+	counter := Key[int]("counter")
+	var mu sync.Mutex
+	last, outOfOrder := 0, 0
+	s.Subscribe("counter", func(c Change) {
+		time.Sleep(time.Duration(rand.IntN(50)) * time.Microsecond) // slow subscribers widen any reordering
+		mu.Lock()
+		defer mu.Unlock()
+		// Every change continues from the one delivered before it
+		if previous, _ := c.Previous.Value.(int); previous != last {
+			outOfOrder++
+		}
+		last = c.Current.Value.(int)
+	})
+	// A subscriber that changes the store gets its change delivered after the current one
+	s.Subscribe("counter", func(c Change) {
+		Set(s, Key[int]("echo"), c.Current.Value.(int))
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				Update(s, counter, func(v int) int { return v + 1 })
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if outOfOrder != 0 || last != 800 {
+		t.Errorf("%d changes delivered out of order, last = %d, want 800", outOfOrder, last)
+	}
+	if v := Value(s, Key[int]("echo")); v != 800 {
+		t.Errorf("echo = %d, want 800", v)
+	}
+}